@@ -0,0 +1,619 @@
+// This package contains the djtools command-line interface.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/nateranda/djtools/engine"
+	"github.com/nateranda/djtools/lib"
+	"github.com/nateranda/djtools/rbxml"
+	_ "github.com/nateranda/djtools/serato"
+)
+
+// Exit codes returned by Run.
+const (
+	ExitOK      int = 0 // command succeeded
+	ExitError   int = 1 // command failed, like an import or export error
+	ExitUsage   int = 2 // command was used incorrectly
+	ExitInvalid int = 3 // validate found problems with the library, or diff found differences
+)
+
+const usage string = `Usage: djtools <command> [flags] [arguments]
+
+Commands:
+  convert   convert a library to another format
+  inspect   summarize the contents of a library
+  validate  check a library for problems
+  diff      compare two libraries
+  tags      write library metadata to the song file tags
+  formats   list supported formats
+
+Run 'djtools <command> -h' for the flags of a command.
+`
+
+// options contains the flags shared by every command.
+type options struct {
+	from       string
+	json       bool
+	progress   bool
+	tags       lib.TagOptions
+	readTags   bool
+	engine     engine.ImportOptions
+	rbxml      rbxml.ImportOptions
+	rbxmlOpts  rbxml.ExportOptions
+	stdout     io.Writer
+	stderr     io.Writer
+	flagSet    *flag.FlagSet
+	positional []string
+}
+
+// Run runs the djtools command with the given arguments, not including
+// the program name, and returns the exit code.
+func Run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) < 1 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "convert":
+		return runConvert(ctx, args, stdout, stderr)
+	case "inspect":
+		return runInspect(ctx, args, stdout, stderr)
+	case "validate":
+		return runValidate(ctx, args, stdout, stderr)
+	case "diff":
+		return runDiff(ctx, args, stdout, stderr)
+	case "tags":
+		return runTags(ctx, args, stdout, stderr)
+	case "formats":
+		return runFormats(args, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return ExitOK
+	}
+	fmt.Fprintf(stderr, "unknown command %q\n\n%s", command, usage)
+	return ExitUsage
+}
+
+// newOptions creates the flag set for a command with the flags shared by every command.
+func newOptions(name string, stdout io.Writer, stderr io.Writer) *options {
+	o := &options{stdout: stdout, stderr: stderr}
+	o.flagSet = flag.NewFlagSet(name, flag.ContinueOnError)
+	o.flagSet.SetOutput(stderr)
+	o.flagSet.StringVar(&o.from, "from", "", "source format, detected from the path if empty")
+	o.flagSet.BoolVar(&o.json, "json", false, "write output as JSON")
+	o.flagSet.BoolVar(&o.progress, "progress", false, "write import progress to stderr")
+	o.flagSet.BoolVar(&o.engine.ImportOriginalGrids, "engine-original-grids", false,
+		"engine: import the original beatgrids instead of the adjusted ones")
+	o.flagSet.BoolVar(&o.engine.ImportOriginalCues, "engine-original-cues", false,
+		"engine: import the original cue points instead of the adjusted ones")
+	o.flagSet.BoolVar(&o.engine.PreserveOriginalPaths, "engine-preserve-paths", false,
+		"engine: keep song paths relative to the library instead of resolving them")
+	o.flagSet.BoolVar(&o.engine.ImportWaveforms, "engine-waveforms", false,
+		"engine: import waveform analysis")
+	o.flagSet.IntVar(&o.engine.Parallelism, "engine-parallelism", 0,
+		"engine: number of songs whose performance data is decoded at once, the number of CPUs if 0")
+	o.flagSet.BoolVar(&o.rbxml.ReadAudioFiles, "rbxml-read-audio", false,
+		"rbxml: read song file headers to correct decoder offsets, instead of estimating them from the filetype")
+	o.flagSet.BoolVar(&o.readTags, "read-tags", false,
+		"fill missing song metadata from the song file tags and warn where the library disagrees with them")
+	o.flagSet.BoolVar(&o.tags.Overwrite, "overwrite-tags", false,
+		"with -read-tags, replace library metadata that disagrees with the file tags")
+	o.flagSet.BoolVar(&o.tags.Cues, "tag-cues", false,
+		"with -read-tags or the tags command, also read or write the cues, loops, and beatgrid in a djtools tag")
+	return o
+}
+
+// parse parses the arguments of a command, checking the number of positional
+// arguments. It returns -1 if the command should run, or the exit code otherwise.
+func (o *options) parse(args []string, positional int, names string) int {
+	o.flagSet.Usage = func() {
+		fmt.Fprintf(o.stderr, "Usage: djtools %s [flags] %s\n\nFlags:\n", o.flagSet.Name(), names)
+		o.flagSet.PrintDefaults()
+	}
+	err := o.flagSet.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	if err != nil {
+		return ExitUsage
+	}
+	o.positional = o.flagSet.Args()
+	if len(o.positional) != positional {
+		fmt.Fprintf(o.stderr, "expected %d arguments, got %d\n", positional, len(o.positional))
+		o.flagSet.Usage()
+		return ExitUsage
+	}
+	return -1
+}
+
+// writeProgress writes the progress of an import step to stderr.
+func (o *options) writeProgress(p lib.Progress) {
+	fmt.Fprintf(o.stderr, "\r%s: %d/%d", p.Step, p.Done, p.Total)
+	if p.Done == p.Total {
+		fmt.Fprintln(o.stderr)
+	}
+}
+
+// importer returns the importer for a format, configured with the command's flags.
+func (o *options) importer(name string) (lib.Importer, error) {
+	if name == "engine" {
+		options := o.engine
+		if o.progress {
+			options.OnProgress = o.writeProgress
+		}
+		return engine.Importer{Options: options}, nil
+	}
+	if name == "rbxml" {
+		options := o.rbxml
+		if o.progress {
+			options.OnProgress = o.writeProgress
+		}
+		return rbxml.Importer{Options: options}, nil
+	}
+	format, err := lib.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if format.Importer == nil {
+		return nil, fmt.Errorf("%w: %s can't be imported", lib.ErrUnsupported, name)
+	}
+	return format.Importer, nil
+}
+
+// exporter returns the exporter for a format, configured with the command's flags.
+func (o *options) exporter(name string) (lib.Exporter, error) {
+	if name == "rbxml" {
+		return rbxml.Exporter{Options: o.rbxmlOpts}, nil
+	}
+	format, err := lib.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if format.Exporter == nil {
+		return nil, fmt.Errorf("%w: %s can't be exported", lib.ErrUnsupported, name)
+	}
+	return format.Exporter, nil
+}
+
+// load imports the library at path, detecting its format if --from is empty,
+// and reads the song file tags if --read-tags is set.
+func (o *options) load(ctx context.Context, path string) (lib.Library, lib.Report, lib.Detection, error) {
+	library, report, detection, err := o.importLibrary(ctx, path)
+	if err != nil || !o.readTags {
+		return library, report, detection, err
+	}
+	options := o.tags
+	if o.progress {
+		options.OnProgress = o.writeProgress
+	}
+	tagReport := library.ReadTags(options)
+	report.Warnings = append(report.Warnings, tagReport.Warnings...)
+	return library, report, detection, nil
+}
+
+// importLibrary imports the library at path, detecting its format if --from is empty.
+func (o *options) importLibrary(ctx context.Context, path string) (lib.Library, lib.Report, lib.Detection, error) {
+	detection := lib.Detection{Format: o.from, Path: path}
+	if o.from == "" {
+		var err error
+		_, detection, err = lib.DetectFormat(path)
+		if err != nil {
+			return lib.Library{}, lib.Report{}, lib.Detection{}, err
+		}
+	}
+
+	importer, err := o.importer(detection.Format)
+	if err != nil {
+		return lib.Library{}, lib.Report{}, detection, err
+	}
+	if contextImporter, ok := importer.(lib.ContextImporter); ok {
+		library, report, err := contextImporter.ImportContext(ctx, detection.Path)
+		return library, report, detection, err
+	}
+	library, err := importer.Import(detection.Path)
+	return library, lib.Report{}, detection, err
+}
+
+// fail writes an error and returns the error exit code.
+func (o *options) fail(err error) int {
+	if o.json {
+		o.writeJSON(struct{ Error string }{err.Error()})
+	} else {
+		fmt.Fprintf(o.stderr, "error: %v\n", err)
+	}
+	return ExitError
+}
+
+func (o *options) writeJSON(value any) {
+	encoder := json.NewEncoder(o.stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// writeWarnings writes a report's warnings to stderr.
+func (o *options) writeWarnings(report lib.Report) {
+	for _, warning := range report.Warnings {
+		fmt.Fprintf(o.stderr, "warning: %s\n", warning)
+	}
+}
+
+// pathMappings is a repeatable flag of OLD=NEW path mappings.
+type pathMappings []lib.PathMapping
+
+func (p *pathMappings) String() string {
+	var mappings []string
+	for _, mapping := range *p {
+		mappings = append(mappings, mapping.From+"="+mapping.To)
+	}
+	return strings.Join(mappings, ",")
+}
+
+func (p *pathMappings) Set(value string) error {
+	from, to, found := strings.Cut(value, "=")
+	if !found || from == "" || to == "" {
+		return errors.New("mapping must be OLD=NEW")
+	}
+	*p = append(*p, lib.PathMapping{From: from, To: to})
+	return nil
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func runConvert(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	o := newOptions("convert", stdout, stderr)
+	var to string
+	var mappings pathMappings
+	var searchDirs stringList
+	o.flagSet.StringVar(&to, "to", "", "destination format (required)")
+	o.flagSet.BoolVar(&o.rbxmlOpts.UseUTC, "rbxml-utc", false, "rbxml: write dates in UTC instead of local time")
+	o.flagSet.BoolVar(&o.rbxmlOpts.SnapColors, "rbxml-snap-colors", false,
+		"rbxml: map hot cue and loop colors to the nearest Rekordbox color instead of keeping exact colors")
+	o.flagSet.Var(&mappings, "map-path", "rewrite song paths starting with OLD to start with NEW, as OLD=NEW (repeatable)")
+	o.flagSet.Var(&searchDirs, "search-dir", "directory to search for missing songs (repeatable)")
+	var filter string
+	var playlists stringList
+	var dropOrphans bool
+	o.flagSet.StringVar(&filter, "filter", "", "only convert songs matching a filter expression, like 'genre ~ house and bpm > 120'")
+	o.flagSet.Var(&playlists, "playlist", "only convert a playlist or folder by path, like 'Folder/Playlist' (repeatable)")
+	o.flagSet.BoolVar(&dropOrphans, "drop-orphans", false, "drop songs that aren't in any converted playlist")
+	if code := o.parse(args, 2, "SRC DST"); code >= 0 {
+		return code
+	}
+	if to == "" {
+		fmt.Fprintln(stderr, "flag -to is required")
+		o.flagSet.Usage()
+		return ExitUsage
+	}
+
+	exportReporter := &lib.Reporter{}
+	o.rbxmlOpts.ReadAudioFiles = o.rbxml.ReadAudioFiles
+	o.rbxmlOpts.OnWarning = exportReporter.Warn
+	exporter, err := o.exporter(to)
+	if err != nil {
+		return o.fail(err)
+	}
+	library, report, detection, err := o.load(ctx, o.positional[0])
+	if err != nil {
+		return o.fail(err)
+	}
+	// materialize before filtering, so songs only in smart playlists aren't dropped as orphans
+	if format, err := lib.Lookup(to); err == nil && !format.Capabilities.Smartlists {
+		err = library.MaterializeSmartPlaylists(time.Now())
+		if err != nil {
+			return o.fail(err)
+		}
+	}
+	if filter != "" || len(playlists) > 0 || dropOrphans {
+		query := lib.Query{Playlists: playlists, DropOrphans: dropOrphans}
+		if filter != "" {
+			query.Match, err = library.ParseFilter(filter)
+			if err != nil {
+				return o.fail(err)
+			}
+		}
+		library = library.Filter(query)
+	}
+	var relocations []lib.Relocation
+	if len(mappings) > 0 || len(searchDirs) > 0 {
+		relocations, err = library.Relocate(lib.RelocateOptions{
+			Mappings:   mappings,
+			SearchDirs: searchDirs,
+			OnWarning:  func(warning lib.Warning) { report.Warnings = append(report.Warnings, warning) },
+		})
+		if err != nil {
+			return o.fail(err)
+		}
+	}
+	err = exporter.Export(&library, o.positional[1])
+	if err != nil {
+		return o.fail(err)
+	}
+	report.Warnings = append(report.Warnings, exportReporter.Report.Warnings...)
+
+	if o.json {
+		o.writeJSON(struct {
+			From        string
+			To          string
+			Songs       int
+			Warnings    []lib.Warning
+			Relocations []lib.Relocation
+		}{detection.Format, to, len(library.Songs), report.Warnings, relocations})
+	} else {
+		o.writeWarnings(report)
+		for _, relocation := range relocations {
+			if relocation.Status == lib.RelocationMissing || relocation.Status == lib.RelocationAmbiguous {
+				fmt.Fprintf(stderr, "warning: song id %d (%s): file is %s\n",
+					relocation.SongID, relocation.NewPath, relocation.Status)
+			}
+		}
+		fmt.Fprintf(stdout, "converted %d songs from %s to %s\n", len(library.Songs), detection.Format, to)
+	}
+	return ExitOK
+}
+
+// summary is the output of the inspect command.
+type summary struct {
+	Format      string
+	Version     string
+	Songs       int
+	Playlists   int
+	Folders     int
+	Cues        int
+	Loops       int
+	GridMarkers int
+	Corrupt     int
+	Warnings    []lib.Warning
+}
+
+func summarize(library lib.Library, report lib.Report, detection lib.Detection) summary {
+	s := summary{
+		Format:   detection.Format,
+		Version:  detection.Version,
+		Songs:    len(library.Songs),
+		Warnings: report.Warnings,
+	}
+	for _, song := range library.Songs {
+		s.Cues += len(song.Cues)
+		s.Loops += len(song.Loops)
+		s.GridMarkers += len(song.Grid)
+	}
+	// corrupt songs are removed during import, so they're only known from the report
+	for _, warning := range report.Warnings {
+		if warning.Type == lib.WarningCorrupt {
+			s.Corrupt++
+		}
+	}
+	var countPlaylists func([]lib.Playlist)
+	countPlaylists = func(playlists []lib.Playlist) {
+		for _, playlist := range playlists {
+			if playlist.Songs == nil && playlist.SubPlaylists != nil {
+				s.Folders++
+			} else {
+				s.Playlists++
+			}
+			countPlaylists(playlist.SubPlaylists)
+		}
+	}
+	countPlaylists(library.Playlists)
+	return s
+}
+
+func runInspect(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	o := newOptions("inspect", stdout, stderr)
+	if code := o.parse(args, 1, "SRC"); code >= 0 {
+		return code
+	}
+
+	library, report, detection, err := o.load(ctx, o.positional[0])
+	if err != nil {
+		return o.fail(err)
+	}
+	s := summarize(library, report, detection)
+
+	if o.json {
+		o.writeJSON(s)
+		return ExitOK
+	}
+	o.writeWarnings(report)
+	format := s.Format
+	if s.Version != "" {
+		format += " " + s.Version
+	}
+	fmt.Fprintf(stdout, "format:       %s\n", format)
+	fmt.Fprintf(stdout, "songs:        %d\n", s.Songs)
+	fmt.Fprintf(stdout, "playlists:    %d\n", s.Playlists)
+	fmt.Fprintf(stdout, "folders:      %d\n", s.Folders)
+	fmt.Fprintf(stdout, "hot cues:     %d\n", s.Cues)
+	fmt.Fprintf(stdout, "loops:        %d\n", s.Loops)
+	fmt.Fprintf(stdout, "grid markers: %d\n", s.GridMarkers)
+	fmt.Fprintf(stdout, "corrupt:      %d\n", s.Corrupt)
+	return ExitOK
+}
+
+func runValidate(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	o := newOptions("validate", stdout, stderr)
+	if code := o.parse(args, 1, "SRC"); code >= 0 {
+		return code
+	}
+
+	library, report, _, err := o.load(ctx, o.positional[0])
+	if err != nil {
+		return o.fail(err)
+	}
+	issues := library.Validate()
+	// corrupt songs are removed during the import, so they only show up in the report
+	var corrupt []lib.Warning
+	for _, warning := range report.Warnings {
+		if warning.Type == lib.WarningCorrupt {
+			corrupt = append(corrupt, warning)
+		}
+	}
+	valid := len(issues) == 0 && len(corrupt) == 0
+
+	if o.json {
+		o.writeJSON(struct {
+			Valid   bool
+			Issues  []lib.Issue
+			Corrupt []lib.Warning
+		}{valid, issues, corrupt})
+	} else {
+		for _, warning := range corrupt {
+			fmt.Fprintln(stdout, warning)
+		}
+		for _, issue := range issues {
+			fmt.Fprintln(stdout, issue)
+		}
+		if valid {
+			fmt.Fprintln(stdout, "library is valid")
+		}
+	}
+	if !valid {
+		return ExitInvalid
+	}
+	return ExitOK
+}
+
+func runDiff(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	o := newOptions("diff", stdout, stderr)
+	var options lib.DiffOptions
+	o.flagSet.Float64Var(&options.Tolerance, "tolerance", lib.DefaultTolerance,
+		"largest difference between cue, loop and grid times to ignore, in seconds")
+	if code := o.parse(args, 2, "OLD NEW"); code >= 0 {
+		return code
+	}
+
+	a, _, _, err := o.load(ctx, o.positional[0])
+	if err != nil {
+		return o.fail(err)
+	}
+	b, _, _, err := o.load(ctx, o.positional[1])
+	if err != nil {
+		return o.fail(err)
+	}
+	diff := lib.Diff(&a, &b, options)
+
+	if o.json {
+		o.writeJSON(diff)
+	} else if diff.Empty() {
+		fmt.Fprintln(stdout, "libraries are the same")
+	} else {
+		fmt.Fprint(stdout, diff)
+	}
+	if !diff.Empty() {
+		return ExitInvalid
+	}
+	return ExitOK
+}
+
+func runTags(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	o := newOptions("tags", stdout, stderr)
+	var options lib.TagWriteOptions
+	o.flagSet.BoolVar(&options.DryRun, "dry-run", false, "only show the changes, without writing any song file")
+	o.flagSet.BoolVar(&options.Backup, "backup", false, "copy each song file to a .bak file before changing it")
+	o.flagSet.BoolVar(&options.Keys, "keys", false, "also write song keys, only for libraries where every song has been analyzed")
+	if code := o.parse(args, 1, "SRC"); code >= 0 {
+		return code
+	}
+
+	library, report, _, err := o.load(ctx, o.positional[0])
+	if err != nil {
+		return o.fail(err)
+	}
+	options.Cues = o.tags.Cues
+	if o.progress {
+		options.OnProgress = o.writeProgress
+	}
+	writes, tagReport := library.WriteTags(options)
+	report.Warnings = append(report.Warnings, tagReport.Warnings...)
+
+	if o.json {
+		o.writeJSON(struct {
+			DryRun   bool
+			Writes   []lib.TagWrite
+			Warnings []lib.Warning
+		}{options.DryRun, writes, report.Warnings})
+		return ExitOK
+	}
+	o.writeWarnings(report)
+	for _, write := range writes {
+		fmt.Fprintf(stdout, "~ song %s\n", write.Path)
+		for _, change := range write.Changes {
+			fmt.Fprintf(stdout, "    %s: %s -> %s\n", change.Field, change.Old, change.New)
+		}
+	}
+	if options.DryRun {
+		fmt.Fprintf(stdout, "%d song files would be written\n", len(writes))
+	} else {
+		fmt.Fprintf(stdout, "%d song files written\n", len(writes))
+	}
+	return ExitOK
+}
+
+func runFormats(args []string, stdout io.Writer, stderr io.Writer) int {
+	o := newOptions("formats", stdout, stderr)
+	if code := o.parse(args, 0, ""); code >= 0 {
+		return code
+	}
+
+	type formatInfo struct {
+		Name         string
+		Description  string
+		Import       bool
+		Export       bool
+		Capabilities lib.Capabilities
+	}
+	var formats []formatInfo
+	for _, format := range lib.Formats() {
+		formats = append(formats, formatInfo{
+			Name:         format.Name,
+			Description:  format.Description,
+			Import:       format.Importer != nil,
+			Export:       format.Exporter != nil,
+			Capabilities: format.Capabilities,
+		})
+	}
+
+	if o.json {
+		o.writeJSON(formats)
+		return ExitOK
+	}
+	for _, format := range formats {
+		var support []string
+		if format.Import {
+			support = append(support, "import")
+		}
+		if format.Export {
+			support = append(support, "export")
+		}
+		if support == nil {
+			support = append(support, "detect only")
+		}
+		fmt.Fprintf(stdout, "%-8s %-28s %s\n", format.Name, format.Description, strings.Join(support, ", "))
+	}
+	return ExitOK
+}
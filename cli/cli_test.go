@@ -0,0 +1,245 @@
+package cli_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nateranda/djtools/cli"
+	"github.com/nateranda/djtools/lib"
+	"github.com/nateranda/djtools/rbxml"
+	"github.com/stretchr/testify/assert"
+)
+
+var xmlDir string = filepath.Join("..", "rbxml", "testdata", "import", "xml")
+
+// memoryFormat imports and exports libraries to memory by path
+type memoryFormat map[string]lib.Library
+
+func (m memoryFormat) Import(path string) (lib.Library, error) {
+	library, exists := m[path]
+	if !exists {
+		return lib.Library{}, errors.New("not found")
+	}
+	return library, nil
+}
+
+func (m memoryFormat) Export(library *lib.Library, path string) error {
+	m[path] = *library
+	return nil
+}
+
+var memory = memoryFormat{}
+
+func init() {
+	lib.Register(lib.Format{Name: "memory", Importer: memory, Exporter: memory})
+}
+
+// run runs the cli with the given arguments and returns the exit code and output
+func run(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := cli.Run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"NoCommand", nil},
+		{"UnknownCommand", []string{"unknown"}},
+		{"MissingArguments", []string{"inspect"}},
+		{"MissingTo", []string{"convert", "src.xml", "dst.xml"}},
+		{"UnknownFlag", []string{"inspect", "--unknown", "src.xml"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, _, _ := run(test.args...)
+			assert.Equal(t, cli.ExitUsage, code, "Incorrect usage should return the usage exit code.")
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	src := filepath.Join(xmlDir, "cuesLoops.xml")
+	dst := filepath.Join(t.TempDir(), "library.xml")
+	code, stdout, _ := run("convert", "--from", "rbxml", "--to", "rbxml", "--rbxml-utc", "--json", src, dst)
+	assert.Equal(t, cli.ExitOK, code, "Valid conversion should succeed.")
+
+	var output struct {
+		From  string
+		To    string
+		Songs int
+	}
+	err := json.Unmarshal([]byte(stdout), &output)
+	assert.Nil(t, err, "JSON output should be valid.")
+	assert.Equal(t, "rbxml", output.From, "Output should contain the source format.")
+
+	library, err := rbxml.Import(dst, rbxml.ImportOptions{})
+	assert.Nil(t, err, "Converted library should be importable.")
+	assert.Equal(t, output.Songs, len(library.Songs), "Converted library should contain every song.")
+}
+
+func TestConvertRelocate(t *testing.T) {
+	src := filepath.Join(xmlDir, "songs.xml")
+	dst := filepath.Join(t.TempDir(), "library.xml")
+	code, stdout, _ := run("convert", "--to", "rbxml", "--json",
+		"--map-path", "/Users/nateranda/Music=D:\\Music", src, dst)
+	assert.Equal(t, cli.ExitOK, code, "Valid conversion should succeed.")
+
+	var output struct {
+		Relocations []lib.Relocation
+	}
+	err := json.Unmarshal([]byte(stdout), &output)
+	assert.Nil(t, err, "JSON output should be valid.")
+	assert.NotEmpty(t, output.Relocations, "Output should contain the relocations.")
+	for _, relocation := range output.Relocations {
+		assert.Regexp(t, `^D:\\Music\\`, relocation.NewPath, "Song paths should be mapped.")
+	}
+
+	code, _, _ = run("convert", "--to", "rbxml", "--map-path", "invalid", src, dst)
+	assert.Equal(t, cli.ExitUsage, code, "Invalid mapping should return the usage exit code.")
+}
+
+func TestConvertFilter(t *testing.T) {
+	src := filepath.Join(xmlDir, "nestedPlaylists.xml")
+	dst := filepath.Join(t.TempDir(), "library.xml")
+	code, _, _ := run("convert", "--to", "rbxml", "--playlist", "Folder1/Folder2", "--drop-orphans", src, dst)
+	assert.Equal(t, cli.ExitOK, code, "Valid conversion should succeed.")
+
+	library, err := rbxml.Import(dst, rbxml.ImportOptions{})
+	assert.Nil(t, err, "Converted library should be importable.")
+	assert.Len(t, library.Playlists, 1, "Only the parent folder should be converted.")
+	assert.Len(t, library.Playlists[0].SubPlaylists, 1, "Only the selected folder should be converted.")
+
+	code, _, _ = run("convert", "--to", "rbxml", "--filter", "bpm >", src, dst)
+	assert.Equal(t, cli.ExitError, code, "Invalid filter should return the error exit code.")
+}
+
+func TestConvertFilterSmartPlaylists(t *testing.T) {
+	smart := &lib.SmartPlaylist{Rules: []lib.SmartRule{{Field: "genre", Operator: lib.RuleEquals, Value: "techno"}}}
+	memory["smart"] = lib.Library{
+		Songs: []lib.Song{
+			{SongID: 1, Path: "/music/one.mp3", Genre: "House"},
+			{SongID: 2, Path: "/music/two.mp3", Genre: "Techno"},
+			{SongID: 3, Path: "/music/three.mp3", Genre: "Pop"},
+		},
+		Playlists: []lib.Playlist{{Name: "static", Songs: []int{1}}, {Name: "techno", Smart: smart}},
+	}
+	code, _, _ := run("convert", "--from", "memory", "--to", "memory", "--drop-orphans", "smart", "filtered")
+	assert.Equal(t, cli.ExitOK, code, "Valid conversion should succeed.")
+
+	library := memory["filtered"]
+	var ids []int
+	for _, song := range library.Songs {
+		ids = append(ids, song.SongID)
+	}
+	assert.Equal(t, []int{1, 2}, ids, "Songs in smart playlists should not be dropped as orphans.")
+	assert.Equal(t, []lib.Playlist{{Name: "static", Songs: []int{1}}, {Name: "techno", Songs: []int{2}}},
+		library.Playlists, "Smart playlists should be materialized.")
+}
+
+func TestConvertError(t *testing.T) {
+	code, _, stderr := run("convert", "--to", "rbxml", filepath.Join("invalid", "path"), "dst.xml")
+	assert.Equal(t, cli.ExitError, code, "Undetectable source should return the error exit code.")
+	assert.Contains(t, stderr, lib.ErrUnknownFormat.Error(), "Error should be written to stderr.")
+
+	code, _, _ = run("convert", "--to", "serato", filepath.Join(xmlDir, "songs.xml"), "dst")
+	assert.Equal(t, cli.ExitError, code, "Unsupported export should return the error exit code.")
+}
+
+func TestInspect(t *testing.T) {
+	code, stdout, _ := run("inspect", "--json", filepath.Join(xmlDir, "nestedPlaylists.xml"))
+	assert.Equal(t, cli.ExitOK, code, "Valid inspection should succeed.")
+
+	var summary struct {
+		Format    string
+		Songs     int
+		Playlists int
+		Folders   int
+	}
+	err := json.Unmarshal([]byte(stdout), &summary)
+	assert.Nil(t, err, "JSON output should be valid.")
+	assert.Equal(t, "rbxml", summary.Format, "Summary should contain the detected format.")
+	assert.Equal(t, 3, summary.Songs, "Summary should count songs.")
+	assert.Equal(t, 4, summary.Playlists, "Summary should count playlists.")
+	assert.Equal(t, 1, summary.Folders, "Summary should count folders.")
+}
+
+func TestInspectReadTags(t *testing.T) {
+	code, stdout, _ := run("inspect", "--json", "--read-tags", filepath.Join(xmlDir, "nestedPlaylists.xml"))
+	assert.Equal(t, cli.ExitOK, code, "Missing song files shouldn't stop the inspection.")
+
+	var summary struct {
+		Songs    int
+		Warnings []lib.Warning
+	}
+	err := json.Unmarshal([]byte(stdout), &summary)
+	assert.Nil(t, err, "JSON output should be valid.")
+	assert.Len(t, summary.Warnings, summary.Songs, "Every missing song file should be reported.")
+	for _, warning := range summary.Warnings {
+		assert.Equal(t, lib.WarningMissingFile, warning.Type)
+	}
+}
+
+func TestTags(t *testing.T) {
+	code, stdout, _ := run("tags", "--json", "--dry-run", filepath.Join(xmlDir, "nestedPlaylists.xml"))
+	assert.Equal(t, cli.ExitOK, code, "Missing song files shouldn't stop writing tags.")
+
+	var output struct {
+		DryRun   bool
+		Writes   []lib.TagWrite
+		Warnings []lib.Warning
+	}
+	err := json.Unmarshal([]byte(stdout), &output)
+	assert.Nil(t, err, "JSON output should be valid.")
+	assert.True(t, output.DryRun)
+	assert.Empty(t, output.Writes, "Missing song files shouldn't be written.")
+	assert.Len(t, output.Warnings, 3, "Every missing song file should be reported.")
+}
+
+func TestValidate(t *testing.T) {
+	code, _, _ := run("validate", filepath.Join(xmlDir, "playlists.xml"))
+	assert.Equal(t, cli.ExitOK, code, "Valid library should pass validation.")
+
+	// a playlist referring to a song that doesn't exist
+	data, err := os.ReadFile(filepath.Join(xmlDir, "playlists.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte(`<TRACK Key="47763673"/>`), []byte(`<TRACK Key="1000"/>`), 1)
+	path := filepath.Join(t.TempDir(), "library.xml")
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, _ := run("validate", "--json", path)
+	assert.Equal(t, cli.ExitInvalid, code, "Invalid library should return the invalid exit code.")
+	var output struct {
+		Valid  bool
+		Issues []lib.Issue
+	}
+	err = json.Unmarshal([]byte(stdout), &output)
+	assert.Nil(t, err, "JSON output should be valid.")
+	assert.False(t, output.Valid, "Invalid library should not be valid.")
+	assert.Len(t, output.Issues, 1, "Missing song should be the only issue.")
+	assert.Equal(t, lib.IssueMissingSong, output.Issues[0].Type, "Issue should be a missing song.")
+}
+
+func TestDiff(t *testing.T) {
+	code, stdout, _ := run("diff", filepath.Join(xmlDir, "playlists.xml"), filepath.Join(xmlDir, "playlists.xml"))
+	assert.Equal(t, cli.ExitOK, code, "Same libraries should have no differences.")
+	assert.Equal(t, "libraries are the same\n", stdout, "Output should say the libraries are the same.")
+
+	code, stdout, _ = run("diff", "--json", filepath.Join(xmlDir, "playlists.xml"), filepath.Join(xmlDir, "nestedPlaylists.xml"))
+	assert.Equal(t, cli.ExitInvalid, code, "Different libraries should return the invalid exit code.")
+	var diff lib.LibraryDiff
+	err := json.Unmarshal([]byte(stdout), &diff)
+	assert.Nil(t, err, "JSON output should be valid.")
+	assert.False(t, diff.Empty(), "Diff should contain the differences.")
+}
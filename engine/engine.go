@@ -4,11 +4,15 @@ package engine
 import (
 	"bytes"
 	"compress/zlib"
+	"context"
 	"database/sql"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"os"
+	"path/filepath"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/nateranda/djtools/lib"
@@ -19,9 +23,17 @@ type ImportOptions struct {
 	ImportOriginalGrids   bool
 	ImportOriginalCues    bool
 	PreserveOriginalPaths bool
+	ImportWaveforms       bool             // decode waveform and loudness analysis into Song.Waveform
+	Parallelism           int              // number of songs whose performance data is decoded at once, runtime.GOMAXPROCS(0) if 0
+	OnProgress            lib.ProgressFunc // called as songs are converted, can be nil
+	OnWarning             lib.WarningFunc  // called for each problem found with a song, can be nil
 }
 
+// driveLibraryFolder is the folder Engine creates at the root of a removable drive.
+const driveLibraryFolder string = "Engine Library"
+
 type library struct {
+	uuid               string
 	songs              []songNull
 	songHistoryList    []songHistory
 	perfData           []performanceDataEntry
@@ -51,6 +63,11 @@ type songNull struct {
 	key          sql.NullInt32
 	label        sql.NullString
 	lastEditTime sql.NullTime
+	originUuid   sql.NullString
+	originId     sql.NullInt64
+	// set when the track was changed on a standalone player after being packed
+	metadataChanged bool
+	perfDataChanged bool
 }
 
 type songHistory struct {
@@ -64,6 +81,9 @@ type performanceDataEntry struct {
 	beatDataBlob  []byte
 	quickCuesBlob []byte
 	loopsBlob     []byte
+	trackDataBlob []byte
+	overviewBlob  []byte
+	highResBlob   []byte // only present in older databases
 }
 
 type playlist struct {
@@ -108,43 +128,242 @@ type marker struct {
 	numBeats   uint32
 }
 
+// ErrBlobTooShort is returned when a blob ends before all of its data was read.
+var ErrBlobTooShort = errors.New("blob is too short")
+
+// ErrBlobLength is returned when an uncompressed blob doesn't match its length header.
+var ErrBlobLength = errors.New("uncompressed blob length does not match length header")
+
+// ErrBlobValue is returned when a blob contains a value that can't be used, like a zero sample rate.
+var ErrBlobValue = errors.New("blob contains an invalid value")
+
+// BlobError is returned when a performance data blob can't be decoded.
+type BlobError struct {
+	Blob   string // name of the blob, like beatData or quickCues
+	Offset int    // byte offset the error occurred at
+	Err    error  // underlying error, like ErrBlobTooShort
+}
+
+func (e *BlobError) Error() string {
+	return fmt.Sprintf("InvalidBlobError: %s blob at byte %d: %v", e.Blob, e.Offset, e.Err)
+}
+
+func (e *BlobError) Unwrap() error {
+	return e.Err
+}
+
+// blobReader reads values from a blob, recording the first out of bounds read
+// instead of panicking. Reads after an error return zero values.
+type blobReader struct {
+	name string
+	blob []byte
+	i    int // byte index
+	err  error
+}
+
+func newBlobReader(name string, blob []byte) *blobReader {
+	return &blobReader{name: name, blob: blob}
+}
+
+// next returns the next n bytes of the blob, or nil if there aren't enough.
+func (r *blobReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.blob)-r.i {
+		r.err = &BlobError{Blob: r.name, Offset: r.i, Err: ErrBlobTooShort}
+		return nil
+	}
+	bytes := r.blob[r.i : r.i+n]
+	r.i += n
+	return bytes
+}
+
+func (r *blobReader) skip(n int) {
+	r.next(n)
+}
+
+func (r *blobReader) byte() byte {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *blobReader) uint32(order binary.ByteOrder) uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return order.Uint32(b)
+}
+
+func (r *blobReader) uint64(order binary.ByteOrder) uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return order.Uint64(b)
+}
+
+func (r *blobReader) float64(order binary.ByteOrder) float64 {
+	return math.Float64frombits(r.uint64(order))
+}
+
+// remaining returns the number of unread bytes.
+func (r *blobReader) remaining() int {
+	return len(r.blob) - r.i
+}
+
+// invalid records an invalid value at the current byte index.
+func (r *blobReader) invalid(format string, a ...any) {
+	if r.err == nil {
+		r.err = &BlobError{Blob: r.name, Offset: r.i, Err: fmt.Errorf("%w: %s", ErrBlobValue, fmt.Sprintf(format, a...))}
+	}
+}
+
 // qUncompress uncompresses a uInt32-appended byte slice using zlib,
 // used for blobs compressed with the QT C++ library's qCompress function.
 func qUncompress(file []byte) ([]byte, error) {
 	if len(file) < 5 {
-		return nil, fmt.Errorf("error uncompressing file: blob must contain 5 or more bytes")
+		return nil, fmt.Errorf("error uncompressing file: %w", ErrBlobTooShort)
 	}
 	uncompressLength := binary.BigEndian.Uint32(file[:4])
 	buffer := bytes.NewBuffer(file[4:])
 	r, err := zlib.NewReader(buffer)
 	if err != nil {
-		return nil, fmt.Errorf("error uncompressing file: %v", err)
+		return nil, fmt.Errorf("error uncompressing file: %w", err)
 	}
 
 	defer r.Close()
 
+	// never read past the length header, so bad headers can't use unbounded memory
 	var out bytes.Buffer
-	io.Copy(&out, r)
+	_, err = io.Copy(&out, io.LimitReader(r, int64(uncompressLength)+1))
+	if err != nil {
+		return nil, fmt.Errorf("error uncompressing file: %w", err)
+	}
 
 	fileDecomp := out.Bytes()
 
 	// check if the file's uncompressed length matches the header
 	if len(fileDecomp) != int(uncompressLength) {
-		return []byte{}, errors.New("VerificationError: uncompressed file length does not match length header")
+		return []byte{}, fmt.Errorf("VerificationError: %w", ErrBlobLength)
 	} else {
 		return fileDecomp, nil
 	}
 }
 
-// Import converts an Engine database into a djtools Library struct
+func init() {
+	lib.Register(lib.Format{
+		Name:        "engine",
+		Description: "Engine DJ database",
+		Capabilities: lib.Capabilities{
+			Smartlists:          true,
+			Loops:               true,
+			MultipleGridMarkers: true,
+			Colors:              true,
+		},
+		Importer: Importer{},
+		Detect:   detect,
+	})
+}
+
+// Importer imports Engine libraries with the given options.
+// It implements lib.Importer.
+type Importer struct {
+	Options ImportOptions
+}
+
+// Import converts an Engine database into a djtools Library struct.
+func (i Importer) Import(path string) (lib.Library, error) {
+	return Import(path, i.Options)
+}
+
+// ImportContext converts an Engine database into a djtools Library struct,
+// stopping early if ctx is canceled. It implements lib.ContextImporter.
+func (i Importer) ImportContext(ctx context.Context, path string) (lib.Library, lib.Report, error) {
+	return ImportContext(ctx, path, i.Options)
+}
+
+// detect detects an Engine library folder, or a drive or music folder containing one,
+// using the schema version in the Information table.
+func detect(path string) lib.Detection {
+	path = findLibrary(path)
+	mPath := filepath.Join(path, "Database2", "m.db")
+	info, err := os.Stat(mPath)
+	if err != nil || info.IsDir() {
+		return lib.Detection{}
+	}
+	// a Database2 folder with an unreadable m.db is probably still Engine
+	detection := lib.Detection{Confidence: 0.5, Path: path}
+
+	m, err := sql.Open("sqlite3", "file:"+mPath+"?mode=ro")
+	if err != nil {
+		return detection
+	}
+	defer m.Close()
+
+	var major, minor, patch int
+	err = m.QueryRow(`SELECT schemaVersionMajor, schemaVersionMinor, schemaVersionPatch
+		FROM Information ORDER BY id LIMIT 1`).Scan(&major, &minor, &patch)
+	if err != nil {
+		return detection
+	}
+	detection.Version = fmt.Sprintf("%d.%d.%d", major, minor, patch)
+	detection.Confidence = 1
+	return detection
+}
+
+// findLibrary returns the folder containing Engine's Database2 folder.
+// path can be the library folder itself, the root of a removable drive, or
+// the folder containing Music, in which case the library is inside of an
+// Engine Library folder.
+func findLibrary(path string) string {
+	candidates := []string{
+		path,
+		filepath.Join(path, driveLibraryFolder),
+		filepath.Join(path, "Music", driveLibraryFolder), // default desktop location
+	}
+	for _, candidate := range candidates {
+		info, err := os.Stat(filepath.Join(candidate, "Database2", "m.db"))
+		if err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	// fall back to the given path so opening the database reports the error
+	return path
+}
+
+// Import converts an Engine database into a djtools Library struct.
+// path can be an Engine library folder or the root of a removable drive.
 func Import(path string, importOptions ImportOptions) (lib.Library, error) {
-	enLibrary, err := importExtract(path)
+	library, _, err := ImportContext(context.Background(), path, importOptions)
+	return library, err
+}
+
+// ImportContext converts an Engine database into a djtools Library struct,
+// stopping early if ctx is canceled. It also returns a Report of
+// the problems found with individual songs.
+func ImportContext(ctx context.Context, path string, importOptions ImportOptions) (lib.Library, lib.Report, error) {
+	reporter := newReporter(importOptions)
+	path = findLibrary(path)
+	enLibrary, err := importExtract(ctx, path, importOptions)
 	if err != nil {
-		return lib.Library{}, err
+		return lib.Library{}, reporter.Report, err
 	}
-	library, err := importConvert(enLibrary, path, importOptions)
+	library, err := importConvert(ctx, enLibrary, path, importOptions, reporter)
 	if err != nil {
-		return lib.Library{}, err
+		return lib.Library{}, reporter.Report, err
+	}
+	return library, reporter.Report, nil
+}
+
+// newReporter returns a Reporter using the callbacks in importOptions.
+func newReporter(importOptions ImportOptions) *lib.Reporter {
+	return &lib.Reporter{
+		OnProgress: importOptions.OnProgress,
+		OnWarning:  importOptions.OnWarning,
 	}
-	return library, nil
 }
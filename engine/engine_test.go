@@ -1,6 +1,7 @@
 package engine_test
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"os"
@@ -33,11 +34,17 @@ type test struct {
 // generateDatabase generates an Engine database from m.sql and hm.sql files
 func generateDatabase(t *testing.T, fixturePath string) string {
 	t.Helper()
-	tempdir := t.TempDir()
+	return generateDatabaseAt(t, fixturePath, t.TempDir())
+}
+
+// generateDatabaseAt generates an Engine database from m.sql and hm.sql files
+// inside of a given directory
+func generateDatabaseAt(t *testing.T, fixturePath string, tempdir string) string {
+	t.Helper()
 
 	//make Database2 directory inside of temp directory
 	path := filepath.Join(tempdir, "Database2")
-	os.Mkdir(path, 0755)
+	os.MkdirAll(path, 0755)
 
 	// open and populate m.db with given fixture
 	path = filepath.Join(tempdir, "Database2", "m.db")
@@ -82,6 +89,31 @@ func TestImportInvalidPath(t *testing.T) {
 		err, "Invalid path should throw an error.")
 }
 
+func TestImportRemovableDrive(t *testing.T) {
+	drive := t.TempDir()
+	libraryPath := filepath.Join(drive, "Engine Library")
+	generateDatabaseAt(t, filepath.Join(fixturesDir, "songs"), libraryPath)
+
+	library, liberr := engine.Import(drive, engine.ImportOptions{})
+	library.SortSongs()
+
+	var stub lib.Library
+	err := stub.Load(filepath.Join(stubsDir, "songs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// paths should resolve relative to the drive's Engine Library folder
+	for i, song := range stub.Songs {
+		stub.Songs[i].Path, err = filepath.Abs(filepath.Join(libraryPath, song.Path))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.Nil(t, liberr, "Valid drive import should return no errors.")
+	assert.Equal(t, stub, library, "Library should match expected output.")
+}
+
 func TestImport(t *testing.T) {
 	tests := []test{
 		{"Empty", "empty", "empty.json", false, defaultOptions},
@@ -122,3 +154,208 @@ func TestImport(t *testing.T) {
 		})
 	}
 }
+
+// packDatabase generates a packed copy of a fixture and changes it like a standalone player would
+func packDatabase(t *testing.T, fixturePath string) string {
+	t.Helper()
+	tempdir := generateDatabase(t, fixturePath)
+	m, err := sql.Open("sqlite3", filepath.Join(tempdir, "Database2", "m.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	_, err = m.Exec(`
+		INSERT INTO Pack (packId, changeLogDatabaseUuid, changeLogId, lastPackTime)
+			VALUES ('7b0c61c4-4d0f-4bd4-9a0f-0d6b0fa8b6b1', '392c48fe-556b-452a-b2eb-6255e8ca3518', 1, 1744940000);
+		UPDATE Track SET title = 'Kanashī (Edit)' WHERE id = 1;
+		UPDATE PerformanceData SET quickCues = (SELECT quickCues FROM PerformanceData WHERE trackId = 5)
+			WHERE trackId = 1;
+		UPDATE Track SET title = 'Outdated' WHERE id = 2;
+		-- set edit times after the changes so the timestamp triggers don't overwrite them
+		UPDATE Track SET lastEditTime = 1744950000,
+			isMetadataOfPackedTrackChanged = 1, isPerfomanceDataOfPackedTrackChanged = 1 WHERE id = 1;
+		UPDATE Track SET lastEditTime = 1, isMetadataOfPackedTrackChanged = 1 WHERE id = 2;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tempdir
+}
+
+func TestPendingPackChanges(t *testing.T) {
+	path := filepath.Join(fixturesDir, "alteredPerformanceData")
+
+	changes, err := engine.PendingPackChanges(generateDatabase(t, path))
+	assert.Nil(t, err, "Valid database should return no errors.")
+	assert.False(t, changes.Pending(), "Unpacked library should have no pending changes.")
+
+	changes, err = engine.PendingPackChanges(packDatabase(t, path))
+	assert.Nil(t, err, "Valid database should return no errors.")
+	assert.Equal(t, engine.PackChanges{
+		Packs: []engine.Pack{{
+			PackID:        "7b0c61c4-4d0f-4bd4-9a0f-0d6b0fa8b6b1",
+			ChangeLogUuid: "392c48fe-556b-452a-b2eb-6255e8ca3518",
+			ChangeLogID:   1,
+			LastPackTime:  1744940000,
+		}},
+		Tracks: []engine.PackedTrack{
+			{SongID: 1, OriginUuid: "392c48fe-556b-452a-b2eb-6255e8ca3518", OriginSongID: 1,
+				MetadataChanged: true, PerformanceDataChanged: true},
+			{SongID: 2, OriginUuid: "392c48fe-556b-452a-b2eb-6255e8ca3518", OriginSongID: 2,
+				MetadataChanged: true},
+		},
+	}, changes, "Pack changes should match expected output.")
+}
+
+func TestImportPackChanges(t *testing.T) {
+	path := filepath.Join(fixturesDir, "alteredPerformanceData")
+	library, merged, _, err := engine.ImportPackChanges(context.Background(),
+		generateDatabase(t, path), packDatabase(t, path), defaultOptions)
+	library.SortSongs()
+	assert.Nil(t, err, "Valid pack import should return no errors.")
+	assert.Equal(t, []int{1}, merged, "Only newer pack changes should be merged.")
+
+	var stub lib.Library
+	err = stub.Load(filepath.Join(stubsDir, "alteredPerformanceData.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.Songs[0].Title = "Kanashī (Edit)"
+	stub.Songs[0].DateModified = 1744950000
+	stub.Songs[0].Cue = stub.Songs[4].Cue
+	stub.Songs[0].Cues = stub.Songs[4].Cues
+	assert.Equal(t, stub, library, "Library should match expected output.")
+}
+
+func TestImportPackChangesReport(t *testing.T) {
+	path := filepath.Join(fixturesDir, "corruptSong")
+	var warnings []lib.Warning
+	options := defaultOptions
+	options.OnWarning = func(w lib.Warning) { warnings = append(warnings, w) }
+
+	_, _, report, err := engine.ImportPackChanges(context.Background(),
+		generateDatabase(t, path), generateDatabase(t, path), options)
+	assert.Nil(t, err, "Corrupt songs should not fail the pack import.")
+	// the corrupt song is reported once for the library and once for the pack
+	var types []lib.WarningType
+	for _, warning := range report.Warnings {
+		types = append(types, warning.Type)
+	}
+	assert.Equal(t, []lib.WarningType{lib.WarningUnknownKey, lib.WarningCorrupt, lib.WarningUnknownKey, lib.WarningCorrupt},
+		types, "Report should contain warnings for both libraries.")
+	assert.Equal(t, report.Warnings, warnings, "Warning callback should receive every warning.")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, _, err = engine.ImportPackChanges(ctx, generateDatabase(t, path), generateDatabase(t, path), options)
+	assert.ErrorIs(t, err, context.Canceled, "Canceled pack import should return the context's error.")
+}
+
+func TestImportWaveforms(t *testing.T) {
+	tempdir := generateDatabase(t, filepath.Join(fixturesDir, "songs"))
+	library, err := engine.Import(tempdir, engine.ImportOptions{
+		PreserveOriginalPaths: true,
+		ImportWaveforms:       true,
+	})
+	library.SortSongs()
+	assert.Nil(t, err, "Valid database import should return no errors.")
+
+	waveform := library.Songs[0].Waveform
+	if waveform == nil {
+		t.Fatal("Analyzed song should have a waveform.")
+	}
+	assert.Equal(t, 14, waveform.Key, "Key should match trackData.")
+	assert.Equal(t, 9812.9482421875, waveform.Overview.SamplesPerEntry, "Samples per entry should match overview header.")
+	assert.Len(t, waveform.Overview.Low, 1024, "Overview should have 1024 entries.")
+	assert.Equal(t, []uint8{0x07, 0x18, 0x03}, []uint8{
+		waveform.Overview.Low[0], waveform.Overview.Mid[0], waveform.Overview.High[0],
+	}, "First overview entry should match blob.")
+	assert.Empty(t, waveform.Detail.Low, "Newer databases have no high resolution waveform.")
+	assert.Equal(t, -1, library.Songs[2].Waveform.Key, "Unanalyzed key should be -1.")
+
+	// waveforms are only imported when asked for
+	library, _ = engine.Import(tempdir, defaultOptions)
+	assert.Nil(t, library.Songs[0].Waveform, "Waveforms should not be imported by default.")
+}
+
+func TestImportMalformedBlob(t *testing.T) {
+	tempdir := generateDatabase(t, filepath.Join(fixturesDir, "songs"))
+	m, err := sql.Open("sqlite3", filepath.Join(tempdir, "Database2", "m.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replace the first song's quickCues blob with one that can't be uncompressed
+	_, err = m.Exec(`UPDATE PerformanceData SET quickCues = X'0000006400' WHERE trackId = 1`)
+	m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	library, liberr := engine.Import(tempdir, defaultOptions)
+	library.SortSongs()
+
+	var stub lib.Library
+	err = stub.Load(filepath.Join(stubsDir, "songs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.Songs = stub.Songs[1:]
+
+	assert.Nil(t, liberr, "Malformed blobs should mark songs corrupt instead of failing.")
+	assert.Equal(t, stub, library, "Corrupt song should be removed from the library.")
+}
+
+func TestImportContextReport(t *testing.T) {
+	tempdir := generateDatabase(t, filepath.Join(fixturesDir, "corruptSong"))
+
+	var progress []lib.Progress
+	var warnings []lib.Warning
+	options := defaultOptions
+	options.OnProgress = func(p lib.Progress) { progress = append(progress, p) }
+	options.OnWarning = func(w lib.Warning) { warnings = append(warnings, w) }
+
+	_, report, err := engine.ImportContext(context.Background(), tempdir, options)
+	assert.Nil(t, err, "Corrupt songs should not fail the import.")
+	// the corrupt song was never analyzed, so its key is unknown too
+	var types []lib.WarningType
+	for _, warning := range report.Warnings {
+		types = append(types, warning.Type)
+	}
+	assert.Equal(t, []lib.WarningType{lib.WarningUnknownKey, lib.WarningCorrupt}, types,
+		"Report should contain warnings for the corrupt song.")
+	assert.Equal(t, report.Warnings, warnings, "Warning callback should receive every warning.")
+	if assert.NotEmpty(t, progress, "Progress callback should be called.") {
+		last := progress[len(progress)-1]
+		assert.Equal(t, last.Total, last.Done, "Last progress update should be complete.")
+	}
+}
+
+func TestImportContextCanceled(t *testing.T) {
+	tempdir := generateDatabase(t, filepath.Join(fixturesDir, "songs"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := engine.ImportContext(ctx, tempdir, defaultOptions)
+	assert.ErrorIs(t, err, context.Canceled, "Canceled import should return the context's error.")
+}
+
+func TestFormat(t *testing.T) {
+	tempdir := generateDatabase(t, filepath.Join(fixturesDir, "songs"))
+	format, detection, err := lib.DetectFormat(tempdir)
+	assert.Nil(t, err, "Engine library should be detected.")
+	assert.Equal(t, "engine", format.Name, "Engine library should be detected as engine.")
+	assert.Equal(t, "3.0.1", detection.Version, "Detection should read the schema version.")
+	assert.Equal(t, 1.0, detection.Confidence, "Readable schema should be detected with full confidence.")
+
+	// the default desktop location should be found from the folder containing Music
+	home := t.TempDir()
+	generateDatabaseAt(t, filepath.Join(fixturesDir, "songs"), filepath.Join(home, "Music", "Engine Library"))
+	_, detection, err = lib.DetectFormat(home)
+	assert.Nil(t, err, "Engine library should be detected inside of Music.")
+	assert.Equal(t, filepath.Join(home, "Music", "Engine Library"), detection.Path,
+		"Detection path should be the Engine Library folder.")
+
+	library, err := format.Importer.Import(tempdir)
+	assert.Nil(t, err, "Registered importer should import the library.")
+	assert.Len(t, library.Songs, 4, "Registered importer should import every song.")
+}
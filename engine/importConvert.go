@@ -1,21 +1,24 @@
 package engine
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"math"
+	"os"
 	"path/filepath"
 
 	"github.com/nateranda/djtools/lib"
 )
 
-func importConvert(enLibrary library, path string, importOptions ImportOptions) (lib.Library, error) {
+func importConvert(ctx context.Context, enLibrary library, path string, importOptions ImportOptions, reporter *lib.Reporter) (lib.Library, error) {
 	var library lib.Library
-	err := importConvertSong(&library, enLibrary.songs, path, importOptions)
+	dedupeOrigins(&enLibrary)
+	err := importConvertSong(ctx, &library, enLibrary.songs, path, importOptions, reporter)
 	if err != nil {
 		return lib.Library{}, err
 	}
-	err = importConvertPerformanceData(&library, enLibrary.perfData, importOptions)
+	err = importConvertPerformanceData(ctx, &library, enLibrary.perfData, importOptions, reporter)
 	if err != nil {
 		return lib.Library{}, err
 	}
@@ -30,9 +33,119 @@ func importConvert(enLibrary library, path string, importOptions ImportOptions)
 	return library, nil
 }
 
-func importConvertSong(library *lib.Library, songsNull []songNull, path string, importOptions ImportOptions) error {
+// dedupeOrigins removes tracks that share an origin database and origin track id
+// with an earlier track, which happens when the same track is copied onto a drive
+// from several databases. References to removed tracks point to the first track:
+// their plays are added to its history, and playlists that would list it twice
+// because of the removal keep only one entry.
+func dedupeOrigins(enLibrary *library) {
+	type origin struct {
+		uuid string
+		id   int64
+	}
+
+	originMap := make(map[origin]int)
+	remap := make(map[int]int)
+	var songs []songNull
+	for _, song := range enLibrary.songs {
+		if !song.originUuid.Valid || !song.originId.Valid {
+			songs = append(songs, song)
+			continue
+		}
+		key := origin{song.originUuid.String, song.originId.Int64}
+		if id, exists := originMap[key]; exists {
+			remap[int(song.id.Int64)] = id
+			continue
+		}
+		originMap[key] = int(song.id.Int64)
+		songs = append(songs, song)
+	}
+
+	if len(remap) == 0 {
+		return
+	}
+	enLibrary.songs = songs
+
+	// drop performance data of removed tracks
+	var perfData []performanceDataEntry
+	for _, entry := range enLibrary.perfData {
+		if _, removed := remap[entry.id]; !removed {
+			perfData = append(perfData, entry)
+		}
+	}
+	enLibrary.perfData = perfData
+
+	// merge the history of removed tracks into the remaining track
+	var history []songHistory
+	historyIndex := make(map[int]int)
+	for _, entry := range enLibrary.songHistoryList {
+		if _, removed := remap[entry.id]; !removed {
+			historyIndex[entry.id] = len(history)
+			history = append(history, entry)
+		}
+	}
+	for _, entry := range enLibrary.songHistoryList {
+		id, removed := remap[entry.id]
+		if !removed {
+			continue
+		}
+		if i, exists := historyIndex[id]; exists {
+			history[i].plays += entry.plays
+			history[i].lastPlayed = max(history[i].lastPlayed, entry.lastPlayed)
+			continue
+		}
+		entry.id = id
+		historyIndex[id] = len(history)
+		history = append(history, entry)
+	}
+	enLibrary.songHistoryList = history
+
+	// point playlist entries to the remaining track, dropping the entries that would
+	// repeat it in a playlist
+	type listed struct{ listId, trackId int }
+	listedTracks := make(map[listed]bool)
+	for _, entity := range enLibrary.playlistEntityList {
+		if _, removed := remap[entity.trackId]; !removed {
+			listedTracks[listed{entity.listId, entity.trackId}] = true
+		}
+	}
+	dropped := make(map[int]int) // dropped entity id to its next entity id
+	var entities []playlistEntity
+	for _, entity := range enLibrary.playlistEntityList {
+		if id, removed := remap[entity.trackId]; removed {
+			key := listed{entity.listId, id}
+			if listedTracks[key] {
+				dropped[entity.id] = entity.nextEntityId
+				continue
+			}
+			listedTracks[key] = true
+			entity.trackId = id
+		}
+		entities = append(entities, entity)
+	}
+	// link the remaining entries around the dropped ones, which can follow each other
+	for i := range entities {
+		next := entities[i].nextEntityId
+		for range len(dropped) {
+			skipped, isDropped := dropped[next]
+			if !isDropped {
+				break
+			}
+			next = skipped
+		}
+		entities[i].nextEntityId = next
+	}
+	enLibrary.playlistEntityList = entities
+}
+
+func importConvertSong(ctx context.Context, library *lib.Library, songsNull []songNull, path string, importOptions ImportOptions, reporter *lib.Reporter) error {
 	var err error
-	for _, song := range songsNull {
+	for i, song := range songsNull {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		reporter.Progress("songs", i+1, len(songsNull))
+
 		var songPath string
 		if importOptions.PreserveOriginalPaths {
 			songPath = song.path.String
@@ -41,6 +154,23 @@ func importConvertSong(library *lib.Library, songsNull []songNull, path string,
 			if err != nil {
 				return fmt.Errorf("error converting songs: %v", err)
 			}
+			// original paths are relative, so they can only be checked once resolved
+			if _, err := os.Stat(songPath); err != nil {
+				reporter.Warn(lib.Warning{
+					Type:    lib.WarningMissingFile,
+					SongID:  int(song.id.Int64),
+					Path:    songPath,
+					Message: fmt.Sprintf("song file not found: %v", err),
+				})
+			}
+		}
+		if song.key.Valid && (song.key.Int32 < 0 || song.key.Int32 > 23) {
+			reporter.Warn(lib.Warning{
+				Type:    lib.WarningUnknownKey,
+				SongID:  int(song.id.Int64),
+				Path:    songPath,
+				Message: fmt.Sprintf("key %d is outside the accepted range", song.key.Int32),
+			})
 		}
 		library.Songs = append(library.Songs, lib.Song{
 			SongID:       int(song.id.Int64),
@@ -68,68 +198,126 @@ func importConvertSong(library *lib.Library, songsNull []songNull, path string,
 	return nil
 }
 
-func importConvertPerformanceData(library *lib.Library, perfData []performanceDataEntry, importOptions ImportOptions) error {
+func importConvertPerformanceData(ctx context.Context, library *lib.Library, perfData []performanceDataEntry, importOptions ImportOptions, reporter *lib.Reporter) error {
 	songMap := make(map[int]*lib.Song)
 	for i, song := range library.Songs {
 		songMap[song.SongID] = &library.Songs[i]
 	}
 
-	for _, perfDataEntry := range perfData {
-		song := songMap[perfDataEntry.id]
+	// the blobs are decoded concurrently, then applied to the songs in order
+	type decoded struct {
+		data performanceData
+		err  error
+	}
+	results, err := lib.ParallelMap(ctx, len(perfData), importOptions.Parallelism, func(i int) decoded {
+		// ignore any entries for removed songs
+		if songMap[perfData[i].id] == nil {
+			return decoded{}
+		}
+		data, err := decodePerformanceData(perfData[i], importOptions)
+		return decoded{data, err}
+	}, func(done int) {
+		reporter.Progress("performanceData", done, len(perfData))
+	})
+	if err != nil {
+		return err
+	}
 
-		beatDataBlobComp := perfDataEntry.beatDataBlob
-		if beatDataBlobComp == nil {
-			fmt.Printf("Corrupt beatData blob for song id %d. Marking song corrupt...\n", perfDataEntry.id)
+	for i, perfDataEntry := range perfData {
+		song := songMap[perfDataEntry.id]
+		if song == nil {
+			continue
+		}
+		if err := results[i].err; err != nil {
 			song.Corrupt = true
+			song.CorruptReason = err.Error()
+			reporter.Warn(lib.Warning{
+				Type:    lib.WarningCorrupt,
+				SongID:  song.SongID,
+				Path:    song.Path,
+				Message: fmt.Sprintf("corrupt performance data, removing song: %v", err),
+			})
 			continue
 		}
+		results[i].data.apply(song, importOptions)
+	}
+	return nil
+}
 
-		quickCuesBlobComp := perfDataEntry.quickCuesBlob
-		loopsBlob := perfDataEntry.loopsBlob
-
-		beatDataBlob, err := qUncompress(beatDataBlobComp)
-		if err != nil {
-			return err
-		}
-		beatData, err := beatDataFromBlob(beatDataBlob)
-		if err != nil {
-			return err
-		}
+// performanceData is a song's decoded performance data.
+type performanceData struct {
+	sampleRate float64
+	grid       []lib.Marker
+	cues       cueData
+	loops      []lib.Loop
+	waveform   *lib.Waveform
+}
 
-		song.SampleRate = beatData.sampleRate
+// decodePerformanceData decodes a song's performance data blobs. It doesn't share
+// any state, so entries can be decoded concurrently.
+func decodePerformanceData(perfDataEntry performanceDataEntry, importOptions ImportOptions) (performanceData, error) {
+	if perfDataEntry.beatDataBlob == nil {
+		return performanceData{}, &BlobError{Blob: "beatData", Err: ErrBlobTooShort}
+	}
 
-		var beatgrid []marker
-		if importOptions.ImportOriginalGrids {
-			beatgrid = beatData.defaultBeatgrid
-		} else {
-			beatgrid = beatData.adjBeatgrid
-		}
+	beatDataBlob, err := qUncompress(perfDataEntry.beatDataBlob)
+	if err != nil {
+		return performanceData{}, &BlobError{Blob: "beatData", Err: err}
+	}
+	beatData, err := beatDataFromBlob(beatDataBlob)
+	if err != nil {
+		return performanceData{}, err
+	}
 
-		song.Grid = gridFromBeatData(beatData.sampleRate, beatgrid)
+	var beatgrid []marker
+	if importOptions.ImportOriginalGrids {
+		beatgrid = beatData.defaultBeatgrid
+	} else {
+		beatgrid = beatData.adjBeatgrid
+	}
 
-		quickCuesBlob, err := qUncompress(quickCuesBlobComp)
-		if err != nil {
-			return fmt.Errorf("error converting performance data: %v", err)
-		}
-		cueData, err := cuesFromBlob(beatData.sampleRate, quickCuesBlob)
-		if err != nil {
-			return err
-		}
+	grid, err := gridFromBeatData(beatData.sampleRate, beatgrid)
+	if err != nil {
+		return performanceData{}, err
+	}
 
-		if importOptions.ImportOriginalCues {
-			song.Cue = cueData.cueOriginal
-		} else {
-			song.Cue = cueData.cueModified
-		}
+	quickCuesBlob, err := qUncompress(perfDataEntry.quickCuesBlob)
+	if err != nil {
+		return performanceData{}, &BlobError{Blob: "quickCues", Err: err}
+	}
+	cueData, err := cuesFromBlob(beatData.sampleRate, quickCuesBlob)
+	if err != nil {
+		return performanceData{}, err
+	}
 
-		song.Cues = cueData.cues
+	loops, err := loopsFromBlob(beatData.sampleRate, perfDataEntry.loopsBlob)
+	if err != nil {
+		return performanceData{}, err
+	}
 
-		song.Loops, err = loopsFromBlob(beatData.sampleRate, loopsBlob)
+	var waveform *lib.Waveform
+	if importOptions.ImportWaveforms {
+		waveform, err = waveformFromBlobs(perfDataEntry)
 		if err != nil {
-			return err
+			return performanceData{}, err
 		}
 	}
-	return nil
+
+	return performanceData{beatData.sampleRate, grid, cueData, loops, waveform}, nil
+}
+
+// apply sets a song's performance data, which only happens once every blob decoded successfully.
+func (p performanceData) apply(song *lib.Song, importOptions ImportOptions) {
+	song.SampleRate = p.sampleRate
+	song.Grid = p.grid
+	if importOptions.ImportOriginalCues {
+		song.Cue = p.cues.cueOriginal
+	} else {
+		song.Cue = p.cues.cueModified
+	}
+	song.Cues = p.cues.cues
+	song.Loops = p.loops
+	song.Waveform = p.waveform
 }
 
 func importConvertHistory(library *lib.Library, songHistoryList []songHistory) {
@@ -343,99 +531,110 @@ func findFirstSongs(playlistEntityList []playlistEntity) ([]playlistEntity, erro
 	return firstSongs, nil
 }
 
+// beatgridMarkerSize is the size of a marker in a beatData blob.
+const beatgridMarkerSize int = 24
+
+// maxNegativeBeats is the most beats a beatgrid can start before the song.
+const maxNegativeBeats float64 = 1 << 16
+
 func beatDataFromBlob(blob []byte) (beatData, error) {
-	if len(blob) < 33 {
-		return beatData{}, fmt.Errorf("InvalidBlobError: beatData blob should be at least 33 bytes")
+	var data beatData
+	r := newBlobReader("beatData", blob)
+
+	// get sample rate
+	data.sampleRate = r.float64(binary.BigEndian)
+	if r.err == nil && (!(data.sampleRate > 0) || math.IsInf(data.sampleRate, 0)) {
+		r.invalid("sample rate %v", data.sampleRate)
 	}
+	r.skip(9) // skip past track length and beatgrid set byte, not needed
 
-	var beatData beatData
-	i := 0 // byte index
+	// save normal beatgrid, then adjusted beatgrid
+	data.defaultBeatgrid = beatgridFromBlob(r)
+	data.adjBeatgrid = beatgridFromBlob(r)
 
-	// get sample rate
-	beatData.sampleRate = math.Float64frombits(binary.BigEndian.Uint64(blob[i : i+8]))
-	i += 17 // skip past track length and beatgrid set byte, not needed
+	if r.err != nil {
+		return beatData{}, r.err
+	}
+	return data, nil
+}
 
-	// save normal beatgrid
-	numMarkers := binary.BigEndian.Uint64(blob[i : i+8])
-	i += 8
+// beatgridFromBlob reads a marker count followed by that many markers.
+func beatgridFromBlob(r *blobReader) []marker {
+	numMarkers := r.uint64(binary.BigEndian)
+	// check the count before looping so a bad count fails fast
+	if r.err == nil && numMarkers > uint64(r.remaining()/beatgridMarkerSize) {
+		r.err = &BlobError{Blob: r.name, Offset: r.i, Err: ErrBlobTooShort}
+	}
+	if r.err != nil {
+		return nil
+	}
 
+	var beatgrid []marker
 	for range numMarkers {
 		var marker marker
-		marker.offset = math.Float64frombits(binary.LittleEndian.Uint64(blob[i : i+8]))
-		i += 8
-		marker.beatNumber = int64(binary.LittleEndian.Uint64(blob[i : i+8]))
-		i += 8
-		marker.numBeats = binary.LittleEndian.Uint32(blob[i : i+4])
-		i += 8 // skip past unknown int32, not needed
-		beatData.defaultBeatgrid = append(beatData.defaultBeatgrid, marker)
-	}
-
-	// save adjusted beatgrid
-	numMarkers = binary.BigEndian.Uint64(blob[i : i+8])
-	i += 8
-	for range numMarkers {
-		var marker marker
-		marker.offset = math.Float64frombits(binary.LittleEndian.Uint64(blob[i : i+8]))
-		i += 8
-		marker.beatNumber = int64(binary.LittleEndian.Uint64(blob[i : i+8]))
-		i += 8
-		marker.numBeats = binary.LittleEndian.Uint32(blob[i : i+4])
-		i += 8 // skip unknown int32, not needed
-		beatData.adjBeatgrid = append(beatData.adjBeatgrid, marker)
+		marker.offset = r.float64(binary.LittleEndian)
+		marker.beatNumber = int64(r.uint64(binary.LittleEndian))
+		marker.numBeats = r.uint32(binary.LittleEndian)
+		r.skip(4) // skip past unknown int32, not needed
+		beatgrid = append(beatgrid, marker)
 	}
-
-	return beatData, nil
+	return beatgrid
 }
 
-func gridFromBeatData(sampleRate float64, enGrid []marker) []lib.Marker {
-	var grid []lib.Marker
-	for i := range len(enGrid) - 1 {
-		var marker lib.Marker
-		marker.StartPosition = enGrid[i].offset / sampleRate
-		lenMarker := enGrid[i+1].offset - enGrid[i].offset
-		marker.Bpm = sampleRate * 60 * float64(enGrid[i].numBeats) / lenMarker
-		marker.BeatNumber = int(enGrid[i].beatNumber) % 4
-		grid = append(grid, marker)
+func gridFromBeatData(sampleRate float64, enGrid []marker) ([]lib.Marker, error) {
+	if len(enGrid) == 0 {
+		return nil, nil
 	}
-
-	// other DJ software doesn't like negative grids, so this
-	// adjusts the first grid to be positive
-	if len(grid) >= 1 {
-		beatLength := 60 / grid[0].Bpm
-		for grid[0].StartPosition < 0 {
-			grid[0].StartPosition += beatLength
-			grid[0].BeatNumber = (grid[0].BeatNumber + 1) % 4
+	// Engine numbers beats from the first downbeat of the song and only supports 4/4,
+	// and each marker stores the beats until the next one
+	anchors := make([]lib.Anchor, len(enGrid))
+	beat := int(enGrid[0].beatNumber)
+	for i, marker := range enGrid {
+		anchors[i] = lib.Anchor{Beat: beat, Time: marker.offset / sampleRate}
+		beat += int(marker.numBeats)
+	}
+	grid, err := lib.AnchorsToGrid(anchors)
+	if err != nil {
+		return nil, &BlobError{Blob: "beatData", Err: fmt.Errorf("%w: %v", ErrBlobValue, err)}
+	}
+	for i, marker := range grid {
+		if math.IsInf(marker.Bpm, 0) {
+			return nil, &BlobError{Blob: "beatData", Err: fmt.Errorf("%w: beatgrid marker %d has bpm %v", ErrBlobValue, i, marker.Bpm)}
 		}
 	}
 
-	return grid
+	// grids can start before the song, which exporters handle, but a real grid
+	// never starts more than a few beats early
+	if len(grid) >= 1 && -grid[0].StartPosition*grid[0].Bpm/60 > maxNegativeBeats {
+		return nil, &BlobError{Blob: "beatData", Err: fmt.Errorf("%w: beatgrid starts %v seconds early",
+			ErrBlobValue, -grid[0].StartPosition)}
+	}
+
+	return grid, nil
 }
 
 func cuesFromBlob(sampleRate float64, blob []byte) (cueData, error) {
 	var blobCueData cueData
-	i := 8 //byte index, skipping number of cues (always 8)
+	r := newBlobReader("quickCues", blob)
+	r.skip(8) // skip number of cues (always 8)
 
 	// skip unset cues
 	for pos := range 8 {
-		labelLength := int(blob[i])
+		labelLength := int(r.byte())
 		if labelLength == 0 { // label length 0 means no cue at this position
-			i += 13
+			r.skip(12)
 			continue
 		}
-		i++
 		var cue lib.HotCue
 		cue.Position = pos + 1
-		cue.Name = string(blob[i : i+labelLength])
-		i += labelLength
-		cue.Offset = math.Float64frombits(binary.BigEndian.Uint64(blob[i:i+8])) / sampleRate
-		i += 9 // skip 1-byte alpha channel (always 255)
-		r := int(blob[i])
-		i++
-		g := int(blob[i])
-		i++
-		b := int(blob[i])
-		i++
-		color, err := lib.RgbToHex(r, g, b)
+		cue.Name = string(r.next(labelLength))
+		cue.Offset = r.float64(binary.BigEndian) / sampleRate
+		r.skip(1) // skip 1-byte alpha channel (always 255)
+		rgb := r.next(3)
+		if r.err != nil {
+			return cueData{}, r.err
+		}
+		color, err := lib.RgbToHex(int(rgb[0]), int(rgb[1]), int(rgb[2]))
 		if err != nil {
 			return cueData{}, fmt.Errorf("error extracting cues from cueData blob: %v", err)
 		}
@@ -443,40 +642,38 @@ func cuesFromBlob(sampleRate float64, blob []byte) (cueData, error) {
 		blobCueData.cues = append(blobCueData.cues, cue)
 	}
 
-	blobCueData.cueModified = math.Float64frombits(binary.BigEndian.Uint64(blob[i:i+8])) / sampleRate
-	i += 9
-	blobCueData.cueOriginal = math.Float64frombits(binary.BigEndian.Uint64(blob[i:i+8])) / sampleRate
+	blobCueData.cueModified = r.float64(binary.BigEndian) / sampleRate
+	r.skip(1)
+	blobCueData.cueOriginal = r.float64(binary.BigEndian) / sampleRate
 
+	if r.err != nil {
+		return cueData{}, r.err
+	}
 	return blobCueData, nil
 }
 
 func loopsFromBlob(sampleRate float64, blob []byte) ([]lib.Loop, error) {
 	var loops []lib.Loop
-	i := 8 // byte index, skipping number of loops (always 8)
+	r := newBlobReader("loops", blob)
+	r.skip(8) // skip number of loops (always 8)
 	for pos := range 8 {
-		labelLength := int(blob[i])
+		labelLength := int(r.byte())
 		// skip unset loops
 		if labelLength == 0 { // label length 0 means no loop at this position
-			i += 23
+			r.skip(22)
 			continue
 		}
-		i++
 		var loop lib.Loop
 		loop.Position = pos + 1
-		loop.Name = string(blob[i : i+labelLength])
-		i += labelLength
-		loop.Start = math.Float64frombits(binary.LittleEndian.Uint64(blob[i:i+8])) / sampleRate
-		i += 8
-		loop.End = math.Float64frombits(binary.LittleEndian.Uint64(blob[i:i+8])) / sampleRate
-		i += 8
-		i += 3 // skip 1-byte alpha channel (always 255) and set bytes (not needed)
-		r := int(blob[i])
-		i++
-		g := int(blob[i])
-		i++
-		b := int(blob[i])
-		i++
-		color, err := lib.RgbToHex(r, g, b)
+		loop.Name = string(r.next(labelLength))
+		loop.Start = r.float64(binary.LittleEndian) / sampleRate
+		loop.End = r.float64(binary.LittleEndian) / sampleRate
+		r.skip(3) // skip 1-byte alpha channel (always 255) and set bytes (not needed)
+		rgb := r.next(3)
+		if r.err != nil {
+			return nil, r.err
+		}
+		color, err := lib.RgbToHex(int(rgb[0]), int(rgb[1]), int(rgb[2]))
 		if err != nil {
 			return nil, fmt.Errorf("error extracting loops from loops blob: %v", err)
 		}
@@ -484,9 +681,107 @@ func loopsFromBlob(sampleRate float64, blob []byte) ([]lib.Loop, error) {
 		loops = append(loops, loop)
 	}
 
+	if r.err != nil {
+		return nil, r.err
+	}
 	return loops, nil
 }
 
+// waveformFromBlobs decodes the compressed trackData, overviewWaveFormData, and
+// highResolutionWaveFormData blobs of a performance data entry, returning nil if
+// the track hasn't been analyzed.
+func waveformFromBlobs(perfDataEntry performanceDataEntry) (*lib.Waveform, error) {
+	if perfDataEntry.trackDataBlob == nil && perfDataEntry.overviewBlob == nil {
+		return nil, nil
+	}
+	waveform := lib.Waveform{Key: -1}
+
+	if perfDataEntry.trackDataBlob != nil {
+		trackDataBlob, err := qUncompress(perfDataEntry.trackDataBlob)
+		if err != nil {
+			return nil, &BlobError{Blob: "trackData", Err: err}
+		}
+		waveform.Loudness, waveform.Key, err = trackDataFromBlob(trackDataBlob)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if perfDataEntry.overviewBlob != nil {
+		overviewBlob, err := qUncompress(perfDataEntry.overviewBlob)
+		if err != nil {
+			return nil, &BlobError{Blob: "overviewWaveFormData", Err: err}
+		}
+		waveform.Overview, err = waveformDataFromBlob("overviewWaveFormData", overviewBlob, 3)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if perfDataEntry.highResBlob != nil {
+		highResBlob, err := qUncompress(perfDataEntry.highResBlob)
+		if err != nil {
+			return nil, &BlobError{Blob: "highResolutionWaveFormData", Err: err}
+		}
+		// each entry is followed by an opacity byte for each band, not needed
+		waveform.Detail, err = waveformDataFromBlob("highResolutionWaveFormData", highResBlob, 6)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &waveform, nil
+}
+
+// trackDataFromBlob returns the average loudness and key from a trackData blob.
+func trackDataFromBlob(blob []byte) (float64, int, error) {
+	r := newBlobReader("trackData", blob)
+	r.skip(16) // skip sample rate and sample count, already in beatData
+	key := int(int32(r.uint32(binary.BigEndian)))
+	loudness := r.float64(binary.BigEndian)
+	if r.err != nil {
+		return 0, 0, r.err
+	}
+
+	if key < 0 || key > 23 {
+		key = -1
+	}
+	// unanalyzed tracks can have a NaN loudness
+	if math.IsNaN(loudness) {
+		loudness = 0
+	}
+	return loudness, key, nil
+}
+
+// waveformDataFromBlob decodes a waveform blob made of a header followed by
+// entries of entrySize bytes, the first three being the low, mid, and high bands.
+func waveformDataFromBlob(name string, blob []byte, entrySize int) (lib.WaveformData, error) {
+	var waveform lib.WaveformData
+	r := newBlobReader(name, blob)
+
+	numEntries := r.uint64(binary.BigEndian)
+	r.skip(8) // skip repeated number of entries
+	waveform.SamplesPerEntry = r.float64(binary.BigEndian)
+	if r.err == nil && numEntries > uint64(r.remaining()/entrySize) {
+		r.err = &BlobError{Blob: name, Offset: r.i, Err: ErrBlobTooShort}
+	}
+	if r.err != nil {
+		return lib.WaveformData{}, r.err
+	}
+
+	waveform.Low = make([]uint8, numEntries)
+	waveform.Mid = make([]uint8, numEntries)
+	waveform.High = make([]uint8, numEntries)
+	for j := range numEntries {
+		entry := r.next(entrySize)
+		waveform.Low[j] = entry[0]
+		waveform.Mid[j] = entry[1]
+		waveform.High[j] = entry[2]
+	}
+
+	return waveform, nil
+}
+
 func fullPathFromRelativePath(basePath string, relativePath string) (string, error) {
 	// hard to test - platform-specific
 	fullPath := filepath.Join(basePath, relativePath)
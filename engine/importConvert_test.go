@@ -0,0 +1,371 @@
+package engine
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+// qCompress compresses a blob the same way as the QT C++ library's qCompress function
+func qCompress(t testing.TB, blob []byte) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.BigEndian, uint32(len(blob)))
+	w := zlib.NewWriter(&buffer)
+	_, err := w.Write(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buffer.Bytes()
+}
+
+// seedBeatData returns a valid beatData blob with a two-marker grid
+func seedBeatData() []byte {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.BigEndian, float64(44100))
+	buffer.Write(make([]byte, 9))
+	for range 2 {
+		binary.Write(&buffer, binary.BigEndian, uint64(2))
+		binary.Write(&buffer, binary.LittleEndian, float64(-1000))
+		binary.Write(&buffer, binary.LittleEndian, int64(-1))
+		binary.Write(&buffer, binary.LittleEndian, uint32(64))
+		buffer.Write(make([]byte, 4))
+		binary.Write(&buffer, binary.LittleEndian, float64(44100*30))
+		binary.Write(&buffer, binary.LittleEndian, int64(63))
+		binary.Write(&buffer, binary.LittleEndian, uint32(0))
+		buffer.Write(make([]byte, 4))
+	}
+	return buffer.Bytes()
+}
+
+// seedQuickCues returns a valid quickCues blob with one cue
+func seedQuickCues() []byte {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.BigEndian, uint64(8))
+	buffer.WriteByte(5)
+	buffer.WriteString("Cue 1")
+	binary.Write(&buffer, binary.BigEndian, float64(44100))
+	buffer.Write([]byte{255, 0xF4, 0xD3, 0x38})
+	buffer.Write(make([]byte, 7*13))
+	binary.Write(&buffer, binary.BigEndian, float64(100))
+	buffer.WriteByte(1)
+	binary.Write(&buffer, binary.BigEndian, float64(200))
+	return buffer.Bytes()
+}
+
+// seedLoops returns a valid loops blob with one loop
+func seedLoops() []byte {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.BigEndian, uint64(8))
+	buffer.WriteByte(6)
+	buffer.WriteString("Loop 1")
+	binary.Write(&buffer, binary.LittleEndian, float64(44100))
+	binary.Write(&buffer, binary.LittleEndian, float64(88200))
+	buffer.Write([]byte{1, 1, 255, 0xF4, 0xD3, 0x38})
+	buffer.Write(make([]byte, 7*23))
+	return buffer.Bytes()
+}
+
+func TestDecodeSeeds(t *testing.T) {
+	beatData, err := beatDataFromBlob(seedBeatData())
+	assert.Nil(t, err, "Valid beatData blob should return no errors.")
+	grid, err := gridFromBeatData(beatData.sampleRate, beatData.adjBeatgrid)
+	assert.Nil(t, err, "Valid beatgrid should return no errors.")
+	assert.Len(t, grid, 1, "Beatgrid should have one marker.")
+	assert.InDelta(t, -1000/44100.0, grid[0].StartPosition, 1e-9, "Negative beatgrid should be kept.")
+	assert.Equal(t, 3, grid[0].BeatNumber, "Beat -1 should be the last beat of a bar.")
+
+	cueData, err := cuesFromBlob(44100, seedQuickCues())
+	assert.Nil(t, err, "Valid quickCues blob should return no errors.")
+	assert.Len(t, cueData.cues, 1, "Cues should have one cue.")
+
+	loops, err := loopsFromBlob(44100, seedLoops())
+	assert.Nil(t, err, "Valid loops blob should return no errors.")
+	assert.Len(t, loops, 1, "Loops should have one loop.")
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	blobs := map[string][]byte{
+		"beatData":  seedBeatData(),
+		"quickCues": seedQuickCues(),
+		"loops":     seedLoops(),
+	}
+	for name, blob := range blobs {
+		for i := range len(blob) - 1 {
+			var err error
+			switch name {
+			case "beatData":
+				_, err = beatDataFromBlob(blob[:i])
+			case "quickCues":
+				_, err = cuesFromBlob(44100, blob[:i])
+			case "loops":
+				_, err = loopsFromBlob(44100, blob[:i])
+			}
+			var blobErr *BlobError
+			if !errors.As(err, &blobErr) {
+				t.Fatalf("%s blob truncated to %d bytes should return a BlobError, got %v", name, i, err)
+			}
+			assert.Equal(t, name, blobErr.Blob, "BlobError should name the blob.")
+			assert.ErrorIs(t, err, ErrBlobTooShort, "Truncated blob should return ErrBlobTooShort.")
+		}
+	}
+}
+
+func TestImportConvertPerformanceDataMissingSong(t *testing.T) {
+	library := lib.Library{Songs: []lib.Song{{SongID: 2}}}
+	perfData := []performanceDataEntry{
+		{id: 1, beatDataBlob: qCompress(t, seedBeatData())},
+		{id: 2, beatDataBlob: qCompress(t, seedBeatData()[:40])},
+	}
+	assert.NotPanics(t, func() {
+		importConvertPerformanceData(context.Background(), &library, perfData, ImportOptions{}, &lib.Reporter{})
+	}, "Performance data for missing songs should be ignored.")
+	assert.True(t, library.Songs[0].Corrupt, "Song with a truncated blob should be marked corrupt.")
+	assert.Contains(t, library.Songs[0].CorruptReason, "beatData", "Corrupt reason should name the blob.")
+}
+
+// originSong returns a song with the given id and origin, or no origin if originUuid is empty.
+func originSong(id int, originUuid string, originId int) songNull {
+	return songNull{
+		id:         sql.NullInt64{Int64: int64(id), Valid: true},
+		originUuid: sql.NullString{String: originUuid, Valid: originUuid != ""},
+		originId:   sql.NullInt64{Int64: int64(originId), Valid: originUuid != ""},
+	}
+}
+
+func TestDedupeOrigins(t *testing.T) {
+	tests := []struct {
+		name     string
+		library  library
+		expected library
+	}{
+		{
+			name: "NoDuplicates",
+			library: library{
+				songs:              []songNull{originSong(1, "a", 1), originSong(2, "", 0), originSong(3, "b", 1)},
+				songHistoryList:    []songHistory{{1, 2, 100}, {3, 1, 200}},
+				perfData:           []performanceDataEntry{{id: 1}, {id: 3}},
+				playlistEntityList: []playlistEntity{{1, 1, 1, 2}, {2, 1, 3, 0}},
+			},
+			expected: library{
+				songs:              []songNull{originSong(1, "a", 1), originSong(2, "", 0), originSong(3, "b", 1)},
+				songHistoryList:    []songHistory{{1, 2, 100}, {3, 1, 200}},
+				perfData:           []performanceDataEntry{{id: 1}, {id: 3}},
+				playlistEntityList: []playlistEntity{{1, 1, 1, 2}, {2, 1, 3, 0}},
+			},
+		},
+		{
+			name: "MergeHistory",
+			library: library{
+				songs:           []songNull{originSong(1, "a", 1), originSong(2, "a", 1), originSong(3, "a", 1)},
+				songHistoryList: []songHistory{{1, 2, 100}, {2, 3, 300}, {3, 1, 200}},
+				perfData:        []performanceDataEntry{{id: 1}, {id: 2}, {id: 3}},
+			},
+			expected: library{
+				songs:           []songNull{originSong(1, "a", 1)},
+				songHistoryList: []songHistory{{1, 6, 300}},
+				perfData:        []performanceDataEntry{{id: 1}},
+			},
+		},
+		{
+			name: "HistoryOnlyOnDuplicate",
+			library: library{
+				songs:           []songNull{originSong(1, "a", 1), originSong(2, "a", 1)},
+				songHistoryList: []songHistory{{2, 4, 300}},
+			},
+			expected: library{
+				songs:           []songNull{originSong(1, "a", 1)},
+				songHistoryList: []songHistory{{1, 4, 300}},
+			},
+		},
+		{
+			name: "PlaylistWithOnlyDuplicate",
+			library: library{
+				songs:              []songNull{originSong(1, "a", 1), originSong(2, "a", 1), originSong(3, "", 0)},
+				playlistEntityList: []playlistEntity{{1, 1, 3, 2}, {2, 1, 2, 0}},
+			},
+			expected: library{
+				songs:              []songNull{originSong(1, "a", 1), originSong(3, "", 0)},
+				playlistEntityList: []playlistEntity{{1, 1, 3, 2}, {2, 1, 1, 0}},
+			},
+		},
+		{
+			name: "PlaylistWithBothCopies",
+			library: library{
+				songs: []songNull{originSong(1, "a", 1), originSong(2, "a", 1), originSong(3, "", 0)},
+				// list 1: 2, 1, 3 drops its first entry, list 2: 1, 2, 2, 3 drops two entries in a row
+				playlistEntityList: []playlistEntity{
+					{1, 1, 2, 2}, {2, 1, 1, 3}, {3, 1, 3, 0},
+					{4, 2, 1, 5}, {5, 2, 2, 6}, {6, 2, 2, 7}, {7, 2, 3, 0},
+				},
+			},
+			expected: library{
+				songs: []songNull{originSong(1, "a", 1), originSong(3, "", 0)},
+				playlistEntityList: []playlistEntity{
+					{2, 1, 1, 3}, {3, 1, 3, 0},
+					{4, 2, 1, 7}, {7, 2, 3, 0},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dedupeOrigins(&test.library)
+			assert.Equal(t, test.expected, test.library)
+		})
+	}
+}
+
+func TestImportConvertSongNoKeyTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	// an untagged MPEG-1 layer III frame
+	err := os.WriteFile(path, append([]byte{0xFF, 0xFB, 0x90, 0x00}, make([]byte, 413)...), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	songs := []songNull{{
+		id:    sql.NullInt64{Int64: 1, Valid: true},
+		title: sql.NullString{String: "Title", Valid: true},
+		path:  sql.NullString{String: path, Valid: true},
+	}}
+	var library lib.Library
+	err = importConvertSong(context.Background(), &library, songs, "", ImportOptions{PreserveOriginalPaths: true}, &lib.Reporter{})
+	assert.Nil(t, err)
+
+	write, err := lib.WriteSongTags(library.Songs[0], lib.TagWriteOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []lib.FieldChange{{Field: "Title", New: `"Title"`}}, write.Changes, "A missing key shouldn't be written as 8B.")
+	data, _ := os.ReadFile(path)
+	assert.NotContains(t, string(data), "TKEY")
+}
+
+// syntheticPerformanceData returns a library of n songs with performance data,
+// where every hundredth song has a truncated beatData blob.
+func syntheticPerformanceData(t testing.TB, n int) (lib.Library, []performanceDataEntry) {
+	beatData := qCompress(t, seedBeatData())
+	truncated := qCompress(t, seedBeatData()[:40])
+	quickCues := qCompress(t, seedQuickCues())
+	var library lib.Library
+	var perfData []performanceDataEntry
+	for i := range n {
+		library.Songs = append(library.Songs, lib.Song{SongID: i + 1, Filetype: "mp3"})
+		entry := performanceDataEntry{id: i + 1, beatDataBlob: beatData, quickCuesBlob: quickCues, loopsBlob: seedLoops()}
+		if i%100 == 99 {
+			entry.beatDataBlob = truncated
+		}
+		perfData = append(perfData, entry)
+	}
+	return library, perfData
+}
+
+func TestImportConvertPerformanceDataParallel(t *testing.T) {
+	serial, perfData := syntheticPerformanceData(t, 1000)
+	serialReporter := &lib.Reporter{}
+	err := importConvertPerformanceData(context.Background(), &serial, perfData, ImportOptions{Parallelism: 1}, serialReporter)
+	assert.Nil(t, err)
+
+	parallel, _ := syntheticPerformanceData(t, 1000)
+	parallelReporter := &lib.Reporter{}
+	err = importConvertPerformanceData(context.Background(), &parallel, perfData, ImportOptions{Parallelism: 8}, parallelReporter)
+	assert.Nil(t, err)
+	assert.Equal(t, serial, parallel, "Parallel decoding should give the same songs.")
+	assert.Len(t, parallelReporter.Report.Warnings, 10, "Every corrupt song should be reported.")
+	assert.Equal(t, serialReporter.Report, parallelReporter.Report, "Warnings should be in song order.")
+}
+
+func BenchmarkImportConvertPerformanceData(b *testing.B) {
+	for _, parallelism := range []int{1, 0} {
+		name := "Serial"
+		if parallelism == 0 {
+			name = "Parallel"
+		}
+		b.Run(name, func(b *testing.B) {
+			fixture, perfData := syntheticPerformanceData(b, 40000)
+			options := ImportOptions{Parallelism: parallelism}
+			b.ResetTimer()
+			for range b.N {
+				// the songs are changed by the import, so every iteration starts from a fresh copy
+				b.StopTimer()
+				library := lib.Library{Songs: slices.Clone(fixture.Songs)}
+				b.StartTimer()
+				err := importConvertPerformanceData(context.Background(), &library, perfData, options, &lib.Reporter{})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func FuzzQUncompress(f *testing.F) {
+	f.Add(qCompress(f, seedBeatData()))
+	f.Add([]byte{0, 0, 0, 5, 0})
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		qUncompress(blob)
+	})
+}
+
+func FuzzBeatDataFromBlob(f *testing.F) {
+	f.Add(seedBeatData())
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		beatData, err := beatDataFromBlob(blob)
+		if err != nil {
+			return
+		}
+		grid, err := gridFromBeatData(beatData.sampleRate, beatData.adjBeatgrid)
+		if err != nil {
+			return
+		}
+		for _, marker := range grid {
+			if !(marker.Bpm > 0) || math.IsInf(marker.Bpm, 0) {
+				t.Errorf("decoded marker has invalid bpm %v", marker.Bpm)
+			}
+		}
+	})
+}
+
+func FuzzCuesFromBlob(f *testing.F) {
+	f.Add(seedQuickCues())
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		cuesFromBlob(44100, blob)
+	})
+}
+
+func FuzzLoopsFromBlob(f *testing.F) {
+	f.Add(seedLoops())
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		loopsFromBlob(44100, blob)
+	})
+}
+
+func FuzzTrackDataFromBlob(f *testing.F) {
+	f.Add(make([]byte, 44))
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		trackDataFromBlob(blob)
+	})
+}
+
+func FuzzWaveformDataFromBlob(f *testing.F) {
+	seed := make([]byte, 24+3*4)
+	binary.BigEndian.PutUint64(seed, 4)
+	binary.BigEndian.PutUint64(seed[8:], 4)
+	f.Add(seed)
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		waveform, err := waveformDataFromBlob("overviewWaveFormData", blob, 3)
+		if err == nil && (len(waveform.Low) != len(waveform.Mid) || len(waveform.Mid) != len(waveform.High)) {
+			t.Errorf("decoded waveform bands have different lengths")
+		}
+	})
+}
@@ -1,12 +1,13 @@
 package engine
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"path/filepath"
 )
 
-func importExtract(path string) (library, error) {
+func importExtract(ctx context.Context, path string, importOptions ImportOptions) (library, error) {
 	var enLibrary library
 	var err error
 
@@ -14,29 +15,35 @@ func importExtract(path string) (library, error) {
 	if err != nil {
 		return library{}, err
 	}
-	enLibrary.songs, err = importExtractTrack(m)
+	defer m.Close()
+	defer hm.Close()
+	enLibrary.uuid, err = importExtractUuid(ctx, m)
 	if err != nil {
-		return library{}, fmt.Errorf("error extracting track data: %v", err)
+		return library{}, fmt.Errorf("error extracting database information: %w", err)
 	}
-	enLibrary.songHistoryList, err = importExtractHistory(hm)
+	enLibrary.songs, err = importExtractTrack(ctx, m)
 	if err != nil {
-		return library{}, fmt.Errorf("error extracting history data: %v", err)
+		return library{}, fmt.Errorf("error extracting track data: %w", err)
 	}
-	enLibrary.perfData, err = importExtractPerformanceData(m)
+	enLibrary.songHistoryList, err = importExtractHistory(ctx, hm)
 	if err != nil {
-		return library{}, fmt.Errorf("error extracting performance data: %v", err)
+		return library{}, fmt.Errorf("error extracting history data: %w", err)
 	}
-	enLibrary.playlists, err = importExtractPlaylist(m)
+	enLibrary.perfData, err = importExtractPerformanceData(ctx, m, importOptions.ImportWaveforms)
 	if err != nil {
-		return library{}, fmt.Errorf("error extracting playlists: %v", err)
+		return library{}, fmt.Errorf("error extracting performance data: %w", err)
 	}
-	enLibrary.playlistEntityList, err = importExtractPlaylistEntity(m)
+	enLibrary.playlists, err = importExtractPlaylist(ctx, m)
 	if err != nil {
-		return library{}, fmt.Errorf("error extracting playlist data: %v", err)
+		return library{}, fmt.Errorf("error extracting playlists: %w", err)
 	}
-	enLibrary.smartlistList, err = importExtractSmartlist(m)
+	enLibrary.playlistEntityList, err = importExtractPlaylistEntity(ctx, m)
 	if err != nil {
-		return library{}, fmt.Errorf("error extracting smartlists: %v", err)
+		return library{}, fmt.Errorf("error extracting playlist data: %w", err)
+	}
+	enLibrary.smartlistList, err = importExtractSmartlist(ctx, m)
+	if err != nil {
+		return library{}, fmt.Errorf("error extracting smartlists: %w", err)
 	}
 	return enLibrary, nil
 }
@@ -68,58 +75,89 @@ func initDB(path string) (*sql.DB, *sql.DB, error) {
 	return m, hm, nil
 }
 
-func importExtractTrack(db *sql.DB) ([]songNull, error) {
+func importExtractUuid(ctx context.Context, db *sql.DB) (string, error) {
+	var uuid sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT uuid FROM Information ORDER BY id LIMIT 1`).Scan(&uuid)
+	if err != nil {
+		return "", err
+	}
+	return uuid.String, nil
+}
+
+func importExtractTrack(ctx context.Context, db *sql.DB) ([]songNull, error) {
 	query := `SELECT id, title, artist, composer, album, genre, fileType, fileBytes, length, year,
-		bpm, dateAdded, bitrate, comment, rating, path, remixer, key, label, lastEditTime
+		bpm, dateAdded, bitrate, comment, rating, path, remixer, key, label, lastEditTime,
+		originDatabaseUuid, originTrackId,
+		COALESCE(isMetadataOfPackedTrackChanged, 0), COALESCE(isPerfomanceDataOfPackedTrackChanged, 0)
 		FROM Track ORDER BY id`
 
-	return queryAndScanRows(db, query, func(r *sql.Rows) (songNull, error) {
+	return queryAndScanRows(ctx, db, query, func(r *sql.Rows) (songNull, error) {
 		var song songNull
 		err := r.Scan(
 			&song.id, &song.title, &song.artist, &song.composer, &song.album, &song.genre, &song.filetype,
 			&song.size, &song.length, &song.year, &song.bpm, &song.dateAdded, &song.bitrate, &song.comment,
 			&song.rating, &song.path, &song.remixer, &song.key, &song.label, &song.lastEditTime,
+			&song.originUuid, &song.originId, &song.metadataChanged, &song.perfDataChanged,
 		)
 		return song, err
 	})
 }
 
-func importExtractHistory(db *sql.DB) ([]songHistory, error) {
+func importExtractHistory(ctx context.Context, db *sql.DB) ([]songHistory, error) {
 	query := `SELECT Track.originTrackId, COUNT(HistorylistEntity.trackId), MAX(HistorylistEntity.startTime) 
 		FROM Track JOIN HistorylistEntity ON Track.id=HistorylistEntity.trackId
 		GROUP BY Track.originTrackId ORDER BY Track.originTrackId`
 
-	return queryAndScanRows(db, query, func(r *sql.Rows) (songHistory, error) {
+	return queryAndScanRows(ctx, db, query, func(r *sql.Rows) (songHistory, error) {
 		var songHistory songHistory
 		err := r.Scan(&songHistory.id, &songHistory.plays, &songHistory.lastPlayed)
 		return songHistory, err
 	})
 }
 
-func importExtractPerformanceData(db *sql.DB) ([]performanceDataEntry, error) {
-	query := `SELECT trackId, beatData, quickCues, loops FROM PerformanceData ORDER BY trackId`
+func importExtractPerformanceData(ctx context.Context, db *sql.DB, importWaveforms bool) ([]performanceDataEntry, error) {
+	// waveforms are large, so only select them if needed
+	waveformColumns := "NULL, NULL, NULL"
+	if importWaveforms {
+		// the high resolution waveform was dropped in newer schema versions
+		var hasHighRes bool
+		err := db.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM pragma_table_info('PerformanceData')
+			WHERE name = 'highResolutionWaveFormData'`).Scan(&hasHighRes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PerformanceData columns: %v", err)
+		}
+		if hasHighRes {
+			waveformColumns = "trackData, overviewWaveFormData, highResolutionWaveFormData"
+		} else {
+			waveformColumns = "trackData, overviewWaveFormData, NULL"
+		}
+	}
+
+	query := `SELECT trackId, beatData, quickCues, loops, ` + waveformColumns + `
+		FROM PerformanceData ORDER BY trackId`
 
-	return queryAndScanRows(db, query, func(r *sql.Rows) (performanceDataEntry, error) {
+	return queryAndScanRows(ctx, db, query, func(r *sql.Rows) (performanceDataEntry, error) {
 		var perfData performanceDataEntry
-		err := r.Scan(&perfData.id, &perfData.beatDataBlob, &perfData.quickCuesBlob, &perfData.loopsBlob)
+		err := r.Scan(&perfData.id, &perfData.beatDataBlob, &perfData.quickCuesBlob, &perfData.loopsBlob,
+			&perfData.trackDataBlob, &perfData.overviewBlob, &perfData.highResBlob)
 		return perfData, err
 	})
 }
 
-func importExtractPlaylist(db *sql.DB) ([]playlist, error) {
+func importExtractPlaylist(ctx context.Context, db *sql.DB) ([]playlist, error) {
 	query := `SELECT id, title, parentListId, nextListId FROM Playlist ORDER BY id`
 
-	return queryAndScanRows(db, query, func(r *sql.Rows) (playlist, error) {
+	return queryAndScanRows(ctx, db, query, func(r *sql.Rows) (playlist, error) {
 		var playlist playlist
 		err := r.Scan(&playlist.id, &playlist.title, &playlist.parentListId, &playlist.nextListId)
 		return playlist, err
 	})
 }
 
-func importExtractPlaylistEntity(db *sql.DB) ([]playlistEntity, error) {
+func importExtractPlaylistEntity(ctx context.Context, db *sql.DB) ([]playlistEntity, error) {
 	query := `SELECT id, listId, trackId, nextEntityId FROM PlaylistEntity ORDER BY listId`
 
-	return queryAndScanRows(db, query, func(r *sql.Rows) (playlistEntity, error) {
+	return queryAndScanRows(ctx, db, query, func(r *sql.Rows) (playlistEntity, error) {
 		var playlistEntity playlistEntity
 		err := r.Scan(&playlistEntity.id, &playlistEntity.listId,
 			&playlistEntity.trackId, &playlistEntity.nextEntityId)
@@ -127,11 +165,11 @@ func importExtractPlaylistEntity(db *sql.DB) ([]playlistEntity, error) {
 	})
 }
 
-func importExtractSmartlist(db *sql.DB) ([]smartlist, error) {
+func importExtractSmartlist(ctx context.Context, db *sql.DB) ([]smartlist, error) {
 	query := `SELECT listUuid, title, parentPlaylistPath, nextPlaylistPath, nextListUuid, rules
 		FROM Smartlist ORDER BY listUuid`
 
-	return queryAndScanRows(db, query, func(r *sql.Rows) (smartlist, error) {
+	return queryAndScanRows(ctx, db, query, func(r *sql.Rows) (smartlist, error) {
 		var smartlist smartlist
 		err := r.Scan(&smartlist.listUuid, &smartlist.title, &smartlist.parentPlaylistPath,
 			&smartlist.nextPlaylistPath, &smartlist.nextListUuid, &smartlist.rules)
@@ -141,10 +179,10 @@ func importExtractSmartlist(db *sql.DB) ([]smartlist, error) {
 
 // queryAndScanRows queries a given database and scans
 // each row in the response based on a given function.
-func queryAndScanRows[T any](db *sql.DB, query string, scanFunc func(*sql.Rows) (T, error)) ([]T, error) {
-	r, err := db.Query(query)
+func queryAndScanRows[T any](ctx context.Context, db *sql.DB, query string, scanFunc func(*sql.Rows) (T, error)) ([]T, error) {
+	r, err := db.QueryContext(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute query '%s': %v", query, err)
+		return nil, fmt.Errorf("failed to execute query '%s': %w", query, err)
 	}
 	defer r.Close()
 
@@ -152,9 +190,12 @@ func queryAndScanRows[T any](db *sql.DB, query string, scanFunc func(*sql.Rows)
 	for r.Next() {
 		item, err := scanFunc(r)
 		if err != nil {
-			return nil, fmt.Errorf("scan error: %v", err)
+			return nil, fmt.Errorf("scan error: %w", err)
 		}
 		results = append(results, item)
 	}
+	if err = r.Err(); err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
 	return results, nil
 }
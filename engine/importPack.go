@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/nateranda/djtools/lib"
+)
+
+// Pack is an entry in Engine's Pack table, which records
+// when a library was packed onto a drive or standalone player.
+type Pack struct {
+	PackID        string // uuid of the pack
+	ChangeLogUuid string // uuid of the database the change log belongs to
+	ChangeLogID   int    // last change log entry included in the pack
+	LastPackTime  int    // date last packed, unix
+}
+
+// PackedTrack is a track that was changed on a standalone
+// player after being packed and hasn't been merged back yet.
+type PackedTrack struct {
+	SongID                 int    // id of the track in the packed database
+	OriginUuid             string // uuid of the database the track was packed from
+	OriginSongID           int    // id of the track in the database it was packed from
+	MetadataChanged        bool   // was the track's metadata changed?
+	PerformanceDataChanged bool   // were the track's grid, cues, or loops changed?
+}
+
+// PackChanges contains the packs and changed tracks of a packed library.
+type PackChanges struct {
+	Packs  []Pack
+	Tracks []PackedTrack
+}
+
+// Pending reports whether there are changes that haven't been merged back.
+func (p PackChanges) Pending() bool {
+	return len(p.Tracks) > 0
+}
+
+// PendingPackChanges finds the tracks in a packed Engine library, like a
+// removable drive used on a standalone player, that were changed since
+// being packed and haven't been merged back into their origin library.
+func PendingPackChanges(path string) (PackChanges, error) {
+	path = findLibrary(path)
+	m, hm, err := initDB(path)
+	if err != nil {
+		return PackChanges{}, err
+	}
+	defer m.Close()
+	defer hm.Close()
+
+	var changes PackChanges
+	ctx := context.Background()
+	changes.Packs, err = importExtractPack(ctx, m)
+	if err != nil {
+		return PackChanges{}, fmt.Errorf("error extracting packs: %v", err)
+	}
+	songs, err := importExtractTrack(ctx, m)
+	if err != nil {
+		return PackChanges{}, fmt.Errorf("error extracting track data: %v", err)
+	}
+	changes.Tracks = packedTracks(songs)
+
+	return changes, nil
+}
+
+// ImportPackChanges imports the Engine library at libraryPath and merges in
+// the pending changes from the packed library at packPath, stopping early if
+// ctx is canceled. A change is only merged if the packed track was modified at
+// the same time as or after the library's track, so newer data takes priority.
+// It returns the merged Library, the ids of the songs that were taken from the
+// pack, and a Report of the problems found with the songs of both libraries.
+func ImportPackChanges(ctx context.Context, libraryPath string, packPath string, importOptions ImportOptions) (lib.Library, []int, lib.Report, error) {
+	reporter := newReporter(importOptions)
+
+	libraryPath = findLibrary(libraryPath)
+	enLibrary, err := importExtract(ctx, libraryPath, importOptions)
+	if err != nil {
+		return lib.Library{}, nil, reporter.Report, err
+	}
+	library, err := importConvert(ctx, enLibrary, libraryPath, importOptions, reporter)
+	if err != nil {
+		return lib.Library{}, nil, reporter.Report, err
+	}
+
+	packPath = findLibrary(packPath)
+	enPack, err := importExtract(ctx, packPath, importOptions)
+	if err != nil {
+		return lib.Library{}, nil, reporter.Report, fmt.Errorf("error importing pack: %v", err)
+	}
+	pack, err := importConvert(ctx, enPack, packPath, importOptions, reporter)
+	if err != nil {
+		return lib.Library{}, nil, reporter.Report, fmt.Errorf("error importing pack: %v", err)
+	}
+
+	songMap := make(map[int]*lib.Song)
+	for i, song := range library.Songs {
+		songMap[song.SongID] = &library.Songs[i]
+	}
+	packSongMap := make(map[int]*lib.Song)
+	for i, song := range pack.Songs {
+		packSongMap[song.SongID] = &pack.Songs[i]
+	}
+
+	var merged []int
+	for _, track := range packedTracks(enPack.songs) {
+		// only merge tracks that were packed from this library
+		if track.OriginUuid != enLibrary.uuid {
+			continue
+		}
+		song := songMap[track.OriginSongID]
+		packSong := packSongMap[track.SongID]
+		if song == nil || packSong == nil {
+			continue
+		}
+		// keep the library's data if it was changed after the pack
+		if packSong.DateModified < song.DateModified {
+			continue
+		}
+		if track.MetadataChanged {
+			mergePackMetadata(song, packSong)
+		}
+		if track.PerformanceDataChanged {
+			mergePackPerformanceData(song, packSong)
+		}
+		merged = append(merged, song.SongID)
+	}
+
+	return library, merged, reporter.Report, nil
+}
+
+func importExtractPack(ctx context.Context, db *sql.DB) ([]Pack, error) {
+	query := `SELECT packId, changeLogDatabaseUuid, changeLogId, CAST(lastPackTime AS INTEGER)
+		FROM Pack ORDER BY id`
+
+	return queryAndScanRows(ctx, db, query, func(r *sql.Rows) (Pack, error) {
+		var packId, changeLogUuid sql.NullString
+		var changeLogId, lastPackTime sql.NullInt64
+		err := r.Scan(&packId, &changeLogUuid, &changeLogId, &lastPackTime)
+		return Pack{
+			PackID:        packId.String,
+			ChangeLogUuid: changeLogUuid.String,
+			ChangeLogID:   int(changeLogId.Int64),
+			LastPackTime:  int(lastPackTime.Int64),
+		}, err
+	})
+}
+
+// packedTracks returns the tracks flagged as changed after being packed.
+func packedTracks(songs []songNull) []PackedTrack {
+	var tracks []PackedTrack
+	for _, song := range songs {
+		if !song.metadataChanged && !song.perfDataChanged {
+			continue
+		}
+		tracks = append(tracks, PackedTrack{
+			SongID:                 int(song.id.Int64),
+			OriginUuid:             song.originUuid.String,
+			OriginSongID:           int(song.originId.Int64),
+			MetadataChanged:        song.metadataChanged,
+			PerformanceDataChanged: song.perfDataChanged,
+		})
+	}
+	return tracks
+}
+
+// mergePackMetadata copies the editable metadata of a packed song,
+// keeping the song's id, path, and file properties.
+func mergePackMetadata(song *lib.Song, packSong *lib.Song) {
+	song.Title = packSong.Title
+	song.Artist = packSong.Artist
+	song.Composer = packSong.Composer
+	song.Album = packSong.Album
+	song.Genre = packSong.Genre
+	song.Year = packSong.Year
+	song.Bpm = packSong.Bpm
+	song.Comment = packSong.Comment
+	song.Rating = packSong.Rating
+	song.Remixer = packSong.Remixer
+	song.Key = packSong.Key
+	song.Label = packSong.Label
+	song.DateModified = packSong.DateModified
+}
+
+// mergePackPerformanceData copies the grid, cues, and loops of a packed song.
+func mergePackPerformanceData(song *lib.Song, packSong *lib.Song) {
+	song.SampleRate = packSong.SampleRate
+	song.Grid = packSong.Grid
+	song.Cue = packSong.Cue
+	song.Cues = packSong.Cues
+	song.Loops = packSong.Loops
+	song.DateModified = max(song.DateModified, packSong.DateModified)
+}
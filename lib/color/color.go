@@ -0,0 +1,186 @@
+// This package maps colors between the fixed palettes used by DJ software.
+package color
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RGB is a 24-bit color.
+type RGB struct {
+	R, G, B uint8
+}
+
+// Parse parses a hex color written as "#RRGGBB", "0xRRGGBB", or "RRGGBB", case-insensitive.
+func Parse(hex string) (RGB, error) {
+	trimmed := strings.TrimPrefix(hex, "#")
+	if len(trimmed) > 1 && (trimmed[:2] == "0x" || trimmed[:2] == "0X") {
+		trimmed = trimmed[2:]
+	}
+	if len(trimmed) != 6 {
+		return RGB{}, fmt.Errorf("color %q is not a 6-digit hex code", hex)
+	}
+	value, err := strconv.ParseUint(trimmed, 16, 32)
+	if err != nil {
+		return RGB{}, fmt.Errorf("color %q is not a 6-digit hex code", hex)
+	}
+	return RGB{uint8(value >> 16), uint8(value >> 8), uint8(value)}, nil
+}
+
+// Hex returns the color in the "#RRGGBB" format used by lib.
+func (c RGB) Hex() string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+// lab converts the color from sRGB to CIELAB under a D65 white point.
+func (c RGB) lab() (l, a, b float64) {
+	linear := func(channel uint8) float64 {
+		v := float64(channel) / 255
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	r, g, bl := linear(c.R), linear(c.G), linear(c.B)
+
+	x := (0.4124*r + 0.3576*g + 0.1805*bl) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*bl
+	z := (0.0193*r + 0.1192*g + 0.9505*bl) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// Distance returns the perceptual distance between two colors,
+// the Euclidean distance in CIELAB space (CIE76 delta E).
+func Distance(a, b RGB) float64 {
+	l1, a1, b1 := a.lab()
+	l2, a2, b2 := b.lab()
+	return math.Sqrt((l1-l2)*(l1-l2) + (a1-a2)*(a1-a2) + (b1-b2)*(b1-b2))
+}
+
+// Palette is a fixed set of colors a DJ program can display.
+type Palette []RGB
+
+// Contains returns true if the color is exactly one of the palette's colors.
+func (p Palette) Contains(c RGB) bool {
+	for _, color := range p {
+		if color == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Nearest returns the palette color perceptually closest to c.
+// The first color wins ties, and an empty palette returns c.
+func (p Palette) Nearest(c RGB) RGB {
+	if len(p) == 0 {
+		return c
+	}
+	nearest, best := p[0], math.Inf(1)
+	for _, color := range p {
+		if distance := Distance(c, color); distance < best {
+			nearest, best = color, distance
+		}
+	}
+	return nearest
+}
+
+// Snap maps a hex color to the nearest palette color, returned in "#RRGGBB" format.
+// An empty color means no color and is returned unchanged.
+func (p Palette) Snap(hex string) (string, error) {
+	if hex == "" {
+		return "", nil
+	}
+	c, err := Parse(hex)
+	if err != nil {
+		return "", err
+	}
+	return p.Nearest(c).Hex(), nil
+}
+
+// RekordboxCues are the hot cue and loop colors of Rekordbox 6 and later.
+// Rekordbox XML can store any RGB color for cues, but the player only shows these.
+var RekordboxCues = Palette{
+	{0xDE, 0x44, 0xCF}, // pink
+	{0xB4, 0x32, 0xFF}, // purple
+	{0xAA, 0x72, 0xFF}, // violet
+	{0x64, 0x73, 0xFF}, // light blue
+	{0x30, 0x5A, 0xFF}, // blue
+	{0x50, 0xB4, 0xFF}, // sky blue
+	{0x00, 0xE0, 0xFF}, // aqua
+	{0x1F, 0xA3, 0x92}, // teal
+	{0x10, 0xB1, 0x76}, // sea green
+	{0x28, 0xE2, 0x14}, // green, the default
+	{0xA5, 0xE1, 0x16}, // lime
+	{0xB4, 0xBE, 0x04}, // olive
+	{0xC3, 0xAF, 0x04}, // yellow
+	{0xE0, 0x64, 0x1B}, // orange
+	{0xE6, 0x28, 0x28}, // red
+	{0xFF, 0x12, 0x7B}, // magenta
+}
+
+// RekordboxTracks are the track colors of Rekordbox, the only values
+// accepted by the Colour attribute of Rekordbox XML.
+var RekordboxTracks = Palette{
+	{0xFF, 0x00, 0x7F}, // pink
+	{0xFF, 0x00, 0x00}, // red
+	{0xFF, 0xA5, 0x00}, // orange
+	{0xFF, 0xFF, 0x00}, // yellow
+	{0x00, 0xFF, 0x00}, // green
+	{0x25, 0xFD, 0xE9}, // aqua
+	{0x00, 0x00, 0xFF}, // blue
+	{0x66, 0x00, 0x99}, // purple
+}
+
+// SeratoCues are the hot cue colors of Serato DJ Pro.
+var SeratoCues = Palette{
+	{0xC0, 0x26, 0x26}, // red
+	{0xDB, 0x4E, 0x27}, // dark orange
+	{0xF8, 0x82, 0x1A}, // orange
+	{0xFA, 0xC3, 0x13}, // yellow
+	{0x4E, 0xB6, 0x48}, // green
+	{0x00, 0x68, 0x38}, // dark green
+	{0x1F, 0xAD, 0x2D}, // bright green
+	{0x8D, 0xC6, 0x3F}, // lime
+	{0x1D, 0xBE, 0xBD}, // cyan
+	{0x0F, 0x88, 0xCA}, // sky blue
+	{0x16, 0x30, 0x8B}, // dark blue
+	{0x17, 0x3B, 0xA2}, // blue
+	{0x5C, 0x3F, 0x97}, // violet
+	{0x68, 0x23, 0xB6}, // purple
+	{0xCE, 0x35, 0x9E}, // pink
+	{0xDC, 0x1D, 0x49}, // magenta
+}
+
+// EngineCues are the default hot cue and loop colors of Engine DJ.
+var EngineCues = Palette{
+	{0xCE, 0x32, 0x39}, // red
+	{0xEF, 0x81, 0x30}, // orange
+	{0xF4, 0xD3, 0x38}, // yellow
+	{0x86, 0xC6, 0x4B}, // lime
+	{0x20, 0xC6, 0x70}, // green
+	{0x00, 0xA8, 0xA9}, // teal
+	{0x15, 0x71, 0xE2}, // blue
+	{0xAA, 0x55, 0xC4}, // purple
+}
+
+// TraktorTracks are the track colors of Traktor, stored as the numbers 1-7 in this order.
+var TraktorTracks = Palette{
+	{0xFF, 0x00, 0x00}, // red
+	{0xFF, 0x80, 0x00}, // orange
+	{0xFF, 0xFF, 0x00}, // yellow
+	{0x00, 0xFF, 0x00}, // green
+	{0x00, 0x00, 0xFF}, // blue
+	{0x80, 0x00, 0xFF}, // violet
+	{0xFF, 0x00, 0xFF}, // magenta
+}
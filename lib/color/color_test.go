@@ -0,0 +1,70 @@
+package color_test
+
+import (
+	"testing"
+
+	"github.com/nateranda/djtools/lib/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	for _, hex := range []string{"#FF8000", "#ff8000", "0xFF8000", "0Xff8000", "FF8000"} {
+		c, err := color.Parse(hex)
+		assert.Nil(t, err, "Parsing %q should return no errors.", hex)
+		assert.Equal(t, color.RGB{R: 0xFF, G: 0x80, B: 0x00}, c)
+		assert.Equal(t, "#FF8000", c.Hex())
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, hex := range []string{"", "#", "#FFF", "#FF80001", "#GG8000", "0x"} {
+		_, err := color.Parse(hex)
+		assert.NotNil(t, err, "Parsing %q should return an error.", hex)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	red := color.RGB{R: 0xFF}
+	assert.Equal(t, 0.0, color.Distance(red, red))
+	assert.InDelta(t, 100, color.Distance(color.RGB{}, color.RGB{R: 0xFF, G: 0xFF, B: 0xFF}), 0.01,
+		"Black and white should be 100 apart.")
+	assert.Less(t, color.Distance(red, color.RGB{R: 0xFF, G: 0x40}), color.Distance(red, color.RGB{G: 0xFF}))
+}
+
+func TestNearest(t *testing.T) {
+	tests := []struct {
+		name     string
+		palette  color.Palette
+		hex      string
+		expected string
+	}{
+		{"Exact", color.EngineCues, "#1571E2", "#1571E2"},
+		{"Red", color.RekordboxTracks, "#C81E1E", "#FF0000"},
+		{"DarkOrange", color.RekordboxTracks, "#FF9010", "#FFA500"},
+		{"Blue", color.RekordboxCues, "#2050F0", "#305AFF"},
+		{"Green", color.SeratoCues, "#28E214", "#1FAD2D"},
+		{"Violet", color.TraktorTracks, "#9040F0", "#8000FF"},
+		{"Empty", color.RekordboxCues, "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			snapped, err := test.palette.Snap(test.hex)
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, snapped)
+		})
+	}
+}
+
+func TestPalettes(t *testing.T) {
+	palettes := []color.Palette{color.RekordboxCues, color.RekordboxTracks, color.SeratoCues,
+		color.EngineCues, color.TraktorTracks}
+	for _, palette := range palettes {
+		for _, c := range palette {
+			assert.True(t, palette.Contains(c))
+			assert.Equal(t, c, palette.Nearest(c), "Palette colors should map to themselves.")
+		}
+	}
+	assert.False(t, color.RekordboxTracks.Contains(color.RGB{R: 1}))
+	assert.Equal(t, color.RGB{R: 1}, color.Palette{}.Nearest(color.RGB{R: 1}))
+}
@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// cueDataField is the tag field of the cue data written with TagWriteOptions.Cues. It is
+// stored as JSON in a TXXX frame, Vorbis comment, or MP4 freeform item named DJTOOLS_CUES.
+const cueDataField = "CueData"
+
+// cueDataVersion is the version of the cue data format, increased on incompatible changes.
+const cueDataVersion = 1
+
+// CueData is the cue points, loops, and beatgrid of a song embedded in its file. Positions
+// are in the library's timing, so they don't depend on the software that reads them.
+type CueData struct {
+	Cues  []HotCue // hot cues, ordered by position
+	Loops []Loop   // loops, ordered by position
+	Grid  []Marker // beatgrid, ordered by start position
+}
+
+// cueDataJSON is CueData as it is stored in the tag.
+type cueDataJSON struct {
+	Version int
+	CueData
+}
+
+func (c CueData) String() string {
+	return fmt.Sprintf("%d hot cues, %d loops, %d grid markers", len(c.Cues), len(c.Loops), len(c.Grid))
+}
+
+// songCueData returns the cue data of a song, or nil if it has no cues, loops, or beatgrid.
+func songCueData(song Song) *CueData {
+	if len(song.Cues) == 0 && len(song.Loops) == 0 && len(song.Grid) == 0 {
+		return nil
+	}
+	// cues and loops are unordered, so they're sorted to write the same tag every time
+	data := CueData{
+		Cues:  append([]HotCue{}, song.Cues...),
+		Loops: append([]Loop{}, song.Loops...),
+		Grid:  song.Grid,
+	}
+	sort.SliceStable(data.Cues, func(i, j int) bool { return data.Cues[i].Position < data.Cues[j].Position })
+	sort.SliceStable(data.Loops, func(i, j int) bool { return data.Loops[i].Position < data.Loops[j].Position })
+	return &data
+}
+
+func encodeCueData(data CueData) string {
+	encoded, _ := json.Marshal(cueDataJSON{cueDataVersion, data})
+	return string(encoded)
+}
+
+func decodeCueData(value string) (CueData, error) {
+	var data cueDataJSON
+	err := json.Unmarshal([]byte(value), &data)
+	if err != nil {
+		return CueData{}, fmt.Errorf("invalid cue data: %v", err)
+	}
+	if data.Version > cueDataVersion {
+		return CueData{}, fmt.Errorf("cue data version %d is newer than this version of djtools", data.Version)
+	}
+	return data.CueData, nil
+}
+
+// ReadEmbeddedCues reads the cue data written to an audio file with TagWriteOptions.Cues.
+// It returns nil if the file has none.
+func ReadEmbeddedCues(path string) (*CueData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cue data: %w", err)
+	}
+	codec, err := tagCodecFor(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cue data: %w", err)
+	}
+	fields, err := codec.read(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cue data: %v", err)
+	}
+	value, exists := fields[cueDataField]
+	if !exists {
+		return nil, nil
+	}
+	cueData, err := decodeCueData(value)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cue data: %v", err)
+	}
+	return &cueData, nil
+}
+
+// mergeCueData fills a song's empty cues, loops, and beatgrid from the cue data embedded in
+// its file, and reports a mismatch if the rest differ.
+func mergeCueData(song *Song, data CueData, overwrite bool, reporter *Reporter) {
+	if len(song.Cues) == 0 {
+		song.Cues = data.Cues
+	}
+	if len(song.Loops) == 0 {
+		song.Loops = data.Loops
+	}
+	if len(song.Grid) == 0 {
+		song.Grid = data.Grid
+	}
+	library := Song{Cues: song.Cues, Loops: song.Loops, Grid: song.Grid}
+	file := Song{Cues: data.Cues, Loops: data.Loops, Grid: data.Grid}
+	changes := diffSong(library, file, DefaultTolerance)
+	if len(changes) == 0 {
+		return
+	}
+	reporter.Warn(Warning{
+		Type:    WarningTagMismatch,
+		SongID:  song.SongID,
+		Path:    song.Path,
+		Message: fmt.Sprintf("cue data differs from the file in %d fields, like %s", len(changes), changes[0].Field),
+	})
+	if overwrite {
+		song.Cues, song.Loops, song.Grid = data.Cues, data.Loops, data.Grid
+	}
+}
+
+// readSongCueData reads the cue data of a song's file into the song for ReadTags.
+// Files whose type can't hold cue data are skipped.
+func readSongCueData(song *Song, overwrite bool, reporter *Reporter) {
+	data, err := ReadEmbeddedCues(song.Path)
+	if errors.Is(err, ErrUnsupported) {
+		return
+	}
+	if err != nil {
+		reporter.Warn(Warning{Type: WarningUnreadableFile, SongID: song.SongID, Path: song.Path, Message: err.Error()})
+		return
+	}
+	if data != nil {
+		mergeCueData(song, *data, overwrite, reporter)
+	}
+}
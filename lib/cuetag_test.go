@@ -0,0 +1,93 @@
+package lib_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+// cueSong is a song with unordered cues and loops and a beatgrid.
+var cueSong = lib.Song{
+	SongID: 1,
+	Key:    -1,
+	Grid:   []lib.Marker{{StartPosition: 0.05, Bpm: 128}, {StartPosition: 60.05, Bpm: 126, BeatsPerBar: 3, BeatUnit: 4}},
+	Cues: []lib.HotCue{
+		{Name: "Drop", Offset: 45.2, Position: 2, Color: "#FF0000"},
+		{Name: "Intro", Offset: 0.05, Position: 1, Color: "#00FF00"},
+	},
+	Loops: []lib.Loop{{Name: "Ünïcode lööp", Start: 30, End: 37.5, Position: 1, Color: "#0000FF"}},
+}
+
+func TestEmbeddedCues(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"ID3v23.mp3", append(id3Tag(songFrames, nil), mp3Frame("Info", 576)...)},
+		{"Song.flac", flacFile()},
+		{"Song.ogg", oggFile()},
+		{"Song.m4a", m4aFile(44100, 2112, "")},
+	}
+	expected := &lib.CueData{Cues: []lib.HotCue{cueSong.Cues[1], cueSong.Cues[0]}, Loops: cueSong.Loops, Grid: cueSong.Grid}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeFile(t, filepath.Join(t.TempDir(), test.name), test.data)
+			data, err := lib.ReadEmbeddedCues(path)
+			assert.Nil(t, err)
+			assert.Nil(t, data, "Files without cue data should return nil.")
+
+			song := cueSong
+			song.Path = path
+			write, err := lib.WriteSongTags(song, lib.TagWriteOptions{Cues: true})
+			assert.Nil(t, err)
+			assert.Equal(t, []lib.FieldChange{{Field: "CueData", New: "2 hot cues, 1 loops, 2 grid markers"}}, write.Changes)
+
+			data, err = lib.ReadEmbeddedCues(path)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, data, "Cue data should be read back sorted by position.")
+
+			write, err = lib.WriteSongTags(song, lib.TagWriteOptions{Cues: true, DryRun: true})
+			assert.Nil(t, err)
+			assert.Empty(t, write.Changes, "Unordered cues shouldn't be written again.")
+		})
+	}
+}
+
+func TestWriteTagsWithoutCues(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.flac"), flacFile())
+	song := cueSong
+	song.Path = path
+	write, err := lib.WriteSongTags(song, lib.TagWriteOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, write.Changes, "Cue data should only be written with the Cues option.")
+}
+
+func TestReadTagsCues(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.mp3"), append(id3Tag(songFrames, nil), mp3Frame("Info", 576)...))
+	song := cueSong
+	song.Path = path
+	_, err := lib.WriteSongTags(song, lib.TagWriteOptions{Cues: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved := cueSong
+	moved.Path = path
+	moved.Cues = []lib.HotCue{{Name: "Drop", Offset: 50, Position: 2, Color: "#FF0000"}}
+	library := lib.Library{Songs: []lib.Song{{SongID: 1, Path: path, Key: -1}, moved}}
+	report := library.ReadTags(lib.TagOptions{Cues: true})
+
+	empty := library.Songs[0]
+	assert.Len(t, empty.Cues, 2, "Empty cues should be filled from the file.")
+	assert.Equal(t, cueSong.Loops, empty.Loops, "Empty loops should be filled from the file.")
+	assert.Equal(t, cueSong.Grid, empty.Grid, "Empty beatgrids should be filled from the file.")
+	assert.Equal(t, moved.Cues, library.Songs[1].Cues, "Different cues should be kept without Overwrite.")
+	assert.Len(t, report.Warnings, 1, "Different cues should be reported.")
+	assert.Equal(t, lib.WarningTagMismatch, report.Warnings[0].Type)
+
+	report = library.ReadTags(lib.TagOptions{Cues: true, Overwrite: true})
+	assert.Len(t, report.Warnings, 1)
+	assert.Len(t, library.Songs[1].Cues, 2, "Different cues should be replaced with Overwrite.")
+}
@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Detection is a library format found at a path.
+type Detection struct {
+	Format     string  // name of the format, like "engine"
+	Version    string  // schema or file version found, empty if unknown
+	Confidence float64 // how likely the path is in this format, 0-1
+	Path       string  // path of the library, which can be inside of the given path
+}
+
+// Detector detects a library format at a path, returning
+// a Detection with a Confidence of 0 if it isn't found.
+type Detector func(path string) Detection
+
+// builtinDetectors detect formats that don't have a package yet, so
+// users can still be told what a path contains. A registered format
+// with the same name replaces its builtin detector.
+var builtinDetectors = map[string]Detector{
+	"traktor":   detectTraktor,
+	"virtualdj": detectVirtualDJ,
+	"mixxx":     detectMixxx,
+	"rekordbox": detectRekordbox,
+}
+
+// Detect returns every format found at a path, most confident first. It checks
+// the path itself and the places each program keeps its library inside of it,
+// so a home or music folder can be given instead of the library itself.
+func Detect(path string) []Detection {
+	detectors := make(map[string]Detector)
+	for name, detector := range builtinDetectors {
+		detectors[name] = detector
+	}
+	for _, format := range Formats() {
+		if format.Detect != nil {
+			detectors[format.Name] = format.Detect
+		}
+	}
+
+	var detections []Detection
+	for name, detector := range detectors {
+		detection := detector(path)
+		if detection.Confidence <= 0 {
+			continue
+		}
+		detection.Format = name
+		if detection.Path == "" {
+			detection.Path = path
+		}
+		detections = append(detections, detection)
+	}
+
+	sort.Slice(detections, func(i, j int) bool {
+		if detections[i].Confidence != detections[j].Confidence {
+			return detections[i].Confidence > detections[j].Confidence
+		}
+		return detections[i].Format < detections[j].Format
+	})
+	return detections
+}
+
+// ReadXMLRoot returns the root element of an XML file, reading only up to it.
+func ReadXMLRoot(path string) (xml.StartElement, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return xml.StartElement{}, err
+	}
+	defer file.Close()
+
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, fmt.Errorf("error reading XML root: %v", err)
+		}
+		if element, ok := token.(xml.StartElement); ok {
+			return element, nil
+		}
+	}
+}
+
+// xmlAttr returns the value of an element's attribute, or an empty string.
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// firstExisting returns the first path that exists, or an empty string.
+func firstExisting(paths ...string) string {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// detectXML detects an XML library with the given root element and version attribute,
+// checking path itself and then each of the candidate paths inside of it.
+func detectXML(path string, root string, versionAttr string, candidates ...string) Detection {
+	paths := []string{path}
+	for _, candidate := range candidates {
+		matches, _ := filepath.Glob(filepath.Join(path, candidate))
+		paths = append(paths, matches...)
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		element, err := ReadXMLRoot(path)
+		if err != nil || element.Name.Local != root {
+			continue
+		}
+		return Detection{
+			Version:    xmlAttr(element, versionAttr),
+			Confidence: 1,
+			Path:       path,
+		}
+	}
+	return Detection{}
+}
+
+func detectTraktor(path string) Detection {
+	return detectXML(path, "NML", "VERSION",
+		"collection.nml",
+		filepath.Join("Native Instruments", "Traktor*", "collection.nml"),
+		filepath.Join("Documents", "Native Instruments", "Traktor*", "collection.nml"),
+	)
+}
+
+func detectVirtualDJ(path string) Detection {
+	return detectXML(path, "VirtualDJ_Database", "Version",
+		"database.xml",
+		filepath.Join("VirtualDJ", "database.xml"),
+		filepath.Join("Documents", "VirtualDJ", "database.xml"),
+	)
+}
+
+func detectMixxx(path string) Detection {
+	if filepath.Base(path) == "mixxxdb.sqlite" {
+		return Detection{Confidence: 0.9, Path: path}
+	}
+	found := firstExisting(
+		filepath.Join(path, "mixxxdb.sqlite"),
+		filepath.Join(path, ".mixxx", "mixxxdb.sqlite"),
+		filepath.Join(path, "Library", "Application Support", "Mixxx", "mixxxdb.sqlite"),
+		filepath.Join(path, "AppData", "Local", "Mixxx", "mixxxdb.sqlite"),
+	)
+	if found == "" {
+		return Detection{}
+	}
+	return Detection{Confidence: 0.9, Path: found}
+}
+
+// detectRekordbox detects rekordbox 6 and newer databases, which are
+// encrypted, so the version can't be read and confidence is lower.
+func detectRekordbox(path string) Detection {
+	if filepath.Base(path) == "master.db" {
+		return Detection{Version: "6", Confidence: 0.8, Path: path}
+	}
+	found := firstExisting(
+		filepath.Join(path, "master.db"),
+		filepath.Join(path, "Library", "Pioneer", "rekordbox", "master.db"),
+		filepath.Join(path, "AppData", "Roaming", "Pioneer", "rekordbox", "master.db"),
+	)
+	if found == "" {
+		return Detection{}
+	}
+	return Detection{Version: "6", Confidence: 0.8, Path: found}
+}
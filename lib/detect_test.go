@@ -0,0 +1,51 @@
+package lib_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFile writes data to path, creating its directories, and returns the path.
+func writeFile(t *testing.T, path string, data []byte) string {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDetect(t *testing.T) {
+	home := t.TempDir()
+	traktorPath := filepath.Join(home, "Documents", "Native Instruments", "Traktor 3.11.1", "collection.nml")
+	writeFile(t, traktorPath, []byte(`<?xml version="1.0" encoding="UTF-8" standalone="no" ?><NML VERSION="19"></NML>`))
+	virtualDJPath := filepath.Join(home, "Documents", "VirtualDJ", "database.xml")
+	writeFile(t, virtualDJPath, []byte(`<?xml version="1.0" encoding="UTF-8"?><VirtualDJ_Database Version="2023"></VirtualDJ_Database>`))
+	rekordboxPath := filepath.Join(home, "Library", "Pioneer", "rekordbox", "master.db")
+	writeFile(t, rekordboxPath, []byte(""))
+
+	detections := lib.Detect(home)
+	assert.Equal(t, []lib.Detection{
+		{Format: "traktor", Version: "19", Confidence: 1, Path: traktorPath},
+		{Format: "virtualdj", Version: "2023", Confidence: 1, Path: virtualDJPath},
+		{Format: "rekordbox", Version: "6", Confidence: 0.8, Path: rekordboxPath},
+	}, detections, "Detections should be found in default locations, most confident first.")
+
+	assert.Empty(t, lib.Detect(t.TempDir()), "Empty folder should not be detected as any format.")
+}
+
+func TestDetectWrongRoot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collection.nml")
+	writeFile(t, path, []byte(`<?xml version="1.0"?><DJ_PLAYLISTS Version="1.0.0"></DJ_PLAYLISTS>`))
+	for _, detection := range lib.Detect(path) {
+		assert.NotEqual(t, "traktor", detection.Format, "NML extension alone should not be detected as Traktor.")
+	}
+}
@@ -0,0 +1,424 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// DefaultTolerance is the time tolerance used by Diff if none is given, in seconds.
+const DefaultTolerance float64 = 0.001
+
+// SongKey returns the key used to match a song across libraries.
+type SongKey func(song Song) string
+
+// PathKey matches songs by path.
+func PathKey(song Song) string {
+	return song.Path
+}
+
+// DiffOptions configures how libraries are compared.
+type DiffOptions struct {
+	Key       SongKey // key used to match and label songs, songs are matched with MatchSongs and labeled by path if nil
+	Tolerance float64 // largest difference between cue, loop and grid times that is ignored, DefaultTolerance if 0
+	Match     MatchOptions
+}
+
+// FieldChange is a change to a single field of a song.
+type FieldChange struct {
+	Field string // name of the field, like "Title" or "Cues[1].Offset"
+	Old   string // old value, empty if the field was added
+	New   string // new value, empty if the field was removed
+}
+
+// SongDiff is the set of changes to a song that exists in both libraries.
+type SongDiff struct {
+	Key     string        // key the song was matched by
+	OldID   int           // id of the song in the old library
+	NewID   int           // id of the song in the new library
+	Changes []FieldChange // changed fields, in the order of the Song struct
+}
+
+// PlaylistDiff is the set of changes to a playlist that exists in both libraries.
+type PlaylistDiff struct {
+	Path      string   // names of the playlist and its parents, separated by "/"
+	Added     []string // keys of songs added to the playlist
+	Removed   []string // keys of songs removed from the playlist
+	Reordered bool     // are the songs in both playlists in a different order?
+}
+
+// LibraryDiff is the difference between two libraries.
+type LibraryDiff struct {
+	AddedSongs       []string       // keys of songs only in the new library
+	RemovedSongs     []string       // keys of songs only in the old library
+	ChangedSongs     []SongDiff     // songs in both libraries with different fields
+	AddedPlaylists   []string       // paths of playlists only in the new library
+	RemovedPlaylists []string       // paths of playlists only in the old library
+	ChangedPlaylists []PlaylistDiff // playlists in both libraries with different songs
+}
+
+// Diff compares two libraries and returns the changes from a to b.
+// Songs are matched by options.Key or MatchSongs, and playlists by their path.
+func Diff(a, b *Library, options DiffOptions) LibraryDiff {
+	if options.Tolerance == 0 {
+		options.Tolerance = DefaultTolerance
+	}
+
+	var diff LibraryDiff
+	label := options.Key
+	if label == nil {
+		label = PathKey
+	}
+	aKeys := songKeys(a, label)
+	bKeys := songKeys(b, label)
+
+	var matches []Match
+	if options.Key != nil {
+		matches = keyMatches(a.Songs, b.Songs, options.Key)
+	} else {
+		matches = MatchSongs(a.Songs, b.Songs, options.Match).Matches
+	}
+
+	aMatched := make(map[int]bool)
+	bMatched := make(map[int]bool)
+	for _, match := range matches {
+		aMatched[match.A] = true
+		bMatched[match.B] = true
+	}
+	for i, song := range a.Songs {
+		if !aMatched[i] {
+			diff.RemovedSongs = append(diff.RemovedSongs, label(song))
+		}
+	}
+	for _, match := range matches {
+		song, other := a.Songs[match.A], b.Songs[match.B]
+		changes := diffSong(song, other, options.Tolerance)
+		if changes != nil {
+			diff.ChangedSongs = append(diff.ChangedSongs, SongDiff{
+				Key:     label(song),
+				OldID:   song.SongID,
+				NewID:   other.SongID,
+				Changes: changes,
+			})
+		}
+		// playlists refer to matched songs by the same key
+		bKeys[other.SongID] = label(song)
+	}
+	for j, song := range b.Songs {
+		if !bMatched[j] {
+			diff.AddedSongs = append(diff.AddedSongs, label(song))
+		}
+	}
+
+	aPlaylists := flattenPlaylists(a.Playlists, "")
+	bPlaylists := flattenPlaylists(b.Playlists, "")
+	bByPath := make(map[string]Playlist)
+	for _, playlist := range bPlaylists {
+		bByPath[playlist.path] = playlist.Playlist
+	}
+	aByPath := make(map[string]bool)
+	for _, playlist := range aPlaylists {
+		aByPath[playlist.path] = true
+		other, exists := bByPath[playlist.path]
+		if !exists {
+			diff.RemovedPlaylists = append(diff.RemovedPlaylists, playlist.path)
+			continue
+		}
+		playlistDiff := diffPlaylist(keysOf(playlist.Songs, aKeys), keysOf(other.Songs, bKeys))
+		if playlistDiff != nil {
+			playlistDiff.Path = playlist.path
+			diff.ChangedPlaylists = append(diff.ChangedPlaylists, *playlistDiff)
+		}
+	}
+	for _, playlist := range bPlaylists {
+		if !aByPath[playlist.path] {
+			diff.AddedPlaylists = append(diff.AddedPlaylists, playlist.path)
+		}
+	}
+
+	return diff
+}
+
+// Empty returns true if the libraries compared were the same.
+func (d LibraryDiff) Empty() bool {
+	return len(d.AddedSongs) == 0 && len(d.RemovedSongs) == 0 && len(d.ChangedSongs) == 0 &&
+		len(d.AddedPlaylists) == 0 && len(d.RemovedPlaylists) == 0 && len(d.ChangedPlaylists) == 0
+}
+
+// String renders the diff as human-readable text,
+// marking additions with "+", removals with "-", and changes with "~".
+func (d LibraryDiff) String() string {
+	var b strings.Builder
+	for _, key := range d.AddedSongs {
+		fmt.Fprintf(&b, "+ song %s\n", key)
+	}
+	for _, key := range d.RemovedSongs {
+		fmt.Fprintf(&b, "- song %s\n", key)
+	}
+	for _, song := range d.ChangedSongs {
+		fmt.Fprintf(&b, "~ song %s\n", song.Key)
+		for _, change := range song.Changes {
+			fmt.Fprintf(&b, "    %s: %s -> %s\n", change.Field, change.Old, change.New)
+		}
+	}
+	for _, path := range d.AddedPlaylists {
+		fmt.Fprintf(&b, "+ playlist %s\n", path)
+	}
+	for _, path := range d.RemovedPlaylists {
+		fmt.Fprintf(&b, "- playlist %s\n", path)
+	}
+	for _, playlist := range d.ChangedPlaylists {
+		fmt.Fprintf(&b, "~ playlist %s\n", playlist.Path)
+		for _, key := range playlist.Added {
+			fmt.Fprintf(&b, "    + %s\n", key)
+		}
+		for _, key := range playlist.Removed {
+			fmt.Fprintf(&b, "    - %s\n", key)
+		}
+		if playlist.Reordered {
+			fmt.Fprintf(&b, "    order changed\n")
+		}
+	}
+	return b.String()
+}
+
+// JSON renders the diff as indented JSON.
+func (d LibraryDiff) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// songKeys maps the song ids of a library to their keys.
+func songKeys(library *Library, key SongKey) map[int]string {
+	keys := make(map[int]string)
+	for _, song := range library.Songs {
+		keys[song.SongID] = key(song)
+	}
+	return keys
+}
+
+// keyMatches pairs songs with the same key in order, so duplicates are still compared.
+func keyMatches(a, b []Song, key SongKey) []Match {
+	unmatched := make(map[string][]int)
+	for i, song := range a {
+		unmatched[key(song)] = append(unmatched[key(song)], i)
+	}
+	var matches []Match
+	for j, song := range b {
+		indexes := unmatched[key(song)]
+		if len(indexes) == 0 {
+			continue
+		}
+		unmatched[key(song)] = indexes[1:]
+		matches = append(matches, Match{indexes[0], j, MatchKey, matchConfidence[MatchKey]})
+	}
+	return matches
+}
+
+// keysOf converts song ids to keys, keeping ids of missing songs as "#id".
+func keysOf(ids []int, keys map[int]string) []string {
+	var result []string
+	for _, id := range ids {
+		key, exists := keys[id]
+		if !exists {
+			key = fmt.Sprintf("#%d", id)
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
+type pathPlaylist struct {
+	Playlist
+	path string
+}
+
+// flattenPlaylists returns every playlist in the tree with its path, in order.
+func flattenPlaylists(playlists []Playlist, parent string) []pathPlaylist {
+	var result []pathPlaylist
+	for _, playlist := range playlists {
+		path := playlist.Name
+		if parent != "" {
+			path = parent + "/" + playlist.Name
+		}
+		result = append(result, pathPlaylist{playlist, path})
+		result = append(result, flattenPlaylists(playlist.SubPlaylists, path)...)
+	}
+	return result
+}
+
+// diffPlaylist compares the songs of two playlists, returning nil if they are the same.
+func diffPlaylist(a, b []string) *PlaylistDiff {
+	var diff PlaylistDiff
+	diff.Removed = subtractKeys(a, b)
+	diff.Added = subtractKeys(b, a)
+
+	// compare the order of the songs in both playlists
+	common := subtractKeys(a, diff.Removed)
+	otherCommon := subtractKeys(b, diff.Added)
+	diff.Reordered = !reflect.DeepEqual(common, otherCommon)
+
+	if diff.Removed == nil && diff.Added == nil && !diff.Reordered {
+		return nil
+	}
+	return &diff
+}
+
+// subtractKeys returns the keys in a that aren't in b, counting duplicates.
+func subtractKeys(a, b []string) []string {
+	count := make(map[string]int)
+	for _, key := range b {
+		count[key]++
+	}
+	var result []string
+	for _, key := range a {
+		if count[key] > 0 {
+			count[key]--
+			continue
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
+// diffSong compares the fields of two songs, ignoring their ids.
+func diffSong(a, b Song, tolerance float64) []FieldChange {
+	var changes []FieldChange
+	add := func(field string, old, new any) {
+		changes = append(changes, FieldChange{field, formatValue(old), formatValue(new)})
+	}
+	near := func(x, y float64) bool {
+		return math.Abs(x-y) <= tolerance
+	}
+
+	aValue := reflect.ValueOf(a)
+	bValue := reflect.ValueOf(b)
+	for i := range aValue.NumField() {
+		name := aValue.Type().Field(i).Name
+		switch name {
+		case "SongID", "Grid", "Cues", "Loops", "Waveform", "Artwork":
+			continue
+		case "Cue":
+			if !near(a.Cue, b.Cue) {
+				add(name, a.Cue, b.Cue)
+			}
+			continue
+		}
+		if aValue.Field(i).Interface() != bValue.Field(i).Interface() {
+			add(name, aValue.Field(i).Interface(), bValue.Field(i).Interface())
+		}
+	}
+
+	for i := range max(len(a.Grid), len(b.Grid)) {
+		field := fmt.Sprintf("Grid[%d]", i)
+		switch {
+		case i >= len(b.Grid):
+			add(field, a.Grid[i], nil)
+		case i >= len(a.Grid):
+			add(field, nil, b.Grid[i])
+		default:
+			if !near(a.Grid[i].StartPosition, b.Grid[i].StartPosition) {
+				add(field+".StartPosition", a.Grid[i].StartPosition, b.Grid[i].StartPosition)
+			}
+			if a.Grid[i].Bpm != b.Grid[i].Bpm {
+				add(field+".Bpm", a.Grid[i].Bpm, b.Grid[i].Bpm)
+			}
+			if a.Grid[i].BeatNumber != b.Grid[i].BeatNumber {
+				add(field+".BeatNumber", a.Grid[i].BeatNumber, b.Grid[i].BeatNumber)
+			}
+			if a.Grid[i].TimeSignature() != b.Grid[i].TimeSignature() {
+				add(field+".TimeSignature", a.Grid[i].TimeSignature(), b.Grid[i].TimeSignature())
+			}
+		}
+	}
+
+	// cues and loops are unordered, so they're matched by position
+	aCues := make(map[int]HotCue)
+	bCues := make(map[int]HotCue)
+	positions := make(map[int]bool)
+	for _, cue := range a.Cues {
+		aCues[cue.Position] = cue
+		positions[cue.Position] = true
+	}
+	for _, cue := range b.Cues {
+		bCues[cue.Position] = cue
+		positions[cue.Position] = true
+	}
+	for _, position := range slices.Sorted(maps.Keys(positions)) {
+		field := fmt.Sprintf("Cues[%d]", position)
+		aCue, aExists := aCues[position]
+		bCue, bExists := bCues[position]
+		switch {
+		case !bExists:
+			add(field, aCue, nil)
+		case !aExists:
+			add(field, nil, bCue)
+		default:
+			if aCue.Name != bCue.Name {
+				add(field+".Name", aCue.Name, bCue.Name)
+			}
+			if !near(aCue.Offset, bCue.Offset) {
+				add(field+".Offset", aCue.Offset, bCue.Offset)
+			}
+			if aCue.Color != bCue.Color {
+				add(field+".Color", aCue.Color, bCue.Color)
+			}
+		}
+	}
+
+	aLoops := make(map[int]Loop)
+	bLoops := make(map[int]Loop)
+	positions = make(map[int]bool)
+	for _, loop := range a.Loops {
+		aLoops[loop.Position] = loop
+		positions[loop.Position] = true
+	}
+	for _, loop := range b.Loops {
+		bLoops[loop.Position] = loop
+		positions[loop.Position] = true
+	}
+	for _, position := range slices.Sorted(maps.Keys(positions)) {
+		field := fmt.Sprintf("Loops[%d]", position)
+		aLoop, aExists := aLoops[position]
+		bLoop, bExists := bLoops[position]
+		switch {
+		case !bExists:
+			add(field, aLoop, nil)
+		case !aExists:
+			add(field, nil, bLoop)
+		default:
+			if aLoop.Name != bLoop.Name {
+				add(field+".Name", aLoop.Name, bLoop.Name)
+			}
+			if !near(aLoop.Start, bLoop.Start) {
+				add(field+".Start", aLoop.Start, bLoop.Start)
+			}
+			if !near(aLoop.End, bLoop.End) {
+				add(field+".End", aLoop.End, bLoop.End)
+			}
+			if aLoop.Color != bLoop.Color {
+				add(field+".Color", aLoop.Color, bLoop.Color)
+			}
+		}
+	}
+
+	if (a.Waveform == nil) != (b.Waveform == nil) {
+		add("Waveform", a.Waveform != nil, b.Waveform != nil)
+	}
+
+	return changes
+}
+
+// formatValue formats a field value for a FieldChange, quoting strings.
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprintf("%+v", value)
+}
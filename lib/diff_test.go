@@ -0,0 +1,150 @@
+package lib_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func diffLibrary() lib.Library {
+	return lib.Library{
+		Songs: []lib.Song{
+			{
+				SongID: 1,
+				Title:  "one",
+				Path:   "/music/one.mp3",
+				Cue:    1.5,
+				Grid:   []lib.Marker{{StartPosition: 0.1, Bpm: 128}},
+				Cues:   []lib.HotCue{{Offset: 30, Position: 1}, {Offset: 60, Position: 2}},
+				Loops:  []lib.Loop{{Start: 30, End: 45, Position: 1}},
+			},
+			{SongID: 2, Title: "two", Path: "/music/two.mp3"},
+			{SongID: 3, Title: "three", Path: "/music/three.mp3"},
+		},
+		Playlists: []lib.Playlist{
+			{Name: "folder", SubPlaylists: []lib.Playlist{
+				{Name: "playlist", Songs: []int{1, 2, 3}},
+			}},
+		},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(l *lib.Library)
+		expected lib.LibraryDiff
+	}{
+		{"Same", func(l *lib.Library) {}, lib.LibraryDiff{}},
+		{"DifferentIDs", func(l *lib.Library) {
+			for i := range l.Songs {
+				l.Songs[i].SongID += 10
+			}
+			l.Playlists[0].SubPlaylists[0].Songs = []int{11, 12, 13}
+		}, lib.LibraryDiff{}},
+		{"WithinTolerance", func(l *lib.Library) {
+			l.Songs[0].Cue = 1.5005
+			l.Songs[0].Grid[0].StartPosition = 0.1001
+			l.Songs[0].Cues[0].Offset = 30.0009
+		}, lib.LibraryDiff{}},
+		{"AddedRemovedSongs", func(l *lib.Library) {
+			l.Songs[2].Path = "/music/four.mp3"
+		}, lib.LibraryDiff{
+			AddedSongs:   []string{"/music/four.mp3"},
+			RemovedSongs: []string{"/music/three.mp3"},
+			ChangedPlaylists: []lib.PlaylistDiff{{
+				Path:    "folder/playlist",
+				Added:   []string{"/music/four.mp3"},
+				Removed: []string{"/music/three.mp3"},
+			}},
+		}},
+		{"ChangedFields", func(l *lib.Library) {
+			l.Songs[0].Title = "uno"
+			l.Songs[0].Cue = 2
+			l.Songs[0].Grid[0].Bpm = 130
+			l.Songs[0].Cues = l.Songs[0].Cues[:1]
+			l.Songs[0].Cues[0].Offset = 31
+			l.Songs[0].Loops[0].End = 46
+		}, lib.LibraryDiff{
+			ChangedSongs: []lib.SongDiff{{
+				Key:   "/music/one.mp3",
+				OldID: 1,
+				NewID: 1,
+				Changes: []lib.FieldChange{
+					{Field: "Title", Old: `"one"`, New: `"uno"`},
+					{Field: "Cue", Old: "1.5", New: "2"},
+					{Field: "Grid[0].Bpm", Old: "128", New: "130"},
+					{Field: "Cues[1].Offset", Old: "30", New: "31"},
+					{Field: "Cues[2]", Old: "{Name: Offset:60 Position:2 Color:}", New: ""},
+					{Field: "Loops[1].End", Old: "45", New: "46"},
+				},
+			}},
+		}},
+		{"Playlists", func(l *lib.Library) {
+			l.Playlists[0].SubPlaylists[0].Songs = []int{3, 2, 1}
+			l.Playlists[0].Name = "renamed"
+		}, lib.LibraryDiff{
+			AddedPlaylists:   []string{"renamed", "renamed/playlist"},
+			RemovedPlaylists: []string{"folder", "folder/playlist"},
+		}},
+		{"Reordered", func(l *lib.Library) {
+			l.Playlists[0].SubPlaylists[0].Songs = []int{3, 2, 1}
+		}, lib.LibraryDiff{
+			ChangedPlaylists: []lib.PlaylistDiff{{Path: "folder/playlist", Reordered: true}},
+		}},
+		{"CustomKey", func(l *lib.Library) {
+			l.Songs[0].Path = "D:\\Music\\one.mp3"
+		}, lib.LibraryDiff{
+			ChangedSongs: []lib.SongDiff{{
+				Key:     "one",
+				OldID:   1,
+				NewID:   1,
+				Changes: []lib.FieldChange{{Field: "Path", Old: `"/music/one.mp3"`, New: `"D:\\Music\\one.mp3"`}},
+			}},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := diffLibrary()
+			b := diffLibrary()
+			test.modify(&b)
+			var options lib.DiffOptions
+			if test.name == "CustomKey" {
+				options.Key = func(song lib.Song) string { return song.Title }
+			}
+			diff := lib.Diff(&a, &b, options)
+			assert.Equal(t, test.expected, diff, "Diff should find the expected changes.")
+			assert.Equal(t, test.expected.Empty(), diff.Empty(), "Diff should only be empty if nothing changed.")
+		})
+	}
+}
+
+func TestDiffRender(t *testing.T) {
+	a := diffLibrary()
+	b := diffLibrary()
+	b.Songs[1].Title = "dos"
+	b.Songs = b.Songs[:2]
+	b.Playlists[0].SubPlaylists[0].Songs = []int{1, 2}
+	diff := lib.Diff(&a, &b, lib.DiffOptions{})
+
+	expected := strings.Join([]string{
+		"- song /music/three.mp3",
+		"~ song /music/two.mp3",
+		`    Title: "two" -> "dos"`,
+		"~ playlist folder/playlist",
+		"    - /music/three.mp3",
+		"",
+	}, "\n")
+	assert.Equal(t, expected, diff.String(), "Diff should render as text.")
+
+	data, err := diff.JSON()
+	assert.Nil(t, err, "Diff should render as JSON.")
+	var decoded lib.LibraryDiff
+	err = json.Unmarshal(data, &decoded)
+	assert.Nil(t, err, "JSON should be valid.")
+	assert.Equal(t, diff, decoded, "JSON should contain the whole diff.")
+}
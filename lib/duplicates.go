@@ -0,0 +1,236 @@
+package lib
+
+import (
+	"math"
+	"slices"
+)
+
+// WinnerRule reports whether song a should be kept over song b when consolidating duplicates.
+type WinnerRule func(a, b Song) bool
+
+// NewestSong keeps the song with the newer DateModified.
+func NewestSong(a, b Song) bool {
+	return a.DateModified > b.DateModified
+}
+
+// MostCuesSong keeps the song with the most hot cues and loops.
+func MostCuesSong(a, b Song) bool {
+	return len(a.Cues)+len(a.Loops) > len(b.Cues)+len(b.Loops)
+}
+
+// MostPlayedSong keeps the song with the highest play count.
+func MostPlayedSong(a, b Song) bool {
+	return a.PlayCount > b.PlayCount
+}
+
+// DuplicateOptions configures how duplicate songs are found and consolidated.
+type DuplicateOptions struct {
+	// Hash returns a hash of a song's audio content, like PathHash.
+	// Content isn't compared if nil, and songs that return an error are skipped.
+	Hash            func(song Song) (string, error)
+	LengthTolerance float32    // largest difference in length between songs with the same tags, 1 second if 0
+	Winner          WinnerRule // rule used to pick the song that is kept, the first song in the library if nil
+}
+
+// DuplicateGroup is a set of songs that are the same track.
+type DuplicateGroup struct {
+	SongIDs  []int       // ids of the songs, in library order
+	Survivor int         // id of the song kept by ConsolidateDuplicates
+	Method   MatchMethod // how the first duplicate was found: MatchPath, MatchContent, or MatchMetadata
+}
+
+// FindDuplicates returns groups of songs that have the same path, the same content hash,
+// or the same normalized artist, title, and remixer with a similar length.
+// Groups are ordered by their first song in the library.
+func (l *Library) FindDuplicates(options DuplicateOptions) []DuplicateGroup {
+	if options.LengthTolerance == 0 {
+		options.LengthTolerance = lengthTolerance
+	}
+
+	// union-find over song indexes, so duplicates of duplicates are grouped
+	parent := make([]int, len(l.Songs))
+	methods := make(map[int]MatchMethod)
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(i, j int, method MatchMethod) {
+		rootI, rootJ := find(i), find(j)
+		if rootI == rootJ {
+			return
+		}
+		// keep the earliest song as the root so groups stay in library order
+		root, child := min(rootI, rootJ), max(rootI, rootJ)
+		parent[child] = root
+		if _, exists := methods[root]; !exists {
+			if childMethod, exists := methods[child]; exists {
+				method = childMethod
+			}
+			methods[root] = method
+		}
+	}
+
+	var hashes map[int]string
+	if options.Hash != nil {
+		hashes = songHashes(l.Songs, options.Hash)
+	}
+	keys := []struct {
+		method MatchMethod
+		key    func(i int, song Song) string
+	}{
+		{MatchPath, func(i int, song Song) string { return song.Path }},
+		{MatchContent, func(i int, song Song) string { return hashes[i] }},
+		{MatchMetadata, func(i int, song Song) string {
+			if song.Artist == "" || song.Title == "" {
+				return ""
+			}
+			return normalize(song.Artist) + "\x00" + normalize(song.Title) + "\x00" + normalize(song.Remixer)
+		}},
+	}
+	for _, key := range keys {
+		buckets := make(map[string][]int)
+		for i, song := range l.Songs {
+			if k := key.key(i, song); k != "" {
+				buckets[k] = append(buckets[k], i)
+			}
+		}
+		for _, bucket := range buckets {
+			for a := range bucket {
+				for _, j := range bucket[a+1:] {
+					i := bucket[a]
+					if key.method == MatchMetadata && !withinLength(l.Songs[i], l.Songs[j], options.LengthTolerance) {
+						continue
+					}
+					union(i, j, key.method)
+				}
+			}
+		}
+	}
+
+	groups := make(map[int]*DuplicateGroup)
+	var roots []int
+	for i, song := range l.Songs {
+		root := find(i)
+		if root == i {
+			continue
+		}
+		group, exists := groups[root]
+		if !exists {
+			group = &DuplicateGroup{SongIDs: []int{l.Songs[root].SongID}, Method: methods[root]}
+			groups[root] = group
+			roots = append(roots, root)
+		}
+		group.SongIDs = append(group.SongIDs, song.SongID)
+	}
+
+	var result []DuplicateGroup
+	slices.Sort(roots)
+	for _, root := range roots {
+		group := groups[root]
+		group.Survivor = l.survivor(group.SongIDs, options.Winner)
+		result = append(result, *group)
+	}
+	return result
+}
+
+// ConsolidateDuplicates merges each group of duplicates into its survivor and returns the groups.
+// The survivor gains hot cues and loops of the other songs at positions it doesn't use,
+// and every playlist reference to the other songs is replaced by the survivor.
+func (l *Library) ConsolidateDuplicates(options DuplicateOptions) []DuplicateGroup {
+	groups := l.FindDuplicates(options)
+	if groups == nil {
+		return nil
+	}
+
+	replaced := make(map[int]int)
+	for _, group := range groups {
+		for _, id := range group.SongIDs {
+			if id != group.Survivor {
+				replaced[id] = group.Survivor
+			}
+		}
+	}
+
+	indexes := make(map[int]int)
+	for i, song := range l.Songs {
+		indexes[song.SongID] = i
+	}
+	for _, group := range groups {
+		survivor := l.Songs[indexes[group.Survivor]]
+		for _, id := range group.SongIDs {
+			if id != group.Survivor {
+				policy := MergePolicy{Prefer: PreferBase, UnionCues: true, Tolerance: DefaultTolerance}
+				survivor, _ = mergeSong(survivor, l.Songs[indexes[id]], policy)
+			}
+		}
+		l.Songs[indexes[group.Survivor]] = survivor
+	}
+
+	var songs []Song
+	for _, song := range l.Songs {
+		if _, exists := replaced[song.SongID]; !exists {
+			songs = append(songs, song)
+		}
+	}
+	l.Songs = songs
+	survivors := make(map[int]bool)
+	for _, group := range groups {
+		survivors[group.Survivor] = true
+	}
+	l.Playlists = replaceSongsInPlaylists(l.Playlists, replaced, survivors)
+
+	return groups
+}
+
+// survivor returns the id of the song kept from a group by the winner rule.
+func (l *Library) survivor(ids []int, winner WinnerRule) int {
+	if winner == nil {
+		return ids[0]
+	}
+	var best Song
+	for i, song := range l.Songs {
+		if !slices.Contains(ids, song.SongID) {
+			continue
+		}
+		if song.SongID == ids[0] || winner(song, best) {
+			best = l.Songs[i]
+		}
+	}
+	return best.SongID
+}
+
+// withinLength returns true if both songs have known lengths within tolerance.
+func withinLength(a, b Song, tolerance float32) bool {
+	if a.Length <= 0 || b.Length <= 0 {
+		return false
+	}
+	return math.Abs(float64(a.Length-b.Length)) <= float64(tolerance)
+}
+
+// replaceSongsInPlaylists replaces song ids in every playlist, keeping only
+// the first occurrence of a survivor so duplicates in the same playlist become one entry.
+func replaceSongsInPlaylists(playlists []Playlist, replaced map[int]int, survivors map[int]bool) []Playlist {
+	for i := range playlists {
+		if playlists[i].Songs != nil {
+			songs := []int{}
+			for _, id := range playlists[i].Songs {
+				if survivor, exists := replaced[id]; exists {
+					id = survivor
+				}
+				if survivors[id] && slices.Contains(songs, id) {
+					continue
+				}
+				songs = append(songs, id)
+			}
+			playlists[i].Songs = songs
+		}
+		playlists[i].SubPlaylists = replaceSongsInPlaylists(playlists[i].SubPlaylists, replaced, survivors)
+	}
+	return playlists
+}
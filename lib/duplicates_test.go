@@ -0,0 +1,92 @@
+package lib_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func duplicateLibrary() lib.Library {
+	return lib.Library{
+		Songs: []lib.Song{
+			{SongID: 1, Title: "One", Artist: "Artist", Path: "/a/one.mp3", Length: 300, PlayCount: 1,
+				Cues: []lib.HotCue{{Offset: 10, Position: 1}}},
+			{SongID: 2, Title: "Two", Artist: "Artist", Path: "/a/two.mp3", Length: 200},
+			{SongID: 3, Title: "one", Artist: "ARTIST", Path: "/b/one.mp3", Length: 300.5, PlayCount: 5,
+				Cues: []lib.HotCue{{Offset: 20, Position: 1}, {Offset: 30, Position: 2}}},
+			{SongID: 4, Title: "Two", Artist: "Artist", Path: "/a/two.mp3", Length: 100},
+			{SongID: 5, Title: "One", Artist: "Artist", Path: "/c/one.mp3", Length: 250},
+			{SongID: 6, Title: "Six", Path: "/c/six.mp3"},
+			{SongID: 7, Title: "Seven", Path: "/c/seven.mp3"},
+		},
+		Playlists: []lib.Playlist{
+			{Name: "folder", SubPlaylists: []lib.Playlist{
+				{Name: "playlist", Songs: []int{1, 2, 3, 4, 6, 6}},
+			}},
+			{Name: "other", Songs: []int{3, 5}},
+		},
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	hash := func(song lib.Song) (string, error) {
+		if song.SongID == 6 || song.SongID == 7 {
+			return "same", nil
+		}
+		return "", errors.New("not hashed")
+	}
+	tests := []struct {
+		name     string
+		options  lib.DuplicateOptions
+		expected []lib.DuplicateGroup
+	}{
+		{"Default", lib.DuplicateOptions{}, []lib.DuplicateGroup{
+			{SongIDs: []int{1, 3}, Survivor: 1, Method: lib.MatchMetadata},
+			{SongIDs: []int{2, 4}, Survivor: 2, Method: lib.MatchPath},
+		}},
+		{"Hash", lib.DuplicateOptions{Hash: hash}, []lib.DuplicateGroup{
+			{SongIDs: []int{1, 3}, Survivor: 1, Method: lib.MatchMetadata},
+			{SongIDs: []int{2, 4}, Survivor: 2, Method: lib.MatchPath},
+			{SongIDs: []int{6, 7}, Survivor: 6, Method: lib.MatchContent},
+		}},
+		{"LengthTolerance", lib.DuplicateOptions{LengthTolerance: 60}, []lib.DuplicateGroup{
+			{SongIDs: []int{1, 3, 5}, Survivor: 1, Method: lib.MatchMetadata},
+			{SongIDs: []int{2, 4}, Survivor: 2, Method: lib.MatchPath},
+		}},
+		{"Winner", lib.DuplicateOptions{Winner: lib.MostPlayedSong}, []lib.DuplicateGroup{
+			{SongIDs: []int{1, 3}, Survivor: 3, Method: lib.MatchMetadata},
+			{SongIDs: []int{2, 4}, Survivor: 2, Method: lib.MatchPath},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			library := duplicateLibrary()
+			assert.Equal(t, test.expected, library.FindDuplicates(test.options), "Duplicates should be grouped.")
+			assert.Equal(t, duplicateLibrary(), library, "Finding duplicates should not modify the library.")
+		})
+	}
+}
+
+func TestConsolidateDuplicates(t *testing.T) {
+	library := duplicateLibrary()
+	groups := library.ConsolidateDuplicates(lib.DuplicateOptions{Winner: lib.MostCuesSong})
+	assert.Len(t, groups, 2, "Both groups should be consolidated.")
+
+	var ids []int
+	for _, song := range library.Songs {
+		ids = append(ids, song.SongID)
+	}
+	assert.Equal(t, []int{2, 3, 5, 6, 7}, ids, "Duplicates should be removed.")
+	assert.Equal(t, []lib.HotCue{{Offset: 20, Position: 1}, {Offset: 30, Position: 2}},
+		library.Songs[1].Cues, "Survivor should keep its own cues at used positions.")
+	assert.Equal(t, []lib.Playlist{
+		{Name: "folder", SubPlaylists: []lib.Playlist{
+			{Name: "playlist", Songs: []int{3, 2, 6, 6}},
+		}},
+		{Name: "other", Songs: []int{3, 5}},
+	}, library.Playlists, "Playlists should refer to the survivors.")
+	assert.Empty(t, library.Validate(), "Consolidated library should be valid.")
+}
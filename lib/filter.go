@@ -0,0 +1,208 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseFilter parses a filter expression into a predicate for songs in the library.
+//
+// An expression compares fields to values, like `genre ~ house and bpm > 120 and cues > 0`.
+// Fields are those accepted by Compare, operators are those of Operator, and values are
+// numbers, words, or double-quoted strings. Comparisons can be combined with "and", "or",
+// "not", and parentheses, where "and" binds tighter than "or".
+func (l *Library) ParseFilter(expression string) (Predicate, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing filter: %v", err)
+	}
+	p := filterParser{library: l, tokens: tokens}
+	predicate, err := p.parseOr()
+	if err == nil && p.position < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.position].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing filter: %v", err)
+	}
+	return predicate, nil
+}
+
+type filterTokenType int
+
+const (
+	tokenWord filterTokenType = iota
+	tokenString
+	tokenOperator
+	tokenOpen
+	tokenClose
+)
+
+type filterToken struct {
+	tokenType filterTokenType
+	text      string
+}
+
+func tokenizeFilter(expression string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{tokenOpen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{tokenClose, ")"})
+			i++
+		case r == '"':
+			// find the closing quote, skipping escaped characters
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string starting at %d", i)
+			}
+			text, err := strconv.Unquote(string(runes[i : end+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", string(runes[i:end+1]))
+			}
+			tokens = append(tokens, filterToken{tokenString, text})
+			i = end + 1
+		case strings.ContainsRune("=!<>~", r):
+			end := i + 1
+			if end < len(runes) && runes[end] == '=' && r != '~' && r != '=' {
+				end++
+			}
+			operator := string(runes[i:end])
+			if operator == "!" {
+				return nil, fmt.Errorf("unknown operator %q", operator)
+			}
+			tokens = append(tokens, filterToken{tokenOperator, operator})
+			i = end
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("()\"=!<>~", runes[end]) {
+				end++
+			}
+			tokens = append(tokens, filterToken{tokenWord, string(runes[i:end])})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	library  *Library
+	tokens   []filterToken
+	position int
+}
+
+func (p *filterParser) peek() (filterToken, bool) {
+	if p.position >= len(p.tokens) {
+		return filterToken{}, false
+	}
+	return p.tokens[p.position], true
+}
+
+// keyword consumes the next token if it is the keyword, case-insensitive.
+func (p *filterParser) keyword(keyword string) bool {
+	token, ok := p.peek()
+	if ok && token.tokenType == tokenWord && strings.EqualFold(token.text, keyword) {
+		p.position++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (Predicate, error) {
+	predicate, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	predicates := []Predicate{predicate}
+	for p.keyword("or") {
+		predicate, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, predicate)
+	}
+	if len(predicates) == 1 {
+		return predicates[0], nil
+	}
+	return Or(predicates...), nil
+}
+
+func (p *filterParser) parseAnd() (Predicate, error) {
+	predicate, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	predicates := []Predicate{predicate}
+	for p.keyword("and") {
+		predicate, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, predicate)
+	}
+	if len(predicates) == 1 {
+		return predicates[0], nil
+	}
+	return And(predicates...), nil
+}
+
+func (p *filterParser) parseUnary() (Predicate, error) {
+	if p.keyword("not") {
+		predicate, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(predicate), nil
+	}
+
+	token, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	if token.tokenType == tokenOpen {
+		p.position++
+		predicate, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		token, ok := p.peek()
+		if !ok || token.tokenType != tokenClose {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.position++
+		return predicate, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (Predicate, error) {
+	if p.position+3 > len(p.tokens) {
+		return nil, fmt.Errorf("incomplete comparison")
+	}
+	field, operator, value := p.tokens[p.position], p.tokens[p.position+1], p.tokens[p.position+2]
+	if field.tokenType != tokenWord {
+		return nil, fmt.Errorf("expected a field, got %q", field.text)
+	}
+	if operator.tokenType != tokenOperator {
+		return nil, fmt.Errorf("expected an operator after %s, got %q", field.text, operator.text)
+	}
+	if value.tokenType != tokenWord && value.tokenType != tokenString {
+		return nil, fmt.Errorf("expected a value after %s %s, got %q", field.text, operator.text, value.text)
+	}
+	p.position += 3
+	return p.library.Compare(field.text, Operator(operator.text), value.text)
+}
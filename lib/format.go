@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrUnknownFormat is returned when a format isn't registered or can't be detected.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// ErrUnsupported is returned when a format doesn't support an operation, like export.
+var ErrUnsupported = errors.New("operation not supported by format")
+
+// Importer imports a library from a path.
+type Importer interface {
+	Import(path string) (Library, error)
+}
+
+// ContextImporter is an Importer that can be canceled and
+// reports the problems it finds with individual songs.
+type ContextImporter interface {
+	Importer
+	ImportContext(ctx context.Context, path string) (Library, Report, error)
+}
+
+// Exporter exports a library to a path.
+type Exporter interface {
+	Export(library *Library, path string) error
+}
+
+// Capabilities are the library features a format can store.
+type Capabilities struct {
+	Smartlists          bool // rule-based playlists
+	Loops               bool // saved loops
+	MultipleGridMarkers bool // beatgrids with more than one marker
+	Colors              bool // song, cue, and loop colors
+}
+
+// Format is a library format that can be imported, exported, or both.
+type Format struct {
+	Name         string       // short unique name, like "engine" or "rbxml"
+	Description  string       // human-readable name
+	Capabilities Capabilities // features the format can store
+	Importer     Importer     // nil if the format can't be imported
+	Exporter     Exporter     // nil if the format can't be exported
+	Detect       Detector     // detects the format at a path, can be nil
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = make(map[string]Format)
+)
+
+// Register makes a format available by name. It is meant to be called from
+// the init function of the package implementing the format, and panics if
+// a format with the same name is already registered.
+func Register(format Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if format.Name == "" {
+		panic("lib: Register format without a name")
+	}
+	if _, exists := formats[format.Name]; exists {
+		panic("lib: Register called twice for format " + format.Name)
+	}
+	formats[format.Name] = format
+}
+
+// Lookup returns the registered format with the given name.
+func Lookup(name string) (Format, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	format, exists := formats[name]
+	if !exists {
+		return Format{}, fmt.Errorf("%w: %s", ErrUnknownFormat, name)
+	}
+	return format, nil
+}
+
+// Formats returns every registered format sorted by name.
+func Formats() []Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	var list []Format
+	for _, format := range formats {
+		list = append(list, format)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// DetectFormat returns the registered format most confidently detected at
+// a path, along with the Detection, whose Path is the library to import.
+func DetectFormat(path string) (Format, Detection, error) {
+	for _, detection := range Detect(path) {
+		format, err := Lookup(detection.Format)
+		if err == nil {
+			return format, detection, nil
+		}
+	}
+	return Format{}, Detection{}, fmt.Errorf("%w: could not detect format of %s", ErrUnknownFormat, path)
+}
+
+// Import imports a library with the named format, detecting
+// the format from the path if the name is empty.
+func Import(name string, path string) (Library, error) {
+	var format Format
+	var err error
+	if name == "" {
+		var detection Detection
+		format, detection, err = DetectFormat(path)
+		path = detection.Path
+	} else {
+		format, err = Lookup(name)
+	}
+	if err != nil {
+		return Library{}, err
+	}
+	if format.Importer == nil {
+		return Library{}, fmt.Errorf("%w: %s can't be imported", ErrUnsupported, format.Name)
+	}
+	return format.Importer.Import(path)
+}
+
+// Export exports a library with the named format.
+func Export(library *Library, name string, path string) error {
+	format, err := Lookup(name)
+	if err != nil {
+		return err
+	}
+	if format.Exporter == nil {
+		return fmt.Errorf("%w: %s can't be exported", ErrUnsupported, format.Name)
+	}
+	if !format.Capabilities.Smartlists && hasSmartPlaylists(library.Playlists) {
+		// export a copy with static playlists, leaving the caller's library unchanged
+		static := *library
+		static.Playlists = clonePlaylists(library.Playlists)
+		err := static.MaterializeSmartPlaylists(time.Now())
+		if err != nil {
+			return err
+		}
+		library = &static
+	}
+	return format.Exporter.Export(library, path)
+}
+
+// Convert imports a library from src with the format named from, detecting
+// the format if from is empty, and exports it to dst with the format named to.
+func Convert(from string, src string, to string, dst string) error {
+	library, err := Import(from, src)
+	if err != nil {
+		return err
+	}
+	return Export(&library, to, dst)
+}
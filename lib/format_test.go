@@ -0,0 +1,80 @@
+package lib_test
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryFormat imports and exports libraries to memory by path
+type memoryFormat map[string]lib.Library
+
+func (m memoryFormat) Import(path string) (lib.Library, error) {
+	library, exists := m[path]
+	if !exists {
+		return lib.Library{}, errors.New("not found")
+	}
+	return library, nil
+}
+
+func (m memoryFormat) Export(library *lib.Library, path string) error {
+	m[path] = *library
+	return nil
+}
+
+var memory = memoryFormat{}
+
+func init() {
+	lib.Register(lib.Format{
+		Name:     "memory",
+		Importer: memory,
+		Exporter: memory,
+		Detect: func(path string) lib.Detection {
+			if strings.HasPrefix(path, "memory:") {
+				return lib.Detection{Confidence: 1}
+			}
+			return lib.Detection{}
+		},
+	})
+	lib.Register(lib.Format{Name: "readonly", Importer: memory})
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	assert.Panics(t, func() { lib.Register(lib.Format{Name: "memory"}) },
+		"Registering a format twice should panic.")
+}
+
+func TestLookup(t *testing.T) {
+	format, err := lib.Lookup("memory")
+	assert.Nil(t, err, "Registered format should be found.")
+	assert.Equal(t, "memory", format.Name, "Lookup should return the named format.")
+
+	_, err = lib.Lookup("missing")
+	assert.ErrorIs(t, err, lib.ErrUnknownFormat, "Unregistered format should return ErrUnknownFormat.")
+}
+
+func TestDetectFormat(t *testing.T) {
+	format, detection, err := lib.DetectFormat("memory:library")
+	assert.Nil(t, err, "Detectable path should return no errors.")
+	assert.Equal(t, "memory", format.Name, "Path should be detected as the memory format.")
+	assert.Equal(t, "memory:library", detection.Path, "Detection path should default to the given path.")
+
+	_, _, err = lib.DetectFormat(filepath.Join("invalid", "path"))
+	assert.ErrorIs(t, err, lib.ErrUnknownFormat, "Undetectable path should return ErrUnknownFormat.")
+}
+
+func TestConvert(t *testing.T) {
+	library := lib.Library{Songs: []lib.Song{{SongID: 1, Title: "Song"}}}
+	memory["memory:src"] = library
+
+	err := lib.Convert("", "memory:src", "memory", "memory:dst")
+	assert.Nil(t, err, "Valid conversion should return no errors.")
+	assert.Equal(t, library, memory["memory:dst"], "Converted library should match the source.")
+
+	err = lib.Convert("memory", "memory:src", "readonly", "memory:dst")
+	assert.ErrorIs(t, err, lib.ErrUnsupported, "Exporting an import-only format should return ErrUnsupported.")
+}
@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultBeatsPerBar is the number of beats in a bar, and the note value of a beat,
+// of markers without a time signature.
+const DefaultBeatsPerBar = 4
+
+// bpmTolerance is the largest difference between bpms considered equal,
+// so bpms computed from sample offsets like 133.99999999999997 equal 134.
+const bpmTolerance = 1e-6
+
+// gridTolerance is the largest distance in seconds from a beat that a marker can be
+// and still be considered on it.
+const gridTolerance = 0.001
+
+// Beats returns the number of beats in a bar of the marker.
+func (m Marker) Beats() int {
+	if m.BeatsPerBar == 0 {
+		return DefaultBeatsPerBar
+	}
+	return m.BeatsPerBar
+}
+
+// Unit returns the note value of a beat of the marker.
+func (m Marker) Unit() int {
+	if m.BeatUnit == 0 {
+		return DefaultBeatsPerBar
+	}
+	return m.BeatUnit
+}
+
+// TimeSignature returns the marker's time signature, like "4/4" or "7/8".
+func (m Marker) TimeSignature() string {
+	return fmt.Sprintf("%d/%d", m.Beats(), m.Unit())
+}
+
+// SetTimeSignature sets the marker's time signature from a string like "3/4",
+// storing the default of 4/4 as zeros.
+func (m *Marker) SetTimeSignature(signature string) error {
+	upper, lower, found := strings.Cut(signature, "/")
+	beats, err := strconv.Atoi(strings.TrimSpace(upper))
+	if err != nil || !found || beats <= 0 {
+		return fmt.Errorf("invalid time signature %q", signature)
+	}
+	unit, err := strconv.Atoi(strings.TrimSpace(lower))
+	if err != nil || unit <= 0 {
+		return fmt.Errorf("invalid time signature %q", signature)
+	}
+	m.BeatsPerBar, m.BeatUnit = beats, unit
+	if beats == DefaultBeatsPerBar {
+		m.BeatsPerBar = 0
+	}
+	if unit == DefaultBeatsPerBar {
+		m.BeatUnit = 0
+	}
+	return nil
+}
+
+// beatLength returns the length of a beat of the marker in seconds.
+func (m Marker) beatLength() float64 {
+	return 60 / m.Bpm
+}
+
+// GridPosition is a position in a beatgrid.
+type GridPosition struct {
+	Beat      float64 // beats since the first marker, fractional, negative before it
+	Bar       int     // bars since the bar of the first marker, negative before it
+	BeatInBar int     // beat in the bar, 0-indexed
+}
+
+// gridBeats returns the beat index of each marker, counted from the first marker.
+// Each marker starts on the beat nearest to where the previous marker's tempo puts it.
+func gridBeats(grid []Marker) []float64 {
+	beats := make([]float64, len(grid))
+	for i := 1; i < len(grid); i++ {
+		length := grid[i].StartPosition - grid[i-1].StartPosition
+		beats[i] = beats[i-1] + math.Round(length/grid[i-1].beatLength())
+	}
+	return beats
+}
+
+// gridBars returns the bar index of each marker's bar, counted from the first marker's bar.
+func gridBars(grid []Marker, beats []float64) []int {
+	bars := make([]int, len(grid))
+	for i := 1; i < len(grid); i++ {
+		previous := grid[i-1]
+		// beats from the start of the previous marker's bar to the start of this marker's bar,
+		// where a partial bar left by a time signature change counts as a bar
+		between := previous.BeatNumber + int(beats[i]-beats[i-1]) - grid[i].BeatNumber
+		bars[i] = bars[i-1] + ceilDiv(between, previous.Beats())
+	}
+	return bars
+}
+
+// markerAt returns the index of the marker in effect at a time,
+// the first marker for times before the grid.
+func markerAt(grid []Marker, time float64) int {
+	index := 0
+	for i, marker := range grid {
+		if marker.StartPosition <= time {
+			index = i
+		}
+	}
+	return index
+}
+
+// PositionAt returns the beat and bar of a grid at a time in seconds. Times before the
+// first marker are extrapolated from it. It returns false if the grid is empty.
+func PositionAt(grid []Marker, time float64) (GridPosition, bool) {
+	if len(grid) == 0 {
+		return GridPosition{}, false
+	}
+	beats := gridBeats(grid)
+	bars := gridBars(grid, beats)
+	i := markerAt(grid, time)
+	marker := grid[i]
+
+	since := (time - marker.StartPosition) / marker.beatLength()
+	inBar := marker.BeatNumber + int(math.Floor(since+gridTolerance/marker.beatLength()))
+	return GridPosition{
+		Beat:      beats[i] + since,
+		Bar:       bars[i] + floorDiv(inBar, marker.Beats()),
+		BeatInBar: mod(inBar, marker.Beats()),
+	}, true
+}
+
+// BeatTime returns the time in seconds of a beat index counted from the first marker,
+// as returned in GridPosition.Beat. It returns false if the grid is empty.
+func BeatTime(grid []Marker, beat float64) (float64, bool) {
+	if len(grid) == 0 {
+		return 0, false
+	}
+	beats := gridBeats(grid)
+	index := 0
+	for i := range grid {
+		if beats[i] <= beat {
+			index = i
+		}
+	}
+	marker := grid[index]
+	return marker.StartPosition + (beat-beats[index])*marker.beatLength(), true
+}
+
+// SimplifyGrid returns a grid without markers that continue the previous marker's grid:
+// markers with the same bpm and time signature that fall on one of its beats, with the
+// beat in bar it would give them. The grid isn't modified.
+func SimplifyGrid(grid []Marker) []Marker {
+	if len(grid) == 0 {
+		return nil
+	}
+	simplified := []Marker{grid[0]}
+	for _, marker := range grid[1:] {
+		last := simplified[len(simplified)-1]
+		if math.Abs(marker.Bpm-last.Bpm) > bpmTolerance || marker.TimeSignature() != last.TimeSignature() {
+			simplified = append(simplified, marker)
+			continue
+		}
+		beats := (marker.StartPosition - last.StartPosition) / last.beatLength()
+		offBeat := math.Abs(beats-math.Round(beats)) * last.beatLength()
+		inBar := mod(last.BeatNumber+int(math.Round(beats)), last.Beats())
+		if offBeat > gridTolerance || inBar != marker.BeatNumber {
+			simplified = append(simplified, marker)
+		}
+	}
+	return simplified
+}
+
+// mod returns the non-negative remainder of a divided by b.
+func mod(a, b int) int {
+	return (a%b + b) % b
+}
+
+// floorDiv returns a divided by b, rounded down.
+func floorDiv(a, b int) int {
+	return int(math.Floor(float64(a) / float64(b)))
+}
+
+// ceilDiv returns a divided by b, rounded up.
+func ceilDiv(a, b int) int {
+	return int(math.Ceil(float64(a) / float64(b)))
+}
@@ -0,0 +1,130 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeSignature(t *testing.T) {
+	tests := []struct {
+		signature   string
+		beatsPerBar int
+		beatUnit    int
+	}{
+		{"4/4", 0, 0},
+		{"3/4", 3, 0},
+		{"7/8", 7, 8},
+		{" 6 / 8 ", 6, 8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.signature, func(t *testing.T) {
+			var marker lib.Marker
+			err := marker.SetTimeSignature(test.signature)
+			assert.Nil(t, err)
+			assert.Equal(t, test.beatsPerBar, marker.BeatsPerBar)
+			assert.Equal(t, test.beatUnit, marker.BeatUnit)
+		})
+	}
+
+	assert.Equal(t, "4/4", lib.Marker{}.TimeSignature(), "Markers should default to 4/4.")
+	assert.Equal(t, "7/8", lib.Marker{BeatsPerBar: 7, BeatUnit: 8}.TimeSignature())
+	for _, signature := range []string{"", "4", "0/4", "4/0", "a/4", "4/-4"} {
+		var marker lib.Marker
+		assert.NotNil(t, marker.SetTimeSignature(signature), "Parsing %q should return an error.", signature)
+	}
+}
+
+func TestPositionAt(t *testing.T) {
+	// 120 bpm in 4/4 starting on beat 2 of the bar, then 3/4 at 60 bpm starting a bar at 10s
+	grid := []lib.Marker{
+		{StartPosition: 1, Bpm: 120, BeatNumber: 1},
+		{StartPosition: 10, Bpm: 60, BeatsPerBar: 3},
+	}
+
+	tests := []struct {
+		name     string
+		time     float64
+		expected lib.GridPosition
+	}{
+		{"FirstMarker", 1, lib.GridPosition{Beat: 0, Bar: 0, BeatInBar: 1}},
+		{"Between", 1.25, lib.GridPosition{Beat: 0.5, Bar: 0, BeatInBar: 1}},
+		{"NextBar", 2.5, lib.GridPosition{Beat: 3, Bar: 1, BeatInBar: 0}},
+		{"BeforeGrid", 0, lib.GridPosition{Beat: -2, Bar: -1, BeatInBar: 3}},
+		{"ChangeMarker", 10, lib.GridPosition{Beat: 18, Bar: 5, BeatInBar: 0}},
+		{"AfterChange", 13.5, lib.GridPosition{Beat: 21.5, Bar: 6, BeatInBar: 0}},
+		{"ShortlyBeforeBeat", 11.9999, lib.GridPosition{Beat: 19.9999, Bar: 5, BeatInBar: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			position, ok := lib.PositionAt(grid, test.time)
+			assert.True(t, ok)
+			assert.InDelta(t, test.expected.Beat, position.Beat, 1e-9)
+			assert.Equal(t, test.expected.Bar, position.Bar)
+			assert.Equal(t, test.expected.BeatInBar, position.BeatInBar)
+		})
+	}
+
+	_, ok := lib.PositionAt(nil, 1)
+	assert.False(t, ok, "Empty grids have no positions.")
+}
+
+func TestBeatTime(t *testing.T) {
+	grid := []lib.Marker{
+		{StartPosition: 1, Bpm: 120, BeatNumber: 1},
+		{StartPosition: 10, Bpm: 60, BeatsPerBar: 3},
+	}
+	for _, beat := range []float64{-2, 0, 0.5, 3, 17, 18, 21.5} {
+		time, ok := lib.BeatTime(grid, beat)
+		assert.True(t, ok)
+		position, _ := lib.PositionAt(grid, time)
+		assert.InDelta(t, beat, position.Beat, 1e-9, "BeatTime should be the inverse of PositionAt.")
+	}
+	time, _ := lib.BeatTime(grid, 20)
+	assert.InDelta(t, 12, time, 1e-9)
+
+	_, ok := lib.BeatTime(nil, 1)
+	assert.False(t, ok, "Empty grids have no beats.")
+}
+
+func TestSimplifyGrid(t *testing.T) {
+	tests := []struct {
+		name     string
+		grid     []lib.Marker
+		expected []lib.Marker
+	}{
+		{"Empty", nil, nil},
+		{"Single", []lib.Marker{{StartPosition: 1, Bpm: 120}}, []lib.Marker{{StartPosition: 1, Bpm: 120}}},
+		{"Continuing", []lib.Marker{
+			{StartPosition: 1, Bpm: 120},
+			{StartPosition: 3, Bpm: 120},
+			{StartPosition: 4.5, Bpm: 119.9999999999, BeatNumber: 3},
+		}, []lib.Marker{{StartPosition: 1, Bpm: 120}}},
+		{"TempoChange", []lib.Marker{
+			{StartPosition: 1, Bpm: 120},
+			{StartPosition: 3, Bpm: 128},
+			{StartPosition: 6.75, Bpm: 128},
+		}, []lib.Marker{{StartPosition: 1, Bpm: 120}, {StartPosition: 3, Bpm: 128}}},
+		{"OffBeat", []lib.Marker{
+			{StartPosition: 1, Bpm: 120},
+			{StartPosition: 3.1, Bpm: 120},
+		}, []lib.Marker{{StartPosition: 1, Bpm: 120}, {StartPosition: 3.1, Bpm: 120}}},
+		{"BarShift", []lib.Marker{
+			{StartPosition: 1, Bpm: 120},
+			{StartPosition: 3, Bpm: 120, BeatNumber: 2},
+		}, []lib.Marker{{StartPosition: 1, Bpm: 120}, {StartPosition: 3, Bpm: 120, BeatNumber: 2}}},
+		{"TimeSignatureChange", []lib.Marker{
+			{StartPosition: 1, Bpm: 120},
+			{StartPosition: 3, Bpm: 120, BeatsPerBar: 3},
+		}, []lib.Marker{{StartPosition: 1, Bpm: 120}, {StartPosition: 3, Bpm: 120, BeatsPerBar: 3}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, lib.SimplifyGrid(test.grid))
+		})
+	}
+}
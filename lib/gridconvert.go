@@ -0,0 +1,274 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+)
+
+// Anchor is a beat of a grid at a known time. Engine DJ stores grids as anchors,
+// with beat indexes counted from the first downbeat of the song and sample offsets.
+type Anchor struct {
+	Beat        int     // beat index, negative before the first downbeat
+	Time        float64 // time of the beat in seconds, can be negative
+	BeatsPerBar int     // time signature from this anchor on, like Marker.BeatsPerBar, 0 for 4
+	BeatUnit    int     // note value of a beat from this anchor on, like Marker.BeatUnit, 0 for 4
+}
+
+// AnchorsToGrid converts anchors to a grid with a marker at every anchor but the last,
+// which only ends the grid. Bars are counted from a downbeat at beat 0 in the time
+// signature of the first anchor, and an anchor that changes the time signature starts
+// a bar. Anchors must be ordered, with increasing beats and times.
+func AnchorsToGrid(anchors []Anchor) ([]Marker, error) {
+	var grid []Marker
+	for i := range len(anchors) - 1 {
+		beats := anchors[i+1].Beat - anchors[i].Beat
+		length := anchors[i+1].Time - anchors[i].Time
+		if beats <= 0 || !(length > 0) {
+			return nil, fmt.Errorf("anchor %d at beat %d and %gs isn't before the next anchor", i, anchors[i].Beat, anchors[i].Time)
+		}
+		marker := Marker{
+			StartPosition: anchors[i].Time,
+			Bpm:           60 * float64(beats) / length,
+			BeatsPerBar:   anchors[i].BeatsPerBar,
+			BeatUnit:      anchors[i].BeatUnit,
+		}
+		marker.BeatNumber = mod(anchors[i].Beat, marker.Beats())
+		if i > 0 {
+			marker.BeatNumber = continueBar(grid[i-1], anchors[i].Beat-anchors[i-1].Beat, marker)
+		}
+		grid = append(grid, marker)
+	}
+	return grid, nil
+}
+
+// continueBar returns the beat in bar of a marker that starts beats after previous: the
+// beat previous's bars reach if the time signature is the same, or a downbeat if it changes.
+func continueBar(previous Marker, beats int, marker Marker) int {
+	if marker.TimeSignature() != previous.TimeSignature() {
+		return 0
+	}
+	return mod(previous.BeatNumber+beats, previous.Beats())
+}
+
+// GridToAnchors converts a grid to anchors, one for each marker and a last anchor on the
+// first beat at or after end, usually the length of the song. Beat indexes start at the
+// first marker's beat in bar and anchors keep the time signature of their marker, so bars
+// stay in place as long as markers continue the bars of the previous marker, or change
+// the time signature on a downbeat.
+func GridToAnchors(grid []Marker, end float64) []Anchor {
+	if len(grid) == 0 {
+		return nil
+	}
+	beats := gridBeats(grid)
+	var anchors []Anchor
+	for i, marker := range grid {
+		anchors = append(anchors, Anchor{
+			Beat:        int(beats[i]) + grid[0].BeatNumber,
+			Time:        marker.StartPosition,
+			BeatsPerBar: marker.BeatsPerBar,
+			BeatUnit:    marker.BeatUnit,
+		})
+	}
+	last := lastBeat(grid, beats, end)
+	time, _ := BeatTime(grid, float64(last))
+	lastMarker := grid[len(grid)-1]
+	return append(anchors, Anchor{
+		Beat:        last + grid[0].BeatNumber,
+		Time:        time,
+		BeatsPerBar: lastMarker.BeatsPerBar,
+		BeatUnit:    lastMarker.BeatUnit,
+	})
+}
+
+// lastBeat returns the index of the first beat at or after end,
+// and at least one beat after the last marker.
+func lastBeat(grid []Marker, beats []float64, end float64) int {
+	position, _ := PositionAt(grid, end)
+	return max(int(math.Ceil(position.Beat-gridTolerance)), int(beats[len(beats)-1])+1)
+}
+
+// TrimGrid returns a grid starting at the first beat at or after start, for software that
+// can't store markers before the start of the song. The marker in effect at start is moved
+// forward to that beat, and markers that end before it are dropped.
+func TrimGrid(grid []Marker, start float64) []Marker {
+	var trimmed []Marker
+	for i, marker := range grid {
+		if marker.StartPosition < start {
+			beats := math.Ceil((start-marker.StartPosition)/marker.beatLength() - gridTolerance/marker.beatLength())
+			marker.StartPosition += beats * marker.beatLength()
+			marker.BeatNumber = mod(marker.BeatNumber+int(beats), marker.Beats())
+			if i < len(grid)-1 && marker.StartPosition >= grid[i+1].StartPosition-gridTolerance {
+				continue
+			}
+		}
+		trimmed = append(trimmed, marker)
+	}
+	return trimmed
+}
+
+// SegmentMarker is a grid marker of the model used by Serato: every marker but the last
+// stores the number of beats until the next marker, and the last stores the bpm of the
+// rest of the song. Bars start at the first marker.
+type SegmentMarker struct {
+	Time        float64 // start position in seconds
+	Beats       int     // beats until the next marker, 0 for the last marker
+	Bpm         float64 // beats per minute of the last marker, 0 for the others
+	BeatsPerBar int     // time signature from this marker on, like Marker.BeatsPerBar, 0 for 4
+	BeatUnit    int     // note value of a beat from this marker on, like Marker.BeatUnit, 0 for 4
+}
+
+// SegmentsToGrid converts segment markers to a grid with a downbeat at the first marker.
+// A marker that changes the time signature starts a bar.
+func SegmentsToGrid(markers []SegmentMarker) ([]Marker, error) {
+	if len(markers) == 0 {
+		return nil, nil
+	}
+	var grid []Marker
+	for i, marker := range markers {
+		gridMarker := Marker{StartPosition: marker.Time, Bpm: marker.Bpm, BeatsPerBar: marker.BeatsPerBar, BeatUnit: marker.BeatUnit}
+		if i < len(markers)-1 {
+			length := markers[i+1].Time - marker.Time
+			if marker.Beats <= 0 || !(length > 0) {
+				return nil, fmt.Errorf("segment marker %d at %gs isn't before the next marker", i, marker.Time)
+			}
+			gridMarker.Bpm = 60 * float64(marker.Beats) / length
+		} else if !(marker.Bpm > 0) {
+			return nil, fmt.Errorf("last segment marker at %gs has a bpm of %g", marker.Time, marker.Bpm)
+		}
+		if i > 0 {
+			gridMarker.BeatNumber = continueBar(grid[i-1], markers[i-1].Beats, gridMarker)
+		}
+		grid = append(grid, gridMarker)
+	}
+	return grid, nil
+}
+
+// GridToSegments converts a grid to segment markers with the time signatures of their
+// markers. The first marker is moved back to the downbeat of its bar, since bars start at
+// the first segment marker.
+func GridToSegments(grid []Marker) []SegmentMarker {
+	if len(grid) == 0 {
+		return nil
+	}
+	beats := gridBeats(grid)
+	var markers []SegmentMarker
+	for i, marker := range grid {
+		segment := SegmentMarker{Time: marker.StartPosition, BeatsPerBar: marker.BeatsPerBar, BeatUnit: marker.BeatUnit}
+		if i < len(grid)-1 {
+			segment.Beats = int(beats[i+1] - beats[i])
+		} else {
+			segment.Bpm = marker.Bpm
+		}
+		markers = append(markers, segment)
+	}
+
+	markers[0].Time -= float64(grid[0].BeatNumber) * grid[0].beatLength()
+	if len(markers) > 1 {
+		markers[0].Beats += grid[0].BeatNumber
+	}
+	return markers
+}
+
+// ExpandGrid returns a grid with a marker on every beat from the first marker to the first
+// beat at or after end, like Rekordbox stores grids of songs with a changing tempo.
+func ExpandGrid(grid []Marker, end float64) []Marker {
+	if len(grid) == 0 {
+		return nil
+	}
+	times := beatTimes(grid, lastBeat(grid, gridBeats(grid), end))
+	var expanded []Marker
+	for beat := range len(times) - 1 {
+		expanded = append(expanded, beatMarker(grid, times[beat], times[beat+1]))
+	}
+	return expanded
+}
+
+// beatTimes returns the time of every beat of a grid from the first marker to the last beat.
+func beatTimes(grid []Marker, last int) []float64 {
+	times := make([]float64, last+1)
+	for beat := range times {
+		times[beat], _ = BeatTime(grid, float64(beat))
+	}
+	return times
+}
+
+// beatMarker returns a marker at the beat of a grid at time, with the bpm that reaches the beat at next.
+func beatMarker(grid []Marker, time float64, next float64) Marker {
+	position, _ := PositionAt(grid, time)
+	marker := grid[markerAt(grid, time)]
+	return Marker{
+		StartPosition: time,
+		Bpm:           60 / (next - time),
+		BeatNumber:    position.BeatInBar,
+		BeatsPerBar:   marker.BeatsPerBar,
+		BeatUnit:      marker.BeatUnit,
+	}
+}
+
+// gridBreaks returns the beat indexes of markers that change the time signature or
+// don't continue the bars of the previous marker, which resampling has to keep.
+func gridBreaks(grid []Marker, beats []float64) map[int]bool {
+	breaks := make(map[int]bool)
+	for i := 1; i < len(grid); i++ {
+		previous := grid[i-1]
+		inBar := mod(previous.BeatNumber+int(beats[i]-beats[i-1]), previous.Beats())
+		if grid[i].TimeSignature() != previous.TimeSignature() || grid[i].BeatNumber != inBar {
+			breaks[int(beats[i])] = true
+		}
+	}
+	return breaks
+}
+
+// ConstantTempo returns the single marker equivalent to a grid if every beat from the first
+// marker to end is within tolerance seconds of the grid, and false otherwise.
+func ConstantTempo(grid []Marker, end float64, tolerance float64) (Marker, bool) {
+	if len(grid) == 0 {
+		return Marker{}, false
+	}
+	resampled := ResampleGrid(grid, end, tolerance)
+	if len(resampled) != 1 {
+		return Marker{}, false
+	}
+	return resampled[0], true
+}
+
+// ResampleGrid returns a grid with as few markers as it can find where every beat from the
+// first marker to the first beat at or after end is within maxError seconds of the original
+// grid. Markers that change the time signature or the bars are kept. It is used to turn
+// per-beat grids of songs with a changing tempo into grids for software with fewer markers.
+func ResampleGrid(grid []Marker, end float64, maxError float64) []Marker {
+	if len(grid) == 0 {
+		return nil
+	}
+	beats := gridBeats(grid)
+	breaks := gridBreaks(grid, beats)
+	last := lastBeat(grid, beats, end)
+	times := beatTimes(grid, last)
+
+	// fits returns true if a constant tempo from beat start to beat end stays within maxError
+	fits := func(start, end int) bool {
+		beatLength := (times[end] - times[start]) / float64(end-start)
+		for beat := start + 1; beat < end; beat++ {
+			if math.Abs(times[start]+float64(beat-start)*beatLength-times[beat]) > maxError {
+				return false
+			}
+		}
+		return true
+	}
+
+	var resampled []Marker
+	for start := 0; start < last; {
+		end := start + 1
+		for candidate := start + 2; candidate <= last && !breaks[candidate-1]; candidate++ {
+			if !fits(start, candidate) {
+				break
+			}
+			end = candidate
+		}
+		marker := beatMarker(grid, times[start], times[end])
+		marker.Bpm = 60 * float64(end-start) / (times[end] - times[start])
+		resampled = append(resampled, marker)
+		start = end
+	}
+	return resampled
+}
@@ -0,0 +1,207 @@
+package lib_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+// assertSameBeats asserts that the beats of two grids from 0 to end are within tolerance
+// and in the same place in the bar. Beat indexes can differ if the first marker moved.
+func assertSameBeats(t *testing.T, expected, actual []lib.Marker, end float64, tolerance float64) {
+	t.Helper()
+	// step by an amount that never lands halfway between beats
+	for time := 0.0; time < end; time += 0.31 {
+		expectedPosition, _ := lib.PositionAt(expected, time)
+		actualPosition, _ := lib.PositionAt(actual, time)
+		expectedTime, _ := lib.BeatTime(expected, math.Round(expectedPosition.Beat))
+		actualTime, _ := lib.BeatTime(actual, math.Round(actualPosition.Beat))
+		assert.InDelta(t, expectedTime, actualTime, tolerance, "Beats should be at the same time at %gs.", time)
+		if math.Abs(expectedPosition.Beat-math.Round(expectedPosition.Beat)) > 0.01 {
+			assert.Equal(t, expectedPosition.BeatInBar, actualPosition.BeatInBar, "Beat in bar should match at %gs.", time)
+		}
+	}
+}
+
+// dynamicGrid returns a per-beat grid speeding up smoothly from 120 bpm by 0.02 bpm a beat,
+// like a recording with a live drummer.
+func dynamicGrid(beats int) []lib.Marker {
+	var grid []lib.Marker
+	time := 0.5
+	for beat := range beats {
+		bpm := 120 + 0.02*float64(beat)
+		grid = append(grid, lib.Marker{StartPosition: time, Bpm: bpm, BeatNumber: beat % 4})
+		time += 60 / bpm
+	}
+	return grid
+}
+
+func TestAnchors(t *testing.T) {
+	anchors := []lib.Anchor{{Beat: -1, Time: -0.1}, {Beat: 63, Time: 31.9}, {Beat: 127, Time: 61.9}}
+	grid, err := lib.AnchorsToGrid(anchors)
+	assert.Nil(t, err)
+	assert.Len(t, grid, 2)
+	assert.Equal(t, -0.1, grid[0].StartPosition, "Negative grids should be kept.")
+	assert.InDelta(t, 120, grid[0].Bpm, 1e-9)
+	assert.Equal(t, 3, grid[0].BeatNumber, "Beat -1 should be the last beat of a bar.")
+	assert.InDelta(t, 128, grid[1].Bpm, 1e-9)
+	assert.Equal(t, 3, grid[1].BeatNumber)
+
+	converted := lib.GridToAnchors(grid, 61.9)
+	assert.Len(t, converted, len(anchors))
+	for i := range anchors {
+		assert.Equal(t, anchors[i].Beat-anchors[0].Beat, converted[i].Beat-converted[0].Beat)
+		assert.InDelta(t, anchors[i].Time, converted[i].Time, 1e-9)
+	}
+	roundTrip, err := lib.AnchorsToGrid(converted)
+	assert.Nil(t, err)
+	assertSameBeats(t, grid, roundTrip, 61, 1e-9)
+
+	threeFour := []lib.Marker{
+		{StartPosition: 0.5, Bpm: 120, BeatNumber: 1, BeatsPerBar: 3},
+		{StartPosition: 16.5, Bpm: 90, BeatNumber: 0, BeatsPerBar: 3},
+		{StartPosition: 36.5, Bpm: 140, BeatNumber: 0, BeatsPerBar: 7, BeatUnit: 8},
+	}
+	roundTrip, err = lib.AnchorsToGrid(lib.GridToAnchors(threeFour, 60))
+	assert.Nil(t, err)
+	assert.Len(t, roundTrip, len(threeFour))
+	for i := range threeFour {
+		assert.InDelta(t, threeFour[i].Bpm, roundTrip[i].Bpm, 1e-9)
+		roundTrip[i].Bpm = threeFour[i].Bpm
+	}
+	assert.Equal(t, threeFour, roundTrip, "Time signatures and bars should survive a round trip.")
+	assertSameBeats(t, threeFour, roundTrip, 60, 1e-9)
+
+	_, err = lib.AnchorsToGrid([]lib.Anchor{{Beat: 4, Time: 1}, {Beat: 4, Time: 2}})
+	assert.NotNil(t, err, "Anchors on the same beat should return an error.")
+	_, err = lib.AnchorsToGrid([]lib.Anchor{{Beat: 0, Time: 1}, {Beat: 4, Time: 1}})
+	assert.NotNil(t, err, "Anchors at the same time should return an error.")
+}
+
+func TestSegments(t *testing.T) {
+	grid := []lib.Marker{
+		{StartPosition: 1, Bpm: 120, BeatNumber: 2},
+		{StartPosition: 5, Bpm: 100, BeatNumber: 2},
+		{StartPosition: 14.6, Bpm: 125, BeatNumber: 2},
+	}
+	segments := lib.GridToSegments(grid)
+	assert.Equal(t, []lib.SegmentMarker{
+		{Time: 0, Beats: 10},
+		{Time: 5, Beats: 16},
+		{Time: 14.6, Bpm: 125},
+	}, segments, "The first marker should move back to its downbeat.")
+
+	roundTrip, err := lib.SegmentsToGrid(segments)
+	assert.Nil(t, err)
+	assert.Len(t, roundTrip, 3)
+	assertSameBeats(t, grid, roundTrip, 30, 1e-9)
+
+	threeFour := []lib.Marker{
+		{StartPosition: 1, Bpm: 120, BeatNumber: 2, BeatsPerBar: 3},
+		{StartPosition: 5, Bpm: 100, BeatNumber: 1, BeatsPerBar: 3},
+		{StartPosition: 14.6, Bpm: 125, BeatNumber: 0, BeatsPerBar: 7, BeatUnit: 8},
+	}
+	segments = lib.GridToSegments(threeFour)
+	assert.Equal(t, lib.SegmentMarker{Time: 0, Beats: 10, BeatsPerBar: 3}, segments[0])
+	roundTrip, err = lib.SegmentsToGrid(segments)
+	assert.Nil(t, err)
+	assert.Len(t, roundTrip, 3)
+	for _, marker := range roundTrip {
+		assert.NotZero(t, marker.BeatsPerBar, "Time signatures should be kept.")
+	}
+	assertSameBeats(t, threeFour, roundTrip, 30, 1e-9)
+
+	_, err = lib.SegmentsToGrid([]lib.SegmentMarker{{Time: 1}})
+	assert.NotNil(t, err, "The last marker needs a bpm.")
+	_, err = lib.SegmentsToGrid([]lib.SegmentMarker{{Time: 1, Beats: 0}, {Time: 2, Bpm: 120}})
+	assert.NotNil(t, err, "Markers before the last need beats.")
+	empty, err := lib.SegmentsToGrid(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, empty)
+}
+
+func TestTrimGrid(t *testing.T) {
+	grid := []lib.Marker{
+		{StartPosition: -3, Bpm: 120},
+		{StartPosition: -1.2, Bpm: 120, BeatNumber: 1},
+		{StartPosition: 4, Bpm: 60, BeatsPerBar: 3},
+	}
+	trimmed := lib.TrimGrid(grid, 0)
+	assert.Equal(t, []lib.Marker{
+		{StartPosition: 0.3, Bpm: 120, BeatNumber: 0},
+		{StartPosition: 4, Bpm: 60, BeatsPerBar: 3},
+	}, roundMarkers(trimmed), "The marker in effect at 0 should move to its first beat after 0.")
+	assertSameBeats(t, grid[1:], trimmed, 10, 1e-9)
+	assert.Equal(t, grid[2:], lib.TrimGrid(grid[2:], 0), "Grids after the start shouldn't change.")
+}
+
+// roundMarkers rounds the start positions of markers to the microsecond.
+func roundMarkers(grid []lib.Marker) []lib.Marker {
+	for i := range grid {
+		grid[i].StartPosition = math.Round(grid[i].StartPosition*1e6) / 1e6
+	}
+	return grid
+}
+
+func TestExpandGrid(t *testing.T) {
+	grid := []lib.Marker{{StartPosition: 1, Bpm: 120, BeatNumber: 1}, {StartPosition: 3, Bpm: 60, BeatsPerBar: 3}}
+	expanded := lib.ExpandGrid(grid, 6)
+	assert.Len(t, expanded, 7, "Expanded grid should have a marker on every beat until the end.")
+	assert.Equal(t, lib.Marker{StartPosition: 1.5, Bpm: 120, BeatNumber: 2}, expanded[1])
+	assert.Equal(t, lib.Marker{StartPosition: 3, Bpm: 60, BeatsPerBar: 3}, expanded[4])
+	assert.Equal(t, lib.Marker{StartPosition: 5, Bpm: 60, BeatNumber: 2, BeatsPerBar: 3}, expanded[6])
+	assertSameBeats(t, grid, expanded, 6, 1e-9)
+
+	resampled := lib.ResampleGrid(expanded, 6, 1e-6)
+	assert.Equal(t, grid, resampled, "Resampling an expanded constant grid should give back the original.")
+}
+
+func TestConstantTempo(t *testing.T) {
+	grid := []lib.Marker{
+		{StartPosition: 0.5, Bpm: 128},
+		{StartPosition: 0.5 + 64*60/128.0, Bpm: 128.0000001},
+		{StartPosition: 0.5 + 128*60/128.0, Bpm: 127.9999999},
+	}
+	marker, constant := lib.ConstantTempo(grid, 180, 0.001)
+	assert.True(t, constant, "Grids with equal bpms should be constant.")
+	assert.InDelta(t, 128, marker.Bpm, 1e-6)
+	assert.Equal(t, 0.5, marker.StartPosition)
+
+	_, constant = lib.ConstantTempo(dynamicGrid(400), 180, 0.001)
+	assert.False(t, constant, "Grids with a changing tempo shouldn't be constant.")
+
+	shifted := []lib.Marker{{StartPosition: 0.5, Bpm: 128}, {StartPosition: 0.5 + 64*60/128.0, Bpm: 128, BeatNumber: 2}}
+	_, constant = lib.ConstantTempo(shifted, 180, 0.001)
+	assert.False(t, constant, "Grids that shift the bars shouldn't be constant.")
+
+	_, constant = lib.ConstantTempo(nil, 180, 0.001)
+	assert.False(t, constant)
+}
+
+func TestResampleGrid(t *testing.T) {
+	grid := dynamicGrid(400)
+	end := grid[len(grid)-1].StartPosition
+
+	for _, maxError := range []float64{0.0005, 0.002, 0.01} {
+		resampled := lib.ResampleGrid(grid, end, maxError)
+		assert.Less(t, len(resampled), len(grid)/4, "Resampling should remove most markers.")
+		for beat := range len(grid) {
+			expected, _ := lib.BeatTime(grid, float64(beat))
+			actual, _ := lib.BeatTime(resampled, float64(beat))
+			assert.LessOrEqual(t, math.Abs(expected-actual), maxError+1e-9,
+				"Beat %d should be within %gs of the original grid.", beat, maxError)
+			position, _ := lib.PositionAt(resampled, actual)
+			assert.Equal(t, beat%4, position.BeatInBar, "Beat %d should keep its place in the bar.", beat)
+		}
+	}
+
+	bars := []lib.Marker{
+		{StartPosition: 0, Bpm: 120},
+		{StartPosition: 2, Bpm: 120, BeatsPerBar: 3},
+		{StartPosition: 3.5, Bpm: 120},
+	}
+	assert.Equal(t, bars, lib.ResampleGrid(bars, 10, 0.001), "Time signature changes should be kept.")
+	assert.Nil(t, lib.ResampleGrid(nil, 10, 0.001))
+}
@@ -0,0 +1,409 @@
+package lib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3Padding is the padding added after the frames of a written ID3v2 tag,
+// so later changes can be written by other software without moving the audio.
+const id3Padding = 1024
+
+// id3Email is the email of the POPM frame ratings are written to. Windows Media Player's
+// is used since most software reads ratings from it.
+const id3Email = "Windows Media Player 9 Series"
+
+// id3TextFrames are the text frames of tag fields, except the year, which is TYER in
+// ID3v2.3 and TDRC in ID3v2.4.
+var id3TextFrames = map[string]string{
+	"Title":  "TIT2",
+	"Artist": "TPE1",
+	"Album":  "TALB",
+	"Genre":  "TCON",
+	"Bpm":    "TBPM",
+	"Key":    "TKEY",
+}
+
+// id3UserFrames are the descriptions of the TXXX frames of tag fields.
+var id3UserFrames = map[string]string{
+	"Color":      "COLOR",
+	cueDataField: "DJTOOLS_CUES",
+}
+
+// id3Tag is an ID3v2.3 or ID3v2.4 tag and the audio following it.
+type id3Tag struct {
+	version byte // major version, 3 or 4
+	frames  []id3Frame
+	audio   []byte
+}
+
+// id3Frame is a frame of an ID3v2 tag. The data is kept as stored, so frames that aren't
+// changed are written back unchanged.
+type id3Frame struct {
+	id    string
+	flags [2]byte
+	data  []byte
+}
+
+// syncsafe decodes a 28-bit integer stored in the low 7 bits of 4 bytes.
+func syncsafe(data []byte) int {
+	return int(data[0]&0x7F)<<21 | int(data[1]&0x7F)<<14 | int(data[2]&0x7F)<<7 | int(data[3]&0x7F)
+}
+
+// appendSyncsafe appends a 28-bit integer in the low 7 bits of 4 bytes.
+func appendSyncsafe(data []byte, value int) []byte {
+	return append(data, byte(value>>21&0x7F), byte(value>>14&0x7F), byte(value>>7&0x7F), byte(value&0x7F))
+}
+
+// removeUnsync reverses the unsynchronisation of ID3v2 data, which inserts a zero after every 0xFF.
+func removeUnsync(data []byte) []byte {
+	var out []byte
+	for i := 0; i < len(data); i++ {
+		out = append(out, data[i])
+		if data[i] == 0xFF && i+1 < len(data) && data[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
+
+// parseID3 parses the ID3v2 tag at the start of an MP3. Files without one get an empty ID3v2.3 tag.
+func parseID3(data []byte) (id3Tag, error) {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return id3Tag{version: 3, audio: data}, nil
+	}
+	version, flags := data[3], data[5]
+	if version != 3 && version != 4 {
+		return id3Tag{}, fmt.Errorf("%w: ID3v2.%d tags can't be written", ErrUnsupported, version)
+	}
+	size := syncsafe(data[6:10])
+	end := 10 + size
+	if version == 4 && flags&0x10 != 0 {
+		end += 10 // footer
+	}
+	if end > len(data) {
+		return id3Tag{}, fmt.Errorf("ID3 tag is longer than the file")
+	}
+
+	body := data[10 : 10+size]
+	if version == 3 && flags&0x80 != 0 {
+		body = removeUnsync(body)
+	}
+	if flags&0x40 != 0 && len(body) >= 4 {
+		// the extended header is dropped, since it only describes the tag as it was
+		if version == 3 {
+			body = body[min(4+int(binary.BigEndian.Uint32(body)), len(body)):]
+		} else {
+			body = body[min(syncsafe(body), len(body)):]
+		}
+	}
+
+	tag := id3Tag{version: version, audio: data[end:]}
+	for len(body) >= 10 && body[0] != 0 {
+		frameSize := int(binary.BigEndian.Uint32(body[4:]))
+		if version == 4 {
+			frameSize = syncsafe(body[4:8])
+		}
+		if 10+frameSize > len(body) {
+			return id3Tag{}, fmt.Errorf("ID3 frame %q is longer than the tag", body[:4])
+		}
+		tag.frames = append(tag.frames, id3Frame{string(body[:4]), [2]byte{body[8], body[9]}, body[10 : 10+frameSize]})
+		body = body[10+frameSize:]
+	}
+	return tag, nil
+}
+
+// content returns the data of a frame without its version's unsynchronisation and data length
+// indicator. It returns false if the frame is compressed or encrypted.
+func (f id3Frame) content(version byte) ([]byte, bool) {
+	data := f.data
+	if version == 3 {
+		return data, f.flags[1]&0xC0 == 0
+	}
+	if f.flags[1]&0x0C != 0 {
+		return nil, false
+	}
+	if f.flags[1]&0x01 != 0 && len(data) >= 4 {
+		data = data[4:]
+	}
+	if f.flags[1]&0x02 != 0 {
+		data = removeUnsync(data)
+	}
+	return data, true
+}
+
+// encode returns the tag followed by the audio. Frames that ask to be discarded when the tag
+// is changed are left out.
+func (t id3Tag) encode() []byte {
+	var body []byte
+	for _, frame := range t.frames {
+		if (t.version == 3 && frame.flags[0]&0x80 != 0) || (t.version == 4 && frame.flags[0]&0x40 != 0) {
+			continue
+		}
+		body = append(body, frame.id...)
+		if t.version == 4 {
+			body = appendSyncsafe(body, len(frame.data))
+		} else {
+			body = binary.BigEndian.AppendUint32(body, uint32(len(frame.data)))
+		}
+		body = append(body, frame.flags[:]...)
+		body = append(body, frame.data...)
+	}
+	body = append(body, make([]byte, id3Padding)...)
+
+	out := append([]byte{'I', 'D', '3', t.version, 0, 0}, appendSyncsafe(nil, len(body))...)
+	out = append(out, body...)
+	return append(out, t.audio...)
+}
+
+// set replaces the frames of an id matching a condition with a frame, or adds it.
+func (t *id3Tag) set(frame id3Frame, matches func(id3Frame) bool) {
+	var frames []id3Frame
+	for _, f := range t.frames {
+		if f.id != frame.id || (matches != nil && !matches(f)) {
+			frames = append(frames, f)
+		}
+	}
+	t.frames = append(frames, frame)
+}
+
+// remove removes the frames of an id.
+func (t *id3Tag) remove(id string) {
+	var frames []id3Frame
+	for _, f := range t.frames {
+		if f.id != id {
+			frames = append(frames, f)
+		}
+	}
+	t.frames = frames
+}
+
+// find returns the content of the first frame of an id matching a condition.
+func (t id3Tag) find(id string, matches func([]byte) bool) ([]byte, bool) {
+	for _, frame := range t.frames {
+		if frame.id != id {
+			continue
+		}
+		data, ok := frame.content(t.version)
+		if ok && len(data) > 0 && (matches == nil || matches(data)) {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// decodeID3Text decodes a string in an ID3v2 text encoding: 0 for ISO-8859-1, 1 for UTF-16
+// with a byte order mark, 2 for UTF-16BE, and 3 for UTF-8. Multiple values are joined by "/".
+func decodeID3Text(encoding byte, data []byte) string {
+	var text string
+	switch encoding {
+	case 1, 2:
+		var order binary.ByteOrder = binary.BigEndian
+		var units []uint16
+		for i := 0; i+2 <= len(data); i += 2 {
+			unit := binary.BigEndian.Uint16(data[i:])
+			switch {
+			case encoding == 1 && unit == 0xFFFE:
+				order = binary.LittleEndian
+				continue
+			case encoding == 1 && unit == 0xFEFF:
+				order = binary.BigEndian
+				continue
+			}
+			units = append(units, order.Uint16(data[i:]))
+		}
+		text = string(utf16.Decode(units))
+	case 3:
+		text = string(data)
+	default:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+	return strings.ReplaceAll(strings.TrimRight(text, "\x00"), "\x00", "/")
+}
+
+// encodeID3Text encodes the strings of a frame as UTF-8 in ID3v2.4, and as ISO-8859-1 or
+// UTF-16 with a byte order mark in ID3v2.3, which doesn't support UTF-8. Every string but
+// the last is null-terminated. It returns the encoding byte followed by the strings.
+func encodeID3Text(version byte, texts ...string) []byte {
+	encoding := byte(0)
+	if version == 4 {
+		encoding = 3
+	} else if strings.ContainsFunc(strings.Join(texts, ""), func(r rune) bool { return r > 0xFF }) {
+		encoding = 1
+	}
+
+	data := []byte{encoding}
+	for i, text := range texts {
+		switch encoding {
+		case 0:
+			for _, r := range text {
+				data = append(data, byte(r))
+			}
+		case 1:
+			data = append(data, 0xFF, 0xFE)
+			for _, unit := range utf16.Encode([]rune(text)) {
+				data = binary.LittleEndian.AppendUint16(data, unit)
+			}
+		default:
+			data = append(data, text...)
+		}
+		if i < len(texts)-1 {
+			data = append(data, id3Terminator(encoding)...)
+		}
+	}
+	return data
+}
+
+// id3Terminator returns the null terminator of strings in an ID3v2 text encoding.
+func id3Terminator(encoding byte) []byte {
+	if encoding == 1 || encoding == 2 {
+		return []byte{0, 0}
+	}
+	return []byte{0}
+}
+
+// splitID3Description splits the data of a COMM or TXXX frame after its encoding, and after
+// the language for COMM frames, into the description and the value.
+func splitID3Description(encoding byte, data []byte) (string, string) {
+	description, value := bytesCut(data, len(id3Terminator(encoding)))
+	return decodeID3Text(encoding, description), decodeID3Text(encoding, value)
+}
+
+// id3Codec reads and writes the ID3v2 tags of MP3 files.
+type id3Codec struct{}
+
+func (id3Codec) read(data []byte) (map[string]string, error) {
+	tag, err := parseID3(data)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string)
+	for field, id := range id3TextFrames {
+		if content, ok := tag.find(id, nil); ok {
+			fields[field] = decodeID3Text(content[0], content[1:])
+		}
+	}
+	for _, id := range []string{"TYER", "TDRC"} {
+		if content, ok := tag.find(id, nil); ok {
+			year := decodeID3Text(content[0], content[1:])
+			fields["Year"] = year[:min(4, len(year))]
+		}
+	}
+	if content, ok := tag.find("COMM", isID3Comment); ok {
+		_, fields["Comment"] = splitID3Description(content[0], content[4:])
+	}
+	for field, description := range id3UserFrames {
+		if content, ok := tag.find("TXXX", isID3UserText(description)); ok {
+			_, fields[field] = splitID3Description(content[0], content[1:])
+		}
+	}
+	if content, ok := tag.find("POPM", nil); ok {
+		_, rest := bytesCut(content, 1)
+		if len(rest) > 0 && rest[0] > 0 {
+			fields["Rating"] = strconv.Itoa(popmStars(rest[0]) * 20)
+		}
+	}
+	return fields, nil
+}
+
+func (id3Codec) write(data []byte, fields map[string]string) ([]byte, error) {
+	tag, err := parseID3(data)
+	if err != nil {
+		return nil, err
+	}
+	// frames are added in field order so the same changes always give the same file
+	for _, field := range slices.Concat(tagFields, []string{cueDataField}) {
+		value, exists := fields[field]
+		if !exists {
+			continue
+		}
+		switch field {
+		case "Year":
+			year, replaced := "TYER", "TDRC"
+			if tag.version == 4 {
+				year, replaced = "TDRC", "TYER"
+			}
+			tag.remove(replaced)
+			tag.set(id3Frame{id: year, data: encodeID3Text(tag.version, value)}, nil)
+		case "Comment":
+			// the language goes between the encoding and the empty description
+			text := encodeID3Text(tag.version, "", value)
+			frame := append([]byte{text[0]}, "eng"...)
+			tag.set(id3Frame{id: "COMM", data: append(frame, text[1:]...)}, isID3CommentFrame(tag.version))
+		case "Color", cueDataField:
+			description := id3UserFrames[field]
+			tag.set(id3Frame{id: "TXXX", data: encodeID3Text(tag.version, description, value)}, isID3UserFrame(tag.version, description))
+		case "Rating":
+			frame := append([]byte(id3Email), 0, popmRating(tagStars(value)), 0, 0, 0, 0)
+			tag.set(id3Frame{id: "POPM", data: frame}, func(f id3Frame) bool {
+				return strings.HasPrefix(string(f.data), id3Email+"\x00")
+			})
+		default:
+			tag.set(id3Frame{id: id3TextFrames[field], data: encodeID3Text(tag.version, value)}, nil)
+		}
+	}
+	return tag.encode(), nil
+}
+
+// isID3Comment returns true if the content of a COMM frame is the comment without a description.
+func isID3Comment(content []byte) bool {
+	if len(content) < 4 {
+		return false
+	}
+	description, _ := splitID3Description(content[0], content[4:])
+	return description == ""
+}
+
+// isID3UserText returns a condition matching the content of the TXXX frame with a description.
+func isID3UserText(description string) func([]byte) bool {
+	return func(content []byte) bool {
+		frameDescription, _ := splitID3Description(content[0], content[1:])
+		return strings.EqualFold(frameDescription, description)
+	}
+}
+
+// isID3CommentFrame returns a condition matching the comment frame without a description.
+func isID3CommentFrame(version byte) func(id3Frame) bool {
+	return func(f id3Frame) bool {
+		content, ok := f.content(version)
+		return ok && isID3Comment(content)
+	}
+}
+
+// isID3UserFrame returns a condition matching the TXXX frame with a description.
+func isID3UserFrame(version byte, description string) func(id3Frame) bool {
+	return func(f id3Frame) bool {
+		content, ok := f.content(version)
+		return ok && len(content) > 0 && isID3UserText(description)(content)
+	}
+}
+
+// popmRating converts stars to the rating byte of a POPM frame, as Windows Media Player writes it.
+func popmRating(stars int) byte {
+	return [6]byte{0, 1, 64, 128, 196, 255}[stars]
+}
+
+// popmStars converts the rating byte of a POPM frame to stars.
+func popmStars(rating byte) int {
+	switch {
+	case rating == 0:
+		return 0
+	case rating < 32:
+		return 1
+	case rating < 96:
+		return 2
+	case rating < 160:
+		return 3
+	case rating < 224:
+		return 4
+	}
+	return 5
+}
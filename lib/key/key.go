@@ -0,0 +1,261 @@
+// This package converts musical keys between the notations used by DJ software.
+package key
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Key is a musical key in the representation of lib.Song.Key, which follows the
+// Camelot wheel starting at 8B: 0=8B, 1=8A, 2=9B, 3=9A... 23=7A. Engine DJ uses
+// the same representation.
+type Key int
+
+// Unknown is a key that couldn't be determined.
+const Unknown Key = -1
+
+// pitch class names, 0=C, preferring the spelling most DJ software uses
+var majorNames = [12]string{"C", "Db", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"}
+var minorNames = [12]string{"Cm", "C#m", "Dm", "Ebm", "Em", "Fm", "F#m", "Gm", "G#m", "Am", "Bbm", "Bm"}
+
+// pitch classes of note letters, 0=C
+var letters = map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+
+// mod returns the non-negative remainder of a divided by b.
+func mod(a, b int) int {
+	return (a%b + b) % b
+}
+
+// New returns the key with a tonic pitch class, 0=C, and mode.
+func New(pitch int, minor bool) Key {
+	// the relative major shares the Camelot number of a minor key
+	tonic := mod(pitch, 12)
+	if minor {
+		tonic = mod(tonic+3, 12)
+	}
+	// each step around the wheel is a fifth (7 semitones), and 7*7 = 1 mod 12
+	step := mod(7*tonic, 12)
+	k := Key(2 * step)
+	if minor {
+		k++
+	}
+	return k
+}
+
+// Valid returns true if the key is one of the 24 known keys.
+func (k Key) Valid() bool {
+	return k >= 0 && k <= 23
+}
+
+// Minor returns true if the key is minor.
+func (k Key) Minor() bool {
+	return k%2 == 1
+}
+
+// Pitch returns the pitch class of the key's tonic, 0=C.
+func (k Key) Pitch() int {
+	tonic := mod(7*int(k/2), 12)
+	if k.Minor() {
+		tonic = mod(tonic+9, 12)
+	}
+	return tonic
+}
+
+// number returns the Camelot and Open Key wheel numbers of the key.
+func (k Key) number() (camelot int, openKey int) {
+	step := int(k / 2)
+	return mod(step+7, 12) + 1, step + 1
+}
+
+// Camelot returns the key in Camelot notation, like "8A".
+func (k Key) Camelot() string {
+	if !k.Valid() {
+		return ""
+	}
+	number, _ := k.number()
+	if k.Minor() {
+		return fmt.Sprintf("%dA", number)
+	}
+	return fmt.Sprintf("%dB", number)
+}
+
+// OpenKey returns the key in Open Key notation, like "1m".
+func (k Key) OpenKey() string {
+	if !k.Valid() {
+		return ""
+	}
+	_, number := k.number()
+	if k.Minor() {
+		return fmt.Sprintf("%dm", number)
+	}
+	return fmt.Sprintf("%dd", number)
+}
+
+// Musical returns the key in musical notation, like "Am" or "F#".
+func (k Key) Musical() string {
+	if !k.Valid() {
+		return ""
+	}
+	if k.Minor() {
+		return minorNames[k.Pitch()]
+	}
+	return majorNames[k.Pitch()]
+}
+
+func (k Key) String() string {
+	if !k.Valid() {
+		return "unknown"
+	}
+	return k.Camelot()
+}
+
+// Parse parses a key in Camelot ("8A"), Open Key ("1m"), or musical notation
+// ("Am", "F#m", "Dbmaj", "C minor", "B♭"), case-insensitive except for the
+// "m" suffix of musical keys.
+func Parse(s string) (Key, error) {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return Unknown, fmt.Errorf("key is empty")
+	}
+
+	// Camelot and Open Key notations are a number followed by a letter
+	last := strings.ToUpper(trimmed[len(trimmed)-1:])
+	if number, err := strconv.Atoi(trimmed[:len(trimmed)-1]); err == nil && number >= 1 && number <= 12 {
+		switch last {
+		case "A":
+			return Key(2*mod(number-8, 12) + 1), nil
+		case "B":
+			return Key(2 * mod(number-8, 12)), nil
+		case "M":
+			return Key(2*(number-1) + 1), nil
+		case "D":
+			return Key(2 * (number - 1)), nil
+		}
+	}
+
+	return parseMusical(trimmed, s)
+}
+
+func parseMusical(trimmed string, s string) (Key, error) {
+	trimmed = strings.NewReplacer("♯", "#", "♭", "b", " ", "").Replace(trimmed)
+	pitch, exists := letters[strings.ToUpper(trimmed[:1])[0]]
+	if !exists {
+		return Unknown, fmt.Errorf("key %q is not a known notation", s)
+	}
+	rest := trimmed[1:]
+	for len(rest) > 0 && (rest[0] == '#' || rest[0] == 'b') {
+		if rest[0] == '#' {
+			pitch++
+		} else {
+			pitch--
+		}
+		rest = rest[1:]
+	}
+
+	switch strings.ToLower(rest) {
+	case "", "maj", "major":
+		return New(pitch, false), nil
+	case "min", "minor":
+		return New(pitch, true), nil
+	case "m":
+		// a lone uppercase "M" is a common way to write major
+		return New(pitch, rest == "m"), nil
+	}
+	return Unknown, fmt.Errorf("key %q is not a known notation", s)
+}
+
+// Engine returns the key in Engine DJ's numeric representation, which is the same as Key.
+func (k Key) Engine() int {
+	if !k.Valid() {
+		return -1
+	}
+	return int(k)
+}
+
+// FromEngine returns the key of an Engine DJ key value.
+func FromEngine(value int) Key {
+	if value < 0 || value > 23 {
+		return Unknown
+	}
+	return Key(value)
+}
+
+// Traktor returns the key as a Traktor MUSICAL_KEY value: 0-11 are the major keys
+// starting at C, and 12-23 are the minor keys starting at Cm.
+func (k Key) Traktor() int {
+	if !k.Valid() {
+		return -1
+	}
+	if k.Minor() {
+		return 12 + k.Pitch()
+	}
+	return k.Pitch()
+}
+
+// FromTraktor returns the key of a Traktor MUSICAL_KEY value.
+func FromTraktor(value int) Key {
+	if value < 0 || value > 23 {
+		return Unknown
+	}
+	return New(value%12, value >= 12)
+}
+
+// Mixxx returns the key as a Mixxx ChromaticKey value: 0 is invalid, 1-12 are
+// the major keys starting at C, and 13-24 are the minor keys starting at Cm.
+func (k Key) Mixxx() int {
+	if !k.Valid() {
+		return 0
+	}
+	return k.Traktor() + 1
+}
+
+// FromMixxx returns the key of a Mixxx ChromaticKey value.
+func FromMixxx(value int) Key {
+	if value < 1 || value > 24 {
+		return Unknown
+	}
+	return FromTraktor(value - 1)
+}
+
+// Serato returns the key as Serato writes it to tags, in musical notation.
+func (k Key) Serato() string {
+	return k.Musical()
+}
+
+// Transpose returns the key shifted by a number of semitones, keeping its mode.
+func (k Key) Transpose(semitones int) Key {
+	if !k.Valid() {
+		return Unknown
+	}
+	return New(k.Pitch()+semitones, k.Minor())
+}
+
+// Neighbors returns the keys that mix harmonically with the key on the Camelot wheel:
+// one step down, one step up, and the relative major or minor.
+func (k Key) Neighbors() []Key {
+	if !k.Valid() {
+		return nil
+	}
+	return []Key{
+		Key(mod(int(k)-2, 24)),
+		Key(mod(int(k)+2, 24)),
+		k ^ 1, // relative keys differ only by mode
+	}
+}
+
+// Compatible returns true if the keys are the same or neighbors on the Camelot wheel.
+func (k Key) Compatible(other Key) bool {
+	if !k.Valid() || !other.Valid() {
+		return false
+	}
+	if k == other {
+		return true
+	}
+	for _, neighbor := range k.Neighbors() {
+		if neighbor == other {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package key_test
+
+import (
+	"testing"
+
+	"github.com/nateranda/djtools/lib/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotations(t *testing.T) {
+	tests := []struct {
+		key     key.Key
+		camelot string
+		openKey string
+		musical string
+		traktor int
+		mixxx   int
+	}{
+		{0, "8B", "1d", "C", 0, 1},
+		{1, "8A", "1m", "Am", 21, 22},
+		{2, "9B", "2d", "G", 7, 8},
+		{3, "9A", "2m", "Em", 16, 17},
+		{12, "2B", "7d", "F#", 6, 7},
+		{13, "2A", "7m", "Ebm", 15, 16},
+		{22, "7B", "12d", "F", 5, 6},
+		{23, "7A", "12m", "Dm", 14, 15},
+	}
+
+	for _, test := range tests {
+		t.Run(test.camelot, func(t *testing.T) {
+			assert.Equal(t, test.camelot, test.key.Camelot())
+			assert.Equal(t, test.openKey, test.key.OpenKey())
+			assert.Equal(t, test.musical, test.key.Musical())
+			assert.Equal(t, test.traktor, test.key.Traktor())
+			assert.Equal(t, test.mixxx, test.key.Mixxx())
+			assert.Equal(t, test.key, key.FromTraktor(test.traktor))
+			assert.Equal(t, test.key, key.FromMixxx(test.mixxx))
+			assert.Equal(t, test.key, key.FromEngine(test.key.Engine()))
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for k := key.Key(0); k < 24; k++ {
+		for _, s := range []string{k.Camelot(), k.OpenKey(), k.Musical(), k.Serato()} {
+			parsed, err := key.Parse(s)
+			assert.Nil(t, err, "Parsing %q should return no errors.", s)
+			assert.Equal(t, k, parsed, "Parsing %q should return the original key.", s)
+		}
+		assert.Equal(t, k, key.New(k.Pitch(), k.Minor()))
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"8a", "8A"},
+		{" 12B ", "12B"},
+		{"1M", "8A"},
+		{"Am", "8A"},
+		{"AM", "11B"},
+		{"A min", "8A"},
+		{"A minor", "8A"},
+		{"Dbmaj", "3B"},
+		{"C#", "3B"},
+		{"D♭", "3B"},
+		{"F♯m", "11A"},
+		{"Gbm", "11A"},
+		{"bbm", "3A"},
+		{"Cb", "1B"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			k, err := key.Parse(test.s)
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, k.Camelot())
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{"", "13A", "0B", "8C", "H", "Ax", "♯"} {
+		k, err := key.Parse(s)
+		assert.NotNil(t, err, "Parsing %q should return an error.", s)
+		assert.Equal(t, key.Unknown, k)
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	for _, k := range []key.Key{key.Unknown, 24} {
+		assert.False(t, k.Valid())
+		assert.Equal(t, "", k.Camelot())
+		assert.Equal(t, "unknown", k.String())
+		assert.Equal(t, -1, k.Engine())
+		assert.Equal(t, 0, k.Mixxx())
+		assert.Equal(t, key.Unknown, k.Transpose(1))
+		assert.Nil(t, k.Neighbors())
+	}
+	assert.Equal(t, key.Unknown, key.FromTraktor(24))
+	assert.Equal(t, key.Unknown, key.FromMixxx(0))
+	assert.Equal(t, key.Unknown, key.FromEngine(-1))
+}
+
+func TestTranspose(t *testing.T) {
+	am, _ := key.Parse("Am")
+	assert.Equal(t, "Bbm", am.Transpose(1).Musical())
+	assert.Equal(t, "Em", am.Transpose(7).Musical())
+	assert.Equal(t, "9A", am.Transpose(7).Camelot(), "A fifth up should be one step clockwise.")
+	assert.Equal(t, "Gm", am.Transpose(-2).Musical())
+	assert.Equal(t, am, am.Transpose(12))
+}
+
+func TestNeighbors(t *testing.T) {
+	k, _ := key.Parse("1A")
+	var neighbors []string
+	for _, neighbor := range k.Neighbors() {
+		neighbors = append(neighbors, neighbor.Camelot())
+	}
+	assert.Equal(t, []string{"12A", "2A", "1B"}, neighbors)
+
+	assert.True(t, k.Compatible(k))
+	for _, neighbor := range k.Neighbors() {
+		assert.True(t, k.Compatible(neighbor))
+		assert.True(t, neighbor.Compatible(k))
+	}
+	other, _ := key.Parse("3A")
+	assert.False(t, k.Compatible(other))
+	assert.False(t, k.Compatible(key.Unknown))
+}
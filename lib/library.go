@@ -11,7 +11,9 @@ import (
 type Marker struct {
 	StartPosition float64 // start position in seconds
 	Bpm           float64 // beats per minute
-	BeatNumber    int     // beat in measure to start on, 0-indexed, assumed 4/4
+	BeatNumber    int     // beat in bar to start on, 0-indexed
+	BeatsPerBar   int     // beats in a bar, the upper number of the time signature, 0 for the default of 4
+	BeatUnit      int     // note value of a beat, the lower number of the time signature, 0 for the default of 4
 }
 
 // HotCue is a saved hot cue for a song.
@@ -31,49 +33,69 @@ type Loop struct {
 	Color    string  // color of loop button, hex code
 }
 
+// WaveformData is a waveform split into low, mid, and high frequency bands.
+type WaveformData struct {
+	SamplesPerEntry float64 // number of audio samples summarized by each entry
+	Low             []uint8 // amplitude of the low band for each entry, 0-255
+	Mid             []uint8 // amplitude of the mid band for each entry, 0-255
+	High            []uint8 // amplitude of the high band for each entry, 0-255
+}
+
+// Waveform is the waveform and loudness analysis of a song.
+type Waveform struct {
+	Overview WaveformData // low resolution waveform of the whole song
+	Detail   WaveformData // high resolution waveform, empty if not supported by the software
+	Loudness float64      // average loudness, 0-1
+	Key      int          // analyzed key in the same representation as Song.Key, -1 if unknown
+}
+
 // Song is the metadata, analysis data, and saved cues/loops for a song.
 type Song struct {
-	SongID       int      // song id used by software
-	Title        string   // title
-	Artist       string   // artist
-	Composer     string   // composer
-	Album        string   // album song is from
-	Grouping     string   // grouping
-	Genre        string   // genre
-	Filetype     string   // filetype, abbreviated lowercase
-	Size         int      // file size, bytes
-	Length       float32  // song length, seconds
-	TrackNumber  int      // number in album
-	Year         int      // release year
-	Bpm          float32  // beats per minute
-	DateModified int      // date last modified, unix
-	DateAdded    int      // date added to library, unix
-	Bitrate      int      // bitrate, kbps
-	SampleRate   float64  // sample rate, hz
-	Comment      string   // comment
-	PlayCount    int      // play count
-	LastPlayed   int      // date last played, unix
-	Rating       int      // rating in multiples of 20: 0*=0, 1*=20... 5*=100
-	Path         string   // song absolute path
-	Remixer      string   // remixer
-	Key          int      // key in int representation of camelot, 0-indexed: 0=8B, 1=8A, 2=9B... 23=7A
-	Label        string   // label
-	Mix          string   // mix
-	Color        string   // color, hex code
-	Cue          float64  // cue location, seconds
-	Grid         []Marker // slice of Marker structs, ordered by start position
-	Cues         []HotCue // slice of Cue structs, unordered
-	Loops        []Loop   // slice of Loop structs, unordered
-	Corrupt      bool     // is the song file corrupted?
+	SongID        int       // song id used by software
+	Title         string    // title
+	Artist        string    // artist
+	Composer      string    // composer
+	Album         string    // album song is from
+	Grouping      string    // grouping
+	Genre         string    // genre
+	Filetype      string    // filetype, abbreviated lowercase
+	Size          int       // file size, bytes
+	Length        float32   // song length, seconds
+	TrackNumber   int       // number in album
+	Year          int       // release year
+	Bpm           float32   // beats per minute
+	DateModified  int       // date last modified, unix
+	DateAdded     int       // date added to library, unix
+	Bitrate       int       // bitrate, kbps
+	SampleRate    float64   // sample rate, hz
+	Comment       string    // comment
+	PlayCount     int       // play count
+	LastPlayed    int       // date last played, unix
+	Rating        int       // rating in multiples of 20: 0*=0, 1*=20... 5*=100
+	Path          string    // song absolute path
+	Remixer       string    // remixer
+	Key           int       // key in int representation of camelot, 0-indexed: 0=8B, 1=8A, 2=9B... 23=7A
+	Label         string    // label
+	Mix           string    // mix
+	Color         string    // color, hex code
+	Cue           float64   // cue location, seconds
+	Grid          []Marker  // slice of Marker structs, ordered by start position
+	Cues          []HotCue  // slice of Cue structs, unordered
+	Loops         []Loop    // slice of Loop structs, unordered
+	Waveform      *Waveform // waveform analysis, nil if not imported
+	Artwork       *Artwork  // cover art, nil if not imported
+	Corrupt       bool      // is the song file corrupted?
+	CorruptReason string    // why the song is corrupted, empty if it isn't
 }
 
 // Playlist is a set of ordered songs which can contain other playlists.
 // A folder is just a Playlist with no songs that contains other playlists.
 type Playlist struct {
-	PlaylistID   int        // playlist id used by software
-	Name         string     // name of playlist
-	Songs        []int      // slice of song ids in order
-	SubPlaylists []Playlist // slice of child playlists in order, can be recursive
+	PlaylistID   int            // playlist id used by software
+	Name         string         // name of playlist
+	Songs        []int          // slice of song ids in order
+	SubPlaylists []Playlist     // slice of child playlists in order, can be recursive
+	Smart        *SmartPlaylist // smart playlist rules, nil for static playlists
 }
 
 // Library is the entire library of a DJ software.
@@ -137,18 +159,28 @@ func (l *Library) SortSongs() {
 }
 
 // CheckCorruptedSongs removes songs marked as corrupted from the Library
-func (l *Library) CheckCorruptedSongs() {
-	for i, song := range l.Songs {
-		// this is expensive, but it should happen rarely so it's ok
+// and returns the removed songs
+func (l *Library) CheckCorruptedSongs() []Song {
+	var songs []Song
+	var corrupted []Song
+	for _, song := range l.Songs {
 		if song.Corrupt {
-			// remove song from library.Songs (doesn't preserve order)
-			l.Songs[i] = l.Songs[len(l.Songs)-1]
-			l.Songs = l.Songs[:len(l.Songs)-1]
-
-			// remove song from playlists
-			l.Playlists = removeSongFromPlaylists(l.Playlists, song.SongID)
+			corrupted = append(corrupted, song)
+		} else {
+			songs = append(songs, song)
 		}
 	}
+	if corrupted == nil {
+		return nil
+	}
+	l.Songs = songs
+
+	// remove songs from playlists
+	for _, song := range corrupted {
+		l.Playlists = removeSongFromPlaylists(l.Playlists, song.SongID)
+	}
+
+	return corrupted
 }
 
 func removeSongFromPlaylists(playlists []Playlist, songID int) []Playlist {
@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MatchMethod is how two songs were matched.
+type MatchMethod string
+
+const (
+	MatchPath     MatchMethod = "path"     // same path
+	MatchContent  MatchMethod = "content"  // same audio content hash
+	MatchFile     MatchMethod = "file"     // same file size and length
+	MatchMetadata MatchMethod = "metadata" // same normalized artist, title, and remixer, and similar length
+	MatchKey      MatchMethod = "key"      // same key, used by Diff with a custom SongKey
+)
+
+// confidence of each match method, from most to least reliable
+var matchConfidence = map[MatchMethod]float64{
+	MatchPath:     1,
+	MatchContent:  1,
+	MatchFile:     0.9,
+	MatchMetadata: 0.8,
+	MatchKey:      1,
+}
+
+// lengthTolerance is the largest difference between song lengths
+// that still matches, in seconds. Lengths are rounded differently by each software.
+const lengthTolerance float32 = 1
+
+// MatchOptions configures how songs are matched across libraries.
+type MatchOptions struct {
+	// Hash returns a hash of a song's audio content, like ContentHash of its path.
+	// Content isn't compared if nil, and songs that return an error are skipped.
+	Hash func(song Song) (string, error)
+}
+
+// Match is a pair of songs from two libraries that are likely the same song.
+type Match struct {
+	A          int         // index of the song in the first slice
+	B          int         // index of the song in the second slice
+	Method     MatchMethod // how the songs were matched
+	Confidence float64     // how likely the songs are the same, 0-1
+}
+
+// MatchResult is the result of matching two slices of songs.
+type MatchResult struct {
+	Matches   []Match // matched pairs, ordered by B
+	Ambiguous []int   // indexes in the second slice that matched more than one song, ordered
+}
+
+// MatchSongs pairs songs in a with songs in b. Each song is matched at most once,
+// trying by path, then audio content, then file size and length, then metadata.
+// A song that matches more than one song with a method is tried with the next method,
+// and is reported as ambiguous if none of them match exactly one song.
+func MatchSongs(a, b []Song, options MatchOptions) MatchResult {
+	var hashes map[int]string
+	var otherHashes map[int]string
+	if options.Hash != nil {
+		hashes = songHashes(a, options.Hash)
+		otherHashes = songHashes(b, options.Hash)
+	}
+
+	// songs with the same key match if match is nil or returns true,
+	// and songs with an empty key can't be matched by the method
+	methods := []struct {
+		method MatchMethod
+		key    func(i int, song Song, hashes map[int]string) string
+		match  func(x, y Song) bool
+	}{
+		{MatchPath, func(i int, song Song, _ map[int]string) string { return song.Path }, nil},
+		{MatchContent, func(i int, song Song, hashes map[int]string) string { return hashes[i] }, nil},
+		{MatchFile, func(i int, song Song, _ map[int]string) string {
+			if song.Size <= 0 {
+				return ""
+			}
+			return fmt.Sprint(song.Size)
+		}, similarLength},
+		{MatchMetadata, func(i int, song Song, _ map[int]string) string {
+			if song.Artist == "" || song.Title == "" {
+				return ""
+			}
+			return normalize(song.Artist) + "\x00" + normalize(song.Title) + "\x00" + normalize(song.Remixer)
+		}, similarLength},
+	}
+
+	used := make(map[int]bool)
+	matched := make(map[int]Match)
+	ambiguous := make(map[int]bool)
+	for _, method := range methods {
+		candidates := make(map[string][]int)
+		for i, song := range a {
+			if key := method.key(i, song, hashes); key != "" {
+				candidates[key] = append(candidates[key], i)
+			}
+		}
+
+		for j, song := range b {
+			if _, exists := matched[j]; exists {
+				continue
+			}
+			key := method.key(j, song, otherHashes)
+			if key == "" {
+				continue
+			}
+			var found []int
+			for _, i := range candidates[key] {
+				if !used[i] && (method.match == nil || method.match(a[i], song)) {
+					found = append(found, i)
+				}
+			}
+			switch len(found) {
+			case 0:
+			case 1:
+				matched[j] = Match{found[0], j, method.method, matchConfidence[method.method]}
+				used[found[0]] = true
+				delete(ambiguous, j)
+			default:
+				ambiguous[j] = true
+			}
+		}
+	}
+
+	var result MatchResult
+	for j := range b {
+		if match, exists := matched[j]; exists {
+			result.Matches = append(result.Matches, match)
+		} else if ambiguous[j] {
+			result.Ambiguous = append(result.Ambiguous, j)
+		}
+	}
+	return result
+}
+
+// similarLength returns true if the songs have lengths within lengthTolerance.
+// Songs with an unknown length are never similar.
+func similarLength(x, y Song) bool {
+	return withinLength(x, y, lengthTolerance)
+}
+
+// normalize lowercases a string and collapses its whitespace for matching.
+func normalize(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+func songHashes(songs []Song, hash func(song Song) (string, error)) map[int]string {
+	hashes := make(map[int]string)
+	for i, song := range songs {
+		h, err := hash(song)
+		if err == nil {
+			hashes[i] = h
+		}
+	}
+	return hashes
+}
+
+// ContentHash returns the sha256 hash of an audio file, skipping ID3v1 and ID3v2 tags
+// so songs with edited metadata still match.
+func ContentHash(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error hashing song: %v", err)
+	}
+
+	// ID3v2 header: "ID3", version, flags, and a 4-byte syncsafe size
+	if len(data) >= 10 && bytes.HasPrefix(data, []byte("ID3")) {
+		size := int(data[6]&0x7f)<<21 | int(data[7]&0x7f)<<14 | int(data[8]&0x7f)<<7 | int(data[9]&0x7f)
+		// footer flag adds another 10 bytes
+		if data[5]&0x10 != 0 {
+			size += 10
+		}
+		data = data[min(len(data), 10+size):]
+	}
+	// ID3v1 tag: the last 128 bytes, starting with "TAG"
+	if len(data) >= 128 && bytes.HasPrefix(data[len(data)-128:], []byte("TAG")) {
+		data = data[:len(data)-128]
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// PathHash is a MatchOptions.Hash that hashes the file at a song's path with ContentHash.
+func PathHash(song Song) (string, error) {
+	return ContentHash(song.Path)
+}
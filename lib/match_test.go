@@ -0,0 +1,84 @@
+package lib_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchSongs(t *testing.T) {
+	a := []lib.Song{
+		{Path: "/Users/a/Music/one.mp3", Title: "One", Artist: "Artist", Size: 100, Length: 300},
+		{Path: "/Users/a/Music/two.mp3", Title: "Two", Artist: "Artist", Size: 200, Length: 200},
+		{Path: "/Users/a/Music/three.mp3", Title: "Three", Artist: "Artist", Remixer: "Remixer", Length: 100},
+		{Path: "/Users/a/Music/four.mp3", Title: "Four", Artist: "Artist", Length: 250},
+		{Path: "/Users/a/Music/four (copy).mp3", Title: "Four", Artist: "Artist", Length: 250},
+	}
+	b := []lib.Song{
+		{Path: "D:\\Music\\three.mp3", Title: "three", Artist: " ARTIST", Remixer: "remixer", Length: 100.5},
+		{Path: "D:\\Music\\two.mp3", Title: "Two (edit)", Size: 200, Length: 200.2},
+		{Path: "/Users/a/Music/one.mp3"},
+		{Path: "D:\\Music\\four.mp3", Title: "Four", Artist: "Artist", Length: 250},
+		{Path: "D:\\Music\\five.mp3", Title: "Three", Artist: "Artist", Length: 100},
+	}
+
+	result := lib.MatchSongs(a, b, lib.MatchOptions{})
+	assert.Equal(t, []lib.Match{
+		{A: 2, B: 0, Method: lib.MatchMetadata, Confidence: 0.8},
+		{A: 1, B: 1, Method: lib.MatchFile, Confidence: 0.9},
+		{A: 0, B: 2, Method: lib.MatchPath, Confidence: 1},
+	}, result.Matches, "Songs should be matched by the most reliable method.")
+	assert.Equal(t, []int{3}, result.Ambiguous, "Song matching two songs should be ambiguous.")
+}
+
+func TestMatchSongsHash(t *testing.T) {
+	a := []lib.Song{{Path: "/a/one.mp3"}, {Path: "/a/two.mp3"}}
+	b := []lib.Song{{Path: "/b/two.mp3"}, {Path: "/b/missing.mp3"}}
+	hashes := map[string]string{"/a/one.mp3": "1", "/a/two.mp3": "2", "/b/two.mp3": "2"}
+	hash := func(song lib.Song) (string, error) {
+		h, exists := hashes[song.Path]
+		if !exists {
+			return "", errors.New("not found")
+		}
+		return h, nil
+	}
+
+	result := lib.MatchSongs(a, b, lib.MatchOptions{Hash: hash})
+	assert.Equal(t, []lib.Match{{A: 1, B: 0, Method: lib.MatchContent, Confidence: 1}},
+		result.Matches, "Songs should be matched by content.")
+}
+
+func TestContentHash(t *testing.T) {
+	audio := []byte("audio frames")
+	// ID3v2 header with a syncsafe size of 4, then the tag
+	id3v2 := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 4}, []byte("tags")...)
+	id3v1 := make([]byte, 128)
+	copy(id3v1, "TAG")
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"plain.mp3":  audio,
+		"tagged.mp3": append(append(id3v2, audio...), id3v1...),
+		"other.mp3":  []byte("other frames"),
+	}
+	hashes := make(map[string]string)
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[name], err = lib.ContentHash(path)
+		assert.Nil(t, err, "Existing file should be hashed.")
+	}
+
+	assert.Equal(t, hashes["plain.mp3"], hashes["tagged.mp3"], "Tags should not change the hash.")
+	assert.NotEqual(t, hashes["plain.mp3"], hashes["other.mp3"], "Different audio should change the hash.")
+
+	_, err := lib.ContentHash(filepath.Join(dir, "missing.mp3"))
+	assert.NotNil(t, err, "Missing file should return an error.")
+}
@@ -0,0 +1,231 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Preference is which of two matched songs is kept when merging libraries.
+type Preference int
+
+const (
+	PreferNewer Preference = iota // keep the song with the newer DateModified
+	PreferBase                    // keep the song from the base library
+	PreferOther                   // keep the song from the other library
+)
+
+// MergePolicy configures how matched songs are merged.
+type MergePolicy struct {
+	Prefer    Preference // which song's metadata, grid, and cues are kept
+	UnionCues bool       // add hot cues and loops from the other song at positions the kept song doesn't use
+	Tolerance float64    // largest difference between cue and loop times that isn't a conflict, DefaultTolerance if 0
+	Match     MatchOptions
+}
+
+// ConflictType is the kind of difference Merge couldn't resolve.
+type ConflictType string
+
+const (
+	ConflictSameDate  ConflictType = "sameDate"  // songs differ but were modified at the same time, base is kept
+	ConflictCue       ConflictType = "cue"       // hot cues at the same position differ, kept song's cue is used
+	ConflictLoop      ConflictType = "loop"      // loops at the same position differ, kept song's loop is used
+	ConflictAmbiguous ConflictType = "ambiguous" // song matches more than one base song, it is added as a new song
+)
+
+// Conflict is a difference between two libraries that Merge couldn't resolve automatically.
+type Conflict struct {
+	Type        ConflictType // kind of conflict
+	SongID      int          // id of the song in the merged library
+	OtherSongID int          // id of the song in the other library
+	Message     string       // human-readable description
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: song id %d (other id %d): %s", c.Type, c.SongID, c.OtherSongID, c.Message)
+}
+
+// Merge merges other into base and returns the merged library with the conflicts it couldn't resolve.
+// Songs are matched with MatchSongs. Matched songs
+// keep the base SongID and Path, and unmatched songs are added with new SongIDs.
+// Playlists are matched by path and their songs are unioned, base songs first.
+// Neither library is modified, and the merged library doesn't share cues, loops, grids,
+// waveforms, or playlists with them, so it can be changed without changing either.
+func Merge(base, other *Library, policy MergePolicy) (Library, []Conflict) {
+	if policy.Tolerance == 0 {
+		policy.Tolerance = DefaultTolerance
+	}
+
+	var merged Library
+	var conflicts []Conflict
+	merged.Songs = make([]Song, len(base.Songs))
+	for i, song := range base.Songs {
+		merged.Songs[i] = cloneSong(song)
+	}
+
+	nextID := 1
+	for _, song := range base.Songs {
+		nextID = max(nextID, song.SongID+1)
+	}
+
+	// map other song ids to merged song ids
+	ids := make(map[int]int)
+	result := MatchSongs(base.Songs, other.Songs, policy.Match)
+	matches := make(map[int]int)
+	for _, match := range result.Matches {
+		matches[match.B] = match.A
+	}
+	for i, song := range other.Songs {
+		baseIndex, matched := matches[i]
+		if !matched {
+			if slices.Contains(result.Ambiguous, i) {
+				conflicts = append(conflicts, Conflict{
+					Type:        ConflictAmbiguous,
+					SongID:      nextID,
+					OtherSongID: song.SongID,
+					Message:     fmt.Sprintf("%s - %s matches more than one song", song.Artist, song.Title),
+				})
+			}
+			ids[song.SongID] = nextID
+			song = cloneSong(song)
+			song.SongID = nextID
+			merged.Songs = append(merged.Songs, song)
+			nextID++
+			continue
+		}
+
+		mergedSong, songConflicts := mergeSong(base.Songs[baseIndex], song, policy)
+		merged.Songs[baseIndex] = mergedSong
+		conflicts = append(conflicts, songConflicts...)
+		ids[song.SongID] = mergedSong.SongID
+	}
+
+	nextPlaylistID := 1
+	for _, playlist := range flattenPlaylists(base.Playlists, "") {
+		nextPlaylistID = max(nextPlaylistID, playlist.PlaylistID+1)
+	}
+	merged.Playlists = mergePlaylists(base.Playlists, other.Playlists, ids, &nextPlaylistID)
+
+	return merged, conflicts
+}
+
+// mergeSong merges two matched songs according to the policy.
+func mergeSong(base, other Song, policy MergePolicy) (Song, []Conflict) {
+	var conflicts []Conflict
+	conflict := func(conflictType ConflictType, format string, a ...any) {
+		conflicts = append(conflicts, Conflict{
+			Type:        conflictType,
+			SongID:      base.SongID,
+			OtherSongID: other.SongID,
+			Message:     fmt.Sprintf(format, a...),
+		})
+	}
+
+	kept, dropped := base, other
+	switch policy.Prefer {
+	case PreferNewer:
+		if other.DateModified > base.DateModified {
+			kept, dropped = other, base
+		} else if other.DateModified == base.DateModified && diffSong(base, other, policy.Tolerance) != nil {
+			conflict(ConflictSameDate, "songs differ but were both modified at %d", base.DateModified)
+		}
+	case PreferOther:
+		kept, dropped = other, base
+	}
+
+	merged := cloneSong(kept)
+	merged.SongID = base.SongID
+	merged.Path = base.Path
+	if !policy.UnionCues {
+		return merged, conflicts
+	}
+
+	near := func(x, y float64) bool {
+		return math.Abs(x-y) <= policy.Tolerance
+	}
+
+	for _, cue := range dropped.Cues {
+		index := slices.IndexFunc(merged.Cues, func(c HotCue) bool { return c.Position == cue.Position })
+		if index == -1 {
+			merged.Cues = append(merged.Cues, cue)
+		} else if existing := merged.Cues[index]; !near(existing.Offset, cue.Offset) || existing.Name != cue.Name {
+			conflict(ConflictCue, "hot cue %d is at %.3fs and %.3fs", cue.Position, existing.Offset, cue.Offset)
+		}
+	}
+
+	for _, loop := range dropped.Loops {
+		index := slices.IndexFunc(merged.Loops, func(l Loop) bool { return l.Position == loop.Position })
+		if index == -1 {
+			merged.Loops = append(merged.Loops, loop)
+		} else if existing := merged.Loops[index]; !near(existing.Start, loop.Start) || !near(existing.End, loop.End) {
+			conflict(ConflictLoop, "loop %d is at %.3fs-%.3fs and %.3fs-%.3fs",
+				loop.Position, existing.Start, existing.End, loop.Start, loop.End)
+		}
+	}
+
+	return merged, conflicts
+}
+
+// cloneSong copies a song so it can be modified without changing the original.
+func cloneSong(song Song) Song {
+	song.Grid = slices.Clone(song.Grid)
+	song.Cues = slices.Clone(song.Cues)
+	song.Loops = slices.Clone(song.Loops)
+	if song.Waveform != nil {
+		waveform := *song.Waveform
+		waveform.Overview = cloneWaveformData(waveform.Overview)
+		waveform.Detail = cloneWaveformData(waveform.Detail)
+		song.Waveform = &waveform
+	}
+	if song.Artwork != nil {
+		artwork := *song.Artwork
+		artwork.Data = slices.Clone(artwork.Data)
+		song.Artwork = &artwork
+	}
+	return song
+}
+
+func cloneWaveformData(data WaveformData) WaveformData {
+	data.Low = slices.Clone(data.Low)
+	data.Mid = slices.Clone(data.Mid)
+	data.High = slices.Clone(data.High)
+	return data
+}
+
+// mergePlaylists unions two playlist trees, matching playlists by name at each level
+// and converting the song ids of other with ids.
+func mergePlaylists(base, other []Playlist, ids map[int]int, nextID *int) []Playlist {
+	merged := clonePlaylists(base)
+
+	for _, playlist := range other {
+		var songs []int
+		for _, id := range playlist.Songs {
+			if mergedID, exists := ids[id]; exists {
+				songs = append(songs, mergedID)
+			}
+		}
+
+		index := slices.IndexFunc(merged, func(p Playlist) bool { return p.Name == playlist.Name })
+		if index == -1 {
+			// keep ids of added playlists from colliding with base ids
+			if playlist.PlaylistID != 0 {
+				playlist.PlaylistID = *nextID
+				*nextID++
+			}
+			playlist.Songs = songs
+			playlist.SubPlaylists = mergePlaylists(nil, playlist.SubPlaylists, ids, nextID)
+			playlist.Smart = cloneSmartPlaylist(playlist.Smart)
+			merged = append(merged, playlist)
+			continue
+		}
+
+		for _, id := range songs {
+			if !slices.Contains(merged[index].Songs, id) {
+				merged[index].Songs = append(merged[index].Songs, id)
+			}
+		}
+		merged[index].SubPlaylists = mergePlaylists(merged[index].SubPlaylists, playlist.SubPlaylists, ids, nextID)
+	}
+
+	return merged
+}
@@ -0,0 +1,169 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func mergeBase() lib.Library {
+	return lib.Library{
+		Songs: []lib.Song{
+			{
+				SongID:       1,
+				Title:        "One",
+				Artist:       "Artist",
+				Path:         "/music/one.mp3",
+				Length:       300,
+				DateModified: 100,
+				Grid:         []lib.Marker{{StartPosition: 0.1, Bpm: 128}},
+				Cues:         []lib.HotCue{{Offset: 30, Position: 1}},
+				Loops:        []lib.Loop{{Start: 30, End: 45, Position: 1}},
+			},
+			{SongID: 2, Title: "Two", Artist: "Artist", Path: "/music/two.mp3", Length: 200, DateModified: 100},
+		},
+		Playlists: []lib.Playlist{
+			{PlaylistID: 1, Name: "folder", SubPlaylists: []lib.Playlist{
+				{PlaylistID: 2, Name: "playlist", Songs: []int{1, 2}},
+			}},
+		},
+	}
+}
+
+func mergeOther() lib.Library {
+	return lib.Library{
+		Songs: []lib.Song{
+			{
+				SongID:       10,
+				Title:        "one ",
+				Artist:       "ARTIST",
+				Path:         "D:\\Music\\one.mp3",
+				Length:       300.4,
+				DateModified: 200,
+				Grid:         []lib.Marker{{StartPosition: 0.2, Bpm: 128}},
+				Cues:         []lib.HotCue{{Offset: 31, Position: 1}, {Offset: 60, Position: 2}},
+				Loops:        []lib.Loop{{Start: 30, End: 45, Position: 1}},
+			},
+			{SongID: 11, Title: "Three", Artist: "Artist", Path: "/music/three.mp3", Length: 100},
+		},
+		Playlists: []lib.Playlist{
+			{PlaylistID: 1, Name: "folder", SubPlaylists: []lib.Playlist{
+				{PlaylistID: 2, Name: "playlist", Songs: []int{11, 10}},
+				{PlaylistID: 3, Name: "new", Songs: []int{11}},
+			}},
+		},
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    lib.MergePolicy
+		grid      float64
+		cues      []lib.HotCue
+		conflicts []lib.ConflictType
+	}{
+		{"PreferNewer", lib.MergePolicy{Prefer: lib.PreferNewer}, 0.2,
+			[]lib.HotCue{{Offset: 31, Position: 1}, {Offset: 60, Position: 2}}, nil},
+		{"PreferBase", lib.MergePolicy{Prefer: lib.PreferBase}, 0.1,
+			[]lib.HotCue{{Offset: 30, Position: 1}}, nil},
+		{"PreferOther", lib.MergePolicy{Prefer: lib.PreferOther}, 0.2,
+			[]lib.HotCue{{Offset: 31, Position: 1}, {Offset: 60, Position: 2}}, nil},
+		{"UnionCues", lib.MergePolicy{Prefer: lib.PreferBase, UnionCues: true}, 0.1,
+			[]lib.HotCue{{Offset: 30, Position: 1}, {Offset: 60, Position: 2}}, []lib.ConflictType{lib.ConflictCue}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := mergeBase()
+			other := mergeOther()
+			merged, conflicts := lib.Merge(&base, &other, test.policy)
+
+			var conflictTypes []lib.ConflictType
+			for _, conflict := range conflicts {
+				conflictTypes = append(conflictTypes, conflict.Type)
+			}
+			assert.Equal(t, test.conflicts, conflictTypes, "Merge should return the expected conflicts.")
+			assert.Len(t, merged.Songs, 3, "Matched songs should be merged and unmatched songs added.")
+
+			song := merged.Songs[0]
+			assert.Equal(t, 1, song.SongID, "Merged song should keep the base id.")
+			assert.Equal(t, "/music/one.mp3", song.Path, "Merged song should keep the base path.")
+			assert.Equal(t, test.grid, song.Grid[0].StartPosition, "Grid should follow the policy.")
+			assert.Equal(t, test.cues, song.Cues, "Cues should follow the policy.")
+
+			assert.Equal(t, 3, merged.Songs[2].SongID, "Added song should get a new id.")
+			assert.Equal(t, []lib.Playlist{
+				{PlaylistID: 1, Name: "folder", SubPlaylists: []lib.Playlist{
+					{PlaylistID: 2, Name: "playlist", Songs: []int{1, 2, 3}},
+					{PlaylistID: 3, Name: "new", Songs: []int{3}},
+				}},
+			}, merged.Playlists, "Playlists should be unioned.")
+
+			assert.Equal(t, mergeBase(), base, "Base library should not be modified.")
+			assert.Equal(t, mergeOther(), other, "Other library should not be modified.")
+		})
+	}
+}
+
+func TestMergeSameDate(t *testing.T) {
+	base := mergeBase()
+	other := mergeOther()
+	other.Songs[0].DateModified = 100
+	merged, conflicts := lib.Merge(&base, &other, lib.MergePolicy{})
+	assert.Len(t, conflicts, 1, "Different songs modified at the same time should conflict.")
+	assert.Equal(t, lib.ConflictSameDate, conflicts[0].Type, "Conflict should be a same date conflict.")
+	assert.Equal(t, base.Songs[0], merged.Songs[0], "Base song should be kept.")
+}
+
+func TestMergeAmbiguous(t *testing.T) {
+	base := mergeBase()
+	base.Songs[1].Title = "One"
+	base.Songs[1].Length = 300
+	other := mergeOther()
+	merged, conflicts := lib.Merge(&base, &other, lib.MergePolicy{})
+	assert.Len(t, conflicts, 1, "Song matching two base songs should conflict.")
+	assert.Equal(t, lib.ConflictAmbiguous, conflicts[0].Type, "Conflict should be an ambiguous match.")
+	assert.Len(t, merged.Songs, 4, "Ambiguous song should be added as a new song.")
+}
+
+func TestMergeCopies(t *testing.T) {
+	// mergeLibraries returns the base and other libraries with waveforms and a smart playlist
+	mergeLibraries := func() (lib.Library, lib.Library) {
+		base, other := mergeBase(), mergeOther()
+		for _, library := range []*lib.Library{&base, &other} {
+			library.Songs[0].Waveform = &lib.Waveform{Overview: lib.WaveformData{Low: []uint8{1, 2}}}
+			library.Playlists[0].SubPlaylists[0].Smart = &lib.SmartPlaylist{
+				Rules: []lib.SmartRule{{Field: "Genre", Values: []string{"House"}}},
+			}
+		}
+		return base, other
+	}
+	for _, prefer := range []lib.Preference{lib.PreferBase, lib.PreferOther} {
+		base, other := mergeLibraries()
+		merged, _ := lib.Merge(&base, &other, lib.MergePolicy{Prefer: prefer})
+		for i := range merged.Songs {
+			song := &merged.Songs[i]
+			for j := range song.Grid {
+				song.Grid[j].Bpm = 0
+			}
+			for j := range song.Cues {
+				song.Cues[j].Offset = 0
+			}
+			for j := range song.Loops {
+				song.Loops[j].Start = 0
+			}
+			if song.Waveform != nil {
+				song.Waveform.Overview.Low[0] = 0
+			}
+		}
+		playlist := &merged.Playlists[0].SubPlaylists[0]
+		playlist.Songs[0] = 0
+		playlist.Smart.Rules[0].Values[0] = "Techno"
+
+		expectedBase, expectedOther := mergeLibraries()
+		assert.Equal(t, expectedBase, base, "Changing the merged library shouldn't change the base library.")
+		assert.Equal(t, expectedOther, other, "Changing the merged library shouldn't change the other library.")
+	}
+}
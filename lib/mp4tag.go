@@ -0,0 +1,276 @@
+package lib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// mp4Atoms are the ilst atoms of tag fields.
+var mp4Atoms = map[string]string{
+	"Title":   "\xa9nam",
+	"Artist":  "\xa9ART",
+	"Album":   "\xa9alb",
+	"Genre":   "\xa9gen",
+	"Year":    "\xa9day",
+	"Bpm":     "tmpo",
+	"Comment": "\xa9cmt",
+}
+
+// mp4Freeform are the names of the iTunes freeform "----" items of tag fields. Bpms with
+// decimals are written to a freeform item as well, since the tmpo atom is an integer.
+var mp4Freeform = map[string]string{
+	"Bpm":    "BPM",
+	"Rating": "RATING",
+	"Key":    "initialkey",
+	"Color":  "COLOR",
+
+	cueDataField: "DJTOOLS_CUES",
+}
+
+// mp4Handler is the body of the hdlr box of a new meta box.
+var mp4Handler = []byte("\x00\x00\x00\x00\x00\x00\x00\x00mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+
+// mp4Item is a metadata item of an ilst box.
+type mp4Item struct {
+	name     string // atom type, or the name of a freeform item
+	freeform bool
+	dataType uint32 // 1 for UTF-8 text, 21 for integers
+	value    []byte
+}
+
+func parseMP4Item(box mp4Box) mp4Item {
+	item := mp4Item{name: box.boxType, freeform: box.boxType == "----"}
+	for _, child := range mp4Boxes(box.body) {
+		switch {
+		case child.boxType == "name" && item.freeform && len(child.body) >= 4:
+			item.name = string(child.body[4:])
+		case child.boxType == "data" && item.value == nil && len(child.body) >= 8:
+			item.dataType = binary.BigEndian.Uint32(child.body) & 0xFFFFFF
+			item.value = child.body[8:]
+		}
+	}
+	return item
+}
+
+// field returns the tag field of an item, or an empty string if it isn't one.
+func (i mp4Item) field() string {
+	names := mp4Atoms
+	if i.freeform {
+		names = mp4Freeform
+	}
+	for field, name := range names {
+		if strings.EqualFold(name, i.name) {
+			return field
+		}
+	}
+	return ""
+}
+
+// text returns the value of an item as a string, formatting integers in decimal.
+func (i mp4Item) text() string {
+	if i.dataType != 21 {
+		return string(i.value)
+	}
+	var value uint64
+	for _, b := range i.value {
+		value = value<<8 | uint64(b)
+	}
+	return strconv.FormatUint(value, 10)
+}
+
+// encodeMP4Box encodes a box, with a 64-bit size if it doesn't fit in 32 bits.
+func encodeMP4Box(boxType string, body []byte) []byte {
+	var box []byte
+	if size := 8 + len(body); size <= math.MaxUint32 {
+		box = binary.BigEndian.AppendUint32(nil, uint32(size))
+		box = append(box, boxType...)
+	} else {
+		box = append(binary.BigEndian.AppendUint32(nil, 1), boxType...)
+		box = binary.BigEndian.AppendUint64(box, uint64(16+len(body)))
+	}
+	return append(box, body...)
+}
+
+// mp4Replace returns the boxes in data with the body of the first box of a type replaced by
+// the result of edit, or with a new box of that type at the end if there is none.
+func mp4Replace(data []byte, boxType string, edit func(body []byte) []byte) []byte {
+	var out []byte
+	replaced := false
+	consumed := 0
+	for _, box := range mp4Boxes(data) {
+		consumed += len(box.raw)
+		if box.boxType == boxType && !replaced {
+			out = append(out, encodeMP4Box(boxType, edit(box.body))...)
+			replaced = true
+			continue
+		}
+		out = append(out, box.raw...)
+	}
+	if !replaced {
+		out = append(out, encodeMP4Box(boxType, edit(nil))...)
+	}
+	return append(out, data[consumed:]...)
+}
+
+// mp4Data encodes the data box of an item.
+func mp4Data(dataType uint32, value []byte) []byte {
+	body := binary.BigEndian.AppendUint32(nil, dataType)
+	body = append(body, 0, 0, 0, 0) // locale
+	return encodeMP4Box("data", append(body, value...))
+}
+
+// mp4FreeformItem encodes an iTunes freeform item.
+func mp4FreeformItem(name string, value string) []byte {
+	body := encodeMP4Box("mean", []byte("\x00\x00\x00\x00com.apple.iTunes"))
+	body = append(body, encodeMP4Box("name", append(make([]byte, 4), name...))...)
+	body = append(body, mp4Data(1, []byte(value))...)
+	return encodeMP4Box("----", body)
+}
+
+// mp4SetItems replaces the items of tag fields in the body of an ilst box.
+func mp4SetItems(ilst []byte, fields map[string]string) []byte {
+	var out []byte
+	for _, box := range mp4Boxes(ilst) {
+		_, replaced := fields[parseMP4Item(box).field()]
+		_, genre := fields["Genre"]
+		if replaced || (box.boxType == "gnre" && genre) {
+			continue // gnre is an ID3v1 genre number, replaced by the \xa9gen text
+		}
+		out = append(out, box.raw...)
+	}
+	for _, field := range slices.Concat(tagFields, []string{cueDataField}) {
+		value, exists := fields[field]
+		if !exists {
+			continue
+		}
+		if field == "Bpm" {
+			bpm, _ := strconv.ParseFloat(value, 64)
+			tempo := binary.BigEndian.AppendUint16(nil, uint16(min(math.Round(bpm), math.MaxUint16)))
+			out = append(out, encodeMP4Box("tmpo", mp4Data(21, tempo))...)
+			if bpm != math.Round(bpm) {
+				out = append(out, mp4FreeformItem(mp4Freeform[field], value)...)
+			}
+			continue
+		}
+		if atom, exists := mp4Atoms[field]; exists {
+			out = append(out, encodeMP4Box(atom, mp4Data(1, []byte(value)))...)
+		} else {
+			out = append(out, mp4FreeformItem(mp4Freeform[field], value)...)
+		}
+	}
+	return out
+}
+
+// shiftChunkOffsets adds delta to the chunk offsets in a moov box that point after offset.
+func shiftChunkOffsets(moov []byte, offset int, delta int) error {
+	stbls := mp4Children(moov, "moov", "trak", "mdia", "minf", "stbl")
+	for _, stbl := range stbls {
+		for _, box := range mp4Boxes(stbl.body) {
+			width := 4
+			if box.boxType == "co64" {
+				width = 8
+			}
+			if (box.boxType != "stco" && box.boxType != "co64") || len(box.body) < 8 {
+				continue
+			}
+			count := int(binary.BigEndian.Uint32(box.body[4:]))
+			entries := box.body[8:]
+			if count*width > len(entries) {
+				return fmt.Errorf("invalid chunk offset table")
+			}
+			for i := range count {
+				entry := entries[i*width:]
+				if width == 8 {
+					if value := binary.BigEndian.Uint64(entry); value > uint64(offset) {
+						binary.BigEndian.PutUint64(entry, uint64(int64(value)+int64(delta)))
+					}
+					continue
+				}
+				value := int64(binary.BigEndian.Uint32(entry))
+				if value <= int64(offset) {
+					continue
+				}
+				if value+int64(delta) > math.MaxUint32 {
+					return fmt.Errorf("%w: chunk offsets don't fit in the stco box", ErrUnsupported)
+				}
+				binary.BigEndian.PutUint32(entry, uint32(value+int64(delta)))
+			}
+		}
+	}
+	return nil
+}
+
+// mp4Codec reads and writes the metadata items of MP4 files.
+type mp4Codec struct{}
+
+func (mp4Codec) read(data []byte) (map[string]string, error) {
+	moov := mp4Children(data, "moov")
+	if len(moov) == 0 {
+		return nil, fmt.Errorf("no moov box found")
+	}
+	fields := make(map[string]string)
+	var tempo string
+	for _, ilst := range mp4Children(moov[0].body, "udta", "meta", "ilst") {
+		for _, box := range mp4Boxes(ilst.body) {
+			item := parseMP4Item(box)
+			field := item.field()
+			switch {
+			case field == "" || item.value == nil:
+			case field == "Bpm" && !item.freeform:
+				tempo = item.text()
+			case field == "Year":
+				year := item.text()
+				fields[field] = year[:min(4, len(year))] // dates can be full dates
+			default:
+				fields[field] = item.text()
+			}
+		}
+	}
+	if _, exists := fields["Bpm"]; !exists && tempo != "" && tempo != "0" {
+		fields["Bpm"] = tempo
+	}
+	return fields, nil
+}
+
+// write rewrites the moov box with the items in moov/udta/meta/ilst, creating the boxes
+// if they don't exist, and moves the chunk offsets of media data after the moov box.
+func (mp4Codec) write(data []byte, fields map[string]string) ([]byte, error) {
+	boxes := mp4Boxes(data)
+	var out []byte
+	offset := 0
+	found := false
+	for _, box := range boxes {
+		if box.boxType != "moov" || found {
+			out = append(out, box.raw...)
+			offset += len(box.raw)
+			continue
+		}
+		found = true
+		body := mp4Replace(box.body, "udta", func(udta []byte) []byte {
+			return mp4Replace(udta, "meta", func(meta []byte) []byte {
+				if len(meta) < 4 {
+					meta = append(make([]byte, 4), encodeMP4Box("hdlr", mp4Handler)...)
+				}
+				items := mp4Replace(meta[4:], "ilst", func(ilst []byte) []byte {
+					return mp4SetItems(ilst, fields)
+				})
+				return append(append([]byte{}, meta[:4]...), items...)
+			})
+		})
+		moov := encodeMP4Box("moov", body)
+		err := shiftChunkOffsets(moov, offset, len(moov)-len(box.raw))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, moov...)
+		offset += len(box.raw)
+	}
+	if !found {
+		return nil, fmt.Errorf("no moov box found")
+	}
+	return append(out, data[offset:]...), nil
+}
@@ -0,0 +1,600 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Decoders of DJ software start MP3 and AAC files at different samples, so the same position
+// in seconds can be a different point in the song. Library positions are in the timeline of
+// GaplessDecoder, and formats convert to and from the timeline of their software's decoder.
+
+const (
+	mp3DecoderDelay     = 529  // samples added by the MP3 synthesis filterbank
+	defaultEncoderDelay = 576  // encoder delay of LAME, used when a file has no LAME tag
+	defaultPriming      = 2112 // priming samples of most AAC encoders
+)
+
+// Decoder describes which leading samples a DJ software's decoder skips.
+type Decoder struct {
+	SkipsXingFrame    bool // skips the Xing or VBRI header frame of VBR MP3s instead of playing it as silence
+	SkipsInfoFrame    bool // skips the Info header frame of CBR MP3s instead of playing it as silence
+	SkipsEncoderDelay bool // skips the encoder and decoder delay of MP3s with a LAME tag
+	SkipsPriming      bool // skips the priming samples of AAC files that store them
+}
+
+// GaplessDecoder skips every leading sample. Library positions are in its timeline.
+var GaplessDecoder = Decoder{SkipsXingFrame: true, SkipsInfoFrame: true, SkipsEncoderDelay: true, SkipsPriming: true}
+
+// AudioInfo is the information in an audio file's headers, like where decoders start it.
+type AudioInfo struct {
+	Codec        string  // "mp3", "aac", "flac", or empty for other codecs
+	SampleRate   float64 // sample rate, hz
+	Length       float64 // length, seconds, 0 if unknown
+	Bitrate      int     // average bitrate, kbps, 0 if unknown
+	FrameSamples int     // samples in an MP3 frame
+	Header       string  // MP3 header frame: "Xing" or "VBRI" for VBR, "Info" for CBR, empty if there is none
+	EncoderDelay int     // MP3 encoder delay from the LAME tag, -1 if there is no LAME tag
+	Priming      int     // AAC priming samples stored in the file, -1 if they aren't stored
+}
+
+// leadingSamples returns the number of samples a decoder plays before the first sample of the song.
+func (info AudioInfo) leadingSamples(decoder Decoder) int {
+	switch info.Codec {
+	case "mp3":
+		var samples int
+		switch info.Header {
+		case "Xing", "VBRI":
+			if !decoder.SkipsXingFrame {
+				samples += info.FrameSamples
+			}
+		case "Info":
+			if !decoder.SkipsInfoFrame {
+				samples += info.FrameSamples
+			}
+		}
+		// without a LAME tag no decoder knows the delay, so every decoder plays it
+		if info.EncoderDelay < 0 {
+			samples += defaultEncoderDelay + mp3DecoderDelay
+		} else if !decoder.SkipsEncoderDelay {
+			samples += info.EncoderDelay + mp3DecoderDelay
+		}
+		return samples
+	case "aac":
+		if info.Priming < 0 {
+			return defaultPriming
+		}
+		if !decoder.SkipsPriming {
+			return info.Priming
+		}
+	}
+	return 0
+}
+
+// Offset returns the seconds to add to a position in the timeline of one decoder
+// to get the same point of the song in the timeline of another.
+func Offset(info AudioInfo, from Decoder, to Decoder) float64 {
+	if from == to || info.SampleRate <= 0 {
+		return 0
+	}
+	return float64(info.leadingSamples(to)-info.leadingSamples(from)) / info.SampleRate
+}
+
+// OffsetOptions configures how SongOffsets finds the headers of song files.
+type OffsetOptions struct {
+	ReadFiles   bool // read the headers of song files instead of estimating them from the filetype
+	Parallelism int  // number of song files read at once, runtime.GOMAXPROCS(0) if 0
+}
+
+// SongOffsets returns the seconds to add to each song's positions in the timeline of one
+// decoder to get them in the timeline of another. Headers are estimated from the filetype,
+// see EstimateAudioInfo, so the offsets only depend on the library unless options.ReadFiles
+// is set. Then song files are read, and songs whose file can't be read are estimated and
+// reported as WarningEstimatedOffset warnings. If ctx is canceled, ctx.Err() is returned.
+func SongOffsets(ctx context.Context, songs []Song, from Decoder, to Decoder, options OffsetOptions, reporter *Reporter) ([]float64, error) {
+	offsets := make([]float64, len(songs))
+	if from == to {
+		return offsets, nil
+	}
+	if !options.ReadFiles {
+		for i, song := range songs {
+			offsets[i] = songOffset(song, EstimateAudioInfo(song), from, to)
+		}
+		return offsets, nil
+	}
+
+	type read struct {
+		info AudioInfo
+		err  error
+	}
+	reads, err := ParallelMap(ctx, len(songs), options.Parallelism, func(i int) read {
+		info, err := ReadAudioInfo(songs[i].Path)
+		return read{info, err}
+	}, func(done int) {
+		reporter.Progress("audioFiles", done, len(songs))
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, song := range songs {
+		info := reads[i].info
+		if reads[i].err != nil {
+			info = EstimateAudioInfo(song)
+			reporter.Warn(Warning{
+				Type:    WarningEstimatedOffset,
+				SongID:  song.SongID,
+				Path:    song.Path,
+				Message: fmt.Sprintf("decoder offset estimated from the filetype: %v", reads[i].err),
+			})
+		}
+		offsets[i] = songOffset(song, info, from, to)
+	}
+	return offsets, nil
+}
+
+// songOffset returns the offset of a song with the given headers, using the song's
+// sample rate if the headers don't have one.
+func songOffset(song Song, info AudioInfo, from Decoder, to Decoder) float64 {
+	if info.SampleRate <= 0 {
+		info.SampleRate = song.SampleRate
+	}
+	return Offset(info, from, to)
+}
+
+// EstimateAudioInfo returns the most common headers for a song's filetype: CBR MP3s from LAME
+// with an Info frame and the default encoder delay, and AAC files that store their priming.
+func EstimateAudioInfo(song Song) AudioInfo {
+	sampleRate := song.SampleRate
+	if sampleRate <= 0 {
+		sampleRate = 44100
+	}
+	// Rekordbox stores filetypes like "MP3 File"
+	filetype := strings.TrimSuffix(strings.ToLower(song.Filetype), " file")
+	switch filetype {
+	case "mp3":
+		frameSamples := 1152
+		if sampleRate < 32000 {
+			frameSamples = 576 // MPEG-2 and 2.5 frames are half as long
+		}
+		return AudioInfo{Codec: "mp3", SampleRate: sampleRate, FrameSamples: frameSamples,
+			Header: "Info", EncoderDelay: defaultEncoderDelay, Priming: -1}
+	case "m4a", "aac", "mp4":
+		return AudioInfo{Codec: "aac", SampleRate: sampleRate, EncoderDelay: -1, Priming: defaultPriming}
+	}
+	return AudioInfo{SampleRate: sampleRate, EncoderDelay: -1, Priming: -1}
+}
+
+// ShiftPositions adds an offset in seconds to the song's cue, hot cues, loops, and grid.
+// A cue of 0 is unset and left unchanged. Cues and loops are never moved before the start of
+// the song, but grid markers can be, since a beatgrid can start before the first beat.
+func (s *Song) ShiftPositions(offset float64) {
+	if offset == 0 {
+		return
+	}
+	if s.Cue != 0 {
+		s.Cue = max(s.Cue+offset, 0)
+	}
+	for i := range s.Cues {
+		s.Cues[i].Offset = max(s.Cues[i].Offset+offset, 0)
+	}
+	for i := range s.Loops {
+		s.Loops[i].Start = max(s.Loops[i].Start+offset, 0)
+		s.Loops[i].End = max(s.Loops[i].End+offset, 0)
+	}
+	for i := range s.Grid {
+		s.Grid[i].StartPosition += offset
+	}
+}
+
+// ReadAudioInfo reads the headers of an MP3, MP4, or FLAC file. Other files return an
+// AudioInfo without a codec.
+func ReadAudioInfo(path string) (AudioInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return AudioInfo{}, fmt.Errorf("error reading audio info: %v", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, 12)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return AudioInfo{}, fmt.Errorf("error reading audio info: %v", err)
+	}
+	header = header[:n]
+
+	var info AudioInfo
+	switch {
+	case len(header) >= 8 && string(header[4:8]) == "ftyp":
+		info, err = readMP4Info(file)
+	case len(header) >= 4 && string(header[:4]) == "fLaC":
+		info, err = readFLACInfo(file)
+	case len(header) >= 3 && string(header[:3]) == "ID3", len(header) >= 2 && frameSync(header):
+		info, err = readMP3Info(file)
+	default:
+		info = AudioInfo{EncoderDelay: -1, Priming: -1}
+	}
+	if err != nil {
+		return AudioInfo{}, fmt.Errorf("error reading audio info: %v", err)
+	}
+	return info, nil
+}
+
+// frameSync returns true if data starts with an MPEG audio frame sync.
+func frameSync(data []byte) bool {
+	return data[0] == 0xFF && data[1]&0xE0 == 0xE0
+}
+
+// mp3SearchLength is how far past the ID3 tag the first MP3 frame is searched for.
+const mp3SearchLength = 1 << 16
+
+func readMP3Info(file *os.File) (AudioInfo, error) {
+	// skip the ID3v2 tag, whose size is stored in 7-bit bytes
+	var start int64
+	header := make([]byte, 10)
+	_, err := file.ReadAt(header, 0)
+	if err == nil && string(header[:3]) == "ID3" {
+		size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+		start = 10 + size
+		if header[5]&0x10 != 0 { // footer
+			start += 10
+		}
+	}
+
+	data := make([]byte, mp3SearchLength)
+	n, err := file.ReadAt(data, start)
+	if err != nil && err != io.EOF {
+		return AudioInfo{}, err
+	}
+	data = data[:n]
+
+	for i := 0; i+4 <= len(data); i++ {
+		frame, ok := parseMP3Frame(data[i:])
+		if !ok {
+			continue
+		}
+		// VBR headers store the length, and CBR files are as long as their audio data
+		info := frame.info
+		stat, err := file.Stat()
+		if err != nil {
+			return AudioInfo{}, err
+		}
+		audioBytes := stat.Size() - start - int64(i)
+		if frame.frames > 0 {
+			info.Length = float64(frame.frames*info.FrameSamples) / info.SampleRate
+			if frame.bytes > 0 {
+				audioBytes = int64(frame.bytes)
+			}
+			info.Bitrate = int(math.Round(float64(audioBytes) * 8 / info.Length / 1000))
+		} else {
+			info.Bitrate = frame.bitrate
+			info.Length = float64(audioBytes) * 8 / float64(frame.bitrate*1000)
+		}
+		return info, nil
+	}
+	return AudioInfo{}, fmt.Errorf("no MP3 frame found")
+}
+
+// mp3Bitrates are the bitrates of MPEG-1 and MPEG-2 layer III frames by bitrate index, kbps.
+var mp3Bitrates = [2][15]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// mp3Frame is the first frame of an MP3.
+type mp3Frame struct {
+	info    AudioInfo
+	bitrate int // bitrate of the frame, kbps
+	frames  int // frames in the file from the Xing or VBRI header, 0 if unknown
+	bytes   int // bytes of audio in the file from the Xing or VBRI header, 0 if unknown
+}
+
+// parseMP3Frame parses the first frame of an MP3 and its Xing, Info, VBRI, and LAME headers.
+func parseMP3Frame(data []byte) (mp3Frame, bool) {
+	if !frameSync(data) {
+		return mp3Frame{}, false
+	}
+	frame := data
+	version := (frame[1] >> 3) & 3 // 0=MPEG-2.5, 2=MPEG-2, 3=MPEG-1
+	layer := (frame[1] >> 1) & 3   // 1=layer III
+	bitrate := frame[2] >> 4
+	rateIndex := (frame[2] >> 2) & 3
+	if version == 1 || layer != 1 || bitrate == 0 || bitrate == 15 || rateIndex == 3 {
+		return mp3Frame{}, false
+	}
+
+	info := AudioInfo{Codec: "mp3", FrameSamples: 1152, EncoderDelay: -1, Priming: -1}
+	info.SampleRate = []float64{44100, 48000, 32000}[rateIndex]
+	header := mp3Frame{bitrate: mp3Bitrates[0][bitrate]}
+	// the Xing header follows the side information, whose length depends on the version and channels
+	mono := frame[3]>>6 == 3
+	sideInfo := 32
+	if mono {
+		sideInfo = 17
+	}
+	if version != 3 {
+		info.FrameSamples = 576
+		info.SampleRate /= 2
+		header.bitrate = mp3Bitrates[1][bitrate]
+		sideInfo = 17
+		if mono {
+			sideInfo = 9
+		}
+		if version == 0 {
+			info.SampleRate /= 2
+		}
+	}
+
+	xing := 4 + sideInfo
+	switch {
+	case len(frame) >= xing+8 && (string(frame[xing:xing+4]) == "Xing" || string(frame[xing:xing+4]) == "Info"):
+		info.Header = string(frame[xing : xing+4])
+	case len(frame) >= 54 && string(frame[36:40]) == "VBRI":
+		info.Header = "VBRI"
+		header.bytes = int(binary.BigEndian.Uint32(frame[46:]))
+		header.frames = int(binary.BigEndian.Uint32(frame[50:]))
+		header.info = info
+		return header, true
+	default:
+		header.info = info
+		return header, true
+	}
+
+	// the LAME tag follows the optional fields of the Xing header
+	flags := binary.BigEndian.Uint32(frame[xing+4:])
+	lame := xing + 8
+	if flags&1 != 0 && len(frame) >= lame+4 {
+		header.frames = int(binary.BigEndian.Uint32(frame[lame:]))
+		lame += 4
+	}
+	if flags&2 != 0 && len(frame) >= lame+4 {
+		header.bytes = int(binary.BigEndian.Uint32(frame[lame:]))
+		lame += 4
+	}
+	if flags&4 != 0 { // seek table
+		lame += 100
+	}
+	if flags&8 != 0 { // quality
+		lame += 4
+	}
+	if len(frame) >= lame+24 {
+		encoder := string(frame[lame : lame+4])
+		if encoder == "LAME" || encoder == "Lavf" || encoder == "Lavc" {
+			delay := frame[lame+21:]
+			info.EncoderDelay = int(delay[0])<<4 | int(delay[1])>>4
+		}
+	}
+	header.info = info
+	return header, true
+}
+
+// mp4Box is a box, or atom, of an MP4 file.
+type mp4Box struct {
+	boxType string
+	body    []byte
+	raw     []byte // box including its header
+}
+
+// mp4Boxes splits data into the boxes it contains.
+func mp4Boxes(data []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return boxes
+		}
+		boxes = append(boxes, mp4Box{string(data[4:8]), data[headerSize:size], data[:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+// mp4Children returns the boxes of a type at a path inside data, like "trak", "mdia".
+func mp4Children(data []byte, path ...string) []mp4Box {
+	var found []mp4Box
+	for _, box := range mp4Boxes(data) {
+		if box.boxType != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			found = append(found, box)
+			continue
+		}
+		body := box.body
+		if box.boxType == "meta" && len(body) >= 4 {
+			body = body[4:] // meta has a version and flags before its children
+		}
+		found = append(found, mp4Children(body, path[1:]...)...)
+	}
+	return found
+}
+
+// mp4MoovLimit is the largest moov box that is read.
+const mp4MoovLimit = 64 << 20
+
+func readMP4Info(file *os.File) (AudioInfo, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	// find the moov box and the size of the mdat boxes between the top-level boxes,
+	// reading only the headers of the others
+	var offset, mdat int64
+	header := make([]byte, 16)
+	var moov []byte
+	for offset < stat.Size() {
+		_, err := file.ReadAt(header[:8], offset)
+		if err != nil {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		if size == 1 {
+			_, err = file.ReadAt(header[8:], offset+8)
+			if err != nil {
+				return AudioInfo{}, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if size == 0 {
+			size = stat.Size() - offset // box extends to the end of the file
+		}
+		if size < headerSize {
+			return AudioInfo{}, fmt.Errorf("invalid box size %d", size)
+		}
+		switch string(header[4:8]) {
+		case "moov":
+			if size > mp4MoovLimit {
+				return AudioInfo{}, fmt.Errorf("moov box is too large")
+			}
+			moov = make([]byte, size-headerSize)
+			_, err = file.ReadAt(moov, offset+headerSize)
+			if err != nil {
+				return AudioInfo{}, err
+			}
+		case "mdat":
+			mdat += size - headerSize
+		}
+		offset += size
+	}
+	if moov == nil {
+		return AudioInfo{}, fmt.Errorf("no moov box found")
+	}
+
+	info := AudioInfo{EncoderDelay: -1, Priming: -1}
+	for _, trak := range mp4Children(moov, "trak") {
+		for _, stsd := range mp4Children(trak.body, "mdia", "minf", "stbl", "stsd") {
+			if len(stsd.body) < 8 {
+				continue
+			}
+			for _, entry := range mp4Boxes(stsd.body[8:]) {
+				// the sample rate is a 16.16 fixed point number after 24 bytes of the audio sample entry
+				if entry.boxType == "mp4a" && len(entry.body) >= 28 {
+					info.Codec = "aac"
+					info.SampleRate = float64(binary.BigEndian.Uint32(entry.body[24:]) >> 16)
+				}
+			}
+		}
+		if info.Codec != "" {
+			info.Priming = editPriming(trak.body)
+			info.Length = mediaLength(trak.body)
+			break
+		}
+	}
+	if info.Codec == "" {
+		return info, nil
+	}
+	if info.Length > 0 {
+		info.Bitrate = int(math.Round(float64(mdat) * 8 / info.Length / 1000))
+	}
+
+	// iTunes stores the priming as the second number of the iTunSMPB tag
+	for _, item := range mp4Children(moov, "udta", "meta", "ilst", "----") {
+		var name string
+		var value []byte
+		for _, box := range mp4Boxes(item.body) {
+			switch {
+			case box.boxType == "name" && len(box.body) >= 4:
+				name = string(box.body[4:])
+			case box.boxType == "data" && len(box.body) >= 8:
+				value = box.body[8:]
+			}
+		}
+		if name != "iTunSMPB" {
+			continue
+		}
+		fields := strings.Fields(string(bytes.TrimRight(value, "\x00")))
+		if len(fields) >= 2 {
+			priming, err := strconv.ParseInt(fields[1], 16, 64)
+			if err == nil {
+				info.Priming = int(priming)
+			}
+		}
+	}
+	return info, nil
+}
+
+// mediaLength returns the length of a track in seconds from its media header, or 0 if it has none.
+func mediaLength(trak []byte) float64 {
+	for _, mdhd := range mp4Children(trak, "mdia", "mdhd") {
+		body := mdhd.body
+		var timescale, duration uint64
+		if len(body) >= 32 && body[0] == 1 {
+			timescale = uint64(binary.BigEndian.Uint32(body[20:]))
+			duration = binary.BigEndian.Uint64(body[24:])
+		} else if len(body) >= 20 {
+			timescale = uint64(binary.BigEndian.Uint32(body[12:]))
+			duration = uint64(binary.BigEndian.Uint32(body[16:]))
+		}
+		if timescale > 0 {
+			return float64(duration) / float64(timescale)
+		}
+	}
+	return 0
+}
+
+// editPriming returns the media time of the first edit of a track, which skips the priming,
+// or -1 if the track has no edit list.
+func editPriming(trak []byte) int {
+	for _, elst := range mp4Children(trak, "edts", "elst") {
+		body := elst.body
+		if len(body) < 8 || binary.BigEndian.Uint32(body[4:]) == 0 {
+			continue
+		}
+		var mediaTime int64
+		if body[0] == 1 && len(body) >= 24 {
+			mediaTime = int64(binary.BigEndian.Uint64(body[16:]))
+		} else if len(body) >= 16 {
+			mediaTime = int64(int32(binary.BigEndian.Uint32(body[12:])))
+		}
+		if mediaTime >= 0 {
+			return int(mediaTime)
+		}
+	}
+	return -1
+}
+
+// readFLACInfo reads the sample rate and length from the STREAMINFO block that starts a FLAC file.
+func readFLACInfo(file *os.File) (AudioInfo, error) {
+	streamInfo := make([]byte, 4+34)
+	_, err := file.ReadAt(streamInfo, 4)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	if streamInfo[0]&0x7F != 0 {
+		return AudioInfo{}, fmt.Errorf("FLAC file doesn't start with a STREAMINFO block")
+	}
+	block := streamInfo[4:]
+
+	// the sample rate is 20 bits and the number of samples 36 bits, after 10 bytes of frame sizes
+	info := AudioInfo{Codec: "flac", EncoderDelay: -1, Priming: -1}
+	info.SampleRate = float64(int(block[10])<<12 | int(block[11])<<4 | int(block[12])>>4)
+	samples := uint64(block[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(block[14:]))
+	if info.SampleRate > 0 && samples > 0 {
+		info.Length = float64(samples) / info.SampleRate
+		stat, err := file.Stat()
+		if err != nil {
+			return AudioInfo{}, err
+		}
+		info.Bitrate = int(math.Round(float64(stat.Size()) * 8 / info.Length / 1000))
+	}
+	return info, nil
+}
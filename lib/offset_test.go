@@ -0,0 +1,249 @@
+package lib_test
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+// rekordbox, serato, and gapless are the decoder profiles registered by the formats.
+var (
+	rekordbox = lib.Decoder{}
+	serato    = lib.Decoder{SkipsXingFrame: true, SkipsInfoFrame: true, SkipsPriming: true}
+	gapless   = lib.GaplessDecoder
+)
+
+// mp3Frame returns the first frame of an MPEG-1 layer III stereo file at 44.1 kHz and 128 kbps
+// with a Xing or Info header of 1000 frames and 417000 bytes, or no header if header is empty,
+// and a LAME tag if delay isn't negative.
+func mp3Frame(header string, delay int) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	if header == "" {
+		return frame
+	}
+	// the header starts after 32 bytes of side information, with every optional field
+	copy(frame[36:], header)
+	binary.BigEndian.PutUint32(frame[40:], 0x0F)
+	binary.BigEndian.PutUint32(frame[44:], 1000)
+	binary.BigEndian.PutUint32(frame[48:], 417000)
+	if delay >= 0 {
+		lame := 36 + 8 + 4 + 4 + 100 + 4
+		copy(frame[lame:], "LAME3.100")
+		frame[lame+21] = byte(delay >> 4)
+		frame[lame+22] = byte(delay << 4)
+	}
+	return frame
+}
+
+// mp4Box returns an MP4 box of a type containing the concatenated bodies.
+func mp4Box(boxType string, bodies ...[]byte) []byte {
+	var body []byte
+	for _, b := range bodies {
+		body = append(body, b...)
+	}
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(box, boxType...), body...)
+}
+
+// m4aFile returns an M4A file with one 10 second AAC track at sampleRate and 16 kbps, an edit
+// list skipping mediaTime samples if it isn't negative, and an iTunSMPB tag if smpb isn't empty.
+func m4aFile(sampleRate int, mediaTime int, smpb string) []byte {
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint32(entry[24:], uint32(sampleRate)<<16)
+	stsd := append(make([]byte, 4), 0, 0, 0, 1)
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:], uint32(sampleRate))
+	binary.BigEndian.PutUint32(mdhd[16:], uint32(sampleRate*10))
+	stbl := mp4Box("stbl", mp4Box("stsd", stsd, mp4Box("mp4a", entry)))
+	trak := [][]byte{mp4Box("mdia", mp4Box("mdhd", mdhd), mp4Box("minf", stbl))}
+	if mediaTime >= 0 {
+		elst := make([]byte, 20)
+		binary.BigEndian.PutUint32(elst[4:], 1)
+		binary.BigEndian.PutUint32(elst[12:], uint32(mediaTime))
+		trak = append([][]byte{mp4Box("edts", mp4Box("elst", elst))}, trak...)
+	}
+	moov := [][]byte{mp4Box("trak", trak...)}
+	if smpb != "" {
+		name := append(make([]byte, 4), "iTunSMPB"...)
+		data := append(make([]byte, 8), smpb...)
+		item := mp4Box("----", mp4Box("mean", make([]byte, 4), []byte("com.apple.iTunes")), mp4Box("name", name), mp4Box("data", data))
+		moov = append(moov, mp4Box("udta", mp4Box("meta", make([]byte, 4), mp4Box("ilst", item))))
+	}
+	file := append(mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00")), mp4Box("moov", moov...)...)
+	return append(file, mp4Box("mdat", make([]byte, 20000))...)
+}
+
+// flacFile returns a FLAC file of 10 seconds at 44.1 kHz with only a STREAMINFO block.
+func flacFile() []byte {
+	block := make([]byte, 34)
+	block[10], block[11], block[12] = 44100>>12, 44100>>4&0xFF, 44100&0x0F<<4
+	binary.BigEndian.PutUint32(block[14:], 441000)
+	return append([]byte{'f', 'L', 'a', 'C', 0x80, 0, 0, 34}, block...)
+}
+
+func TestReadAudioInfo(t *testing.T) {
+	// ID3v2 header with a syncsafe size of 200
+	id3 := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 1, 72}, make([]byte, 200)...)
+
+	// 1000 frames of 1152 samples
+	length := 1152000 / 44100.0
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected lib.AudioInfo
+	}{
+		{"CBR", mp3Frame("Info", 576), lib.AudioInfo{Codec: "mp3", SampleRate: 44100, Length: length, Bitrate: 128,
+			FrameSamples: 1152, Header: "Info", EncoderDelay: 576, Priming: -1}},
+		{"VBR", mp3Frame("Xing", 1105), lib.AudioInfo{Codec: "mp3", SampleRate: 44100, Length: length, Bitrate: 128,
+			FrameSamples: 1152, Header: "Xing", EncoderDelay: 1105, Priming: -1}},
+		{"NoLAMETag", mp3Frame("Xing", -1), lib.AudioInfo{Codec: "mp3", SampleRate: 44100, Length: length, Bitrate: 128,
+			FrameSamples: 1152, Header: "Xing", EncoderDelay: -1, Priming: -1}},
+		{"NoHeader", mp3Frame("", -1), lib.AudioInfo{Codec: "mp3", SampleRate: 44100, Length: 417 * 8 / 128000.0, Bitrate: 128,
+			FrameSamples: 1152, EncoderDelay: -1, Priming: -1}},
+		{"ID3", append(id3, mp3Frame("Info", 576)...), lib.AudioInfo{Codec: "mp3", SampleRate: 44100, Length: length, Bitrate: 128,
+			FrameSamples: 1152, Header: "Info", EncoderDelay: 576, Priming: -1}},
+		{"iTunSMPB", m4aFile(48000, 2112, " 00000000 00000840 000001CA 0000000000A3D5F6"),
+			lib.AudioInfo{Codec: "aac", SampleRate: 48000, Length: 10, Bitrate: 16, EncoderDelay: -1, Priming: 2112}},
+		{"EditList", m4aFile(44100, 1024, ""),
+			lib.AudioInfo{Codec: "aac", SampleRate: 44100, Length: 10, Bitrate: 16, EncoderDelay: -1, Priming: 1024}},
+		{"NoPriming", m4aFile(44100, -1, ""),
+			lib.AudioInfo{Codec: "aac", SampleRate: 44100, Length: 10, Bitrate: 16, EncoderDelay: -1, Priming: -1}},
+		{"FLAC", flacFile(), lib.AudioInfo{Codec: "flac", SampleRate: 44100, Length: 10, Bitrate: 0, EncoderDelay: -1, Priming: -1}},
+		{"OtherCodec", []byte("RIFF\x00\x00\x00\x00WAVEfmt "),
+			lib.AudioInfo{EncoderDelay: -1, Priming: -1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "song")
+			err := os.WriteFile(path, test.data, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := lib.ReadAudioInfo(path)
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, info)
+		})
+	}
+
+	_, err := lib.ReadAudioInfo(filepath.Join(t.TempDir(), "missing.mp3"))
+	assert.NotNil(t, err, "Missing files should return an error.")
+}
+
+func TestOffset(t *testing.T) {
+	cbr := lib.AudioInfo{Codec: "mp3", SampleRate: 44100, FrameSamples: 1152, Header: "Info", EncoderDelay: 576, Priming: -1}
+	vbr := lib.AudioInfo{Codec: "mp3", SampleRate: 44100, FrameSamples: 1152, Header: "Xing", EncoderDelay: 576, Priming: -1}
+	noLAME := lib.AudioInfo{Codec: "mp3", SampleRate: 44100, FrameSamples: 1152, Header: "Xing", EncoderDelay: -1, Priming: -1}
+	noHeader := lib.AudioInfo{Codec: "mp3", SampleRate: 44100, FrameSamples: 1152, EncoderDelay: -1, Priming: -1}
+	aac := lib.AudioInfo{Codec: "aac", SampleRate: 44100, EncoderDelay: -1, Priming: 2112}
+	aacUnknown := lib.AudioInfo{Codec: "aac", SampleRate: 44100, EncoderDelay: -1, Priming: -1}
+
+	tests := []struct {
+		name     string
+		info     lib.AudioInfo
+		from     lib.Decoder
+		to       lib.Decoder
+		expected float64 // samples
+	}{
+		{"CBRToRekordbox", cbr, gapless, rekordbox, 1152 + 576 + 529},
+		{"CBRFromRekordbox", cbr, rekordbox, gapless, -(1152 + 576 + 529)},
+		{"CBRToSerato", cbr, gapless, serato, 576 + 529},
+		{"VBRRekordboxToSerato", vbr, rekordbox, serato, -1152},
+		{"NoLAMETag", noLAME, gapless, rekordbox, 1152},
+		{"NoHeader", noHeader, gapless, rekordbox, 0},
+		{"AACToRekordbox", aac, gapless, rekordbox, 2112},
+		{"AACToSerato", aac, gapless, serato, 0},
+		{"AACUnknownPriming", aacUnknown, gapless, rekordbox, 0},
+		{"SameDecoder", cbr, rekordbox, rekordbox, 0},
+		{"OtherCodec", lib.AudioInfo{SampleRate: 44100, EncoderDelay: -1, Priming: -1}, gapless, rekordbox, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.InDelta(t, test.expected/44100, lib.Offset(test.info, test.from, test.to), 1e-12)
+		})
+	}
+}
+
+func TestSongOffsets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	err := os.WriteFile(path, mp3Frame("Xing", 1105), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.mp3")
+	songs := []lib.Song{
+		{SongID: 1, Path: path, Filetype: "mp3", SampleRate: 44100},
+		{SongID: 2, Path: missing, Filetype: "MP3 File", SampleRate: 48000},
+		{SongID: 3, Path: missing, Filetype: "m4a", SampleRate: 48000},
+		{SongID: 4, Path: missing, Filetype: "flac", SampleRate: 48000},
+	}
+	estimated := []float64{(1152 + 576 + 529) / 44100.0, (1152 + 576 + 529) / 48000.0, 2112 / 48000.0, 0}
+
+	reporter := &lib.Reporter{}
+	offsets, err := lib.SongOffsets(context.Background(), songs, gapless, rekordbox, lib.OffsetOptions{}, reporter)
+	assert.Nil(t, err)
+	assert.InDeltaSlice(t, estimated, offsets, 1e-12, "Offsets should be estimated from the filetype without reading files.")
+	assert.Empty(t, reporter.Report.Warnings)
+
+	reporter = &lib.Reporter{}
+	offsets, err = lib.SongOffsets(context.Background(), songs, gapless, rekordbox, lib.OffsetOptions{ReadFiles: true, Parallelism: 2}, reporter)
+	assert.Nil(t, err)
+	assert.InDelta(t, (1152+1105+529)/44100.0, offsets[0], 1e-12, "The offset should be read from the file.")
+	assert.InDeltaSlice(t, estimated[1:], offsets[1:], 1e-12, "Missing files should be estimated.")
+	var estimatedIDs []int
+	for _, warning := range reporter.Report.Warnings {
+		assert.Equal(t, lib.WarningEstimatedOffset, warning.Type)
+		estimatedIDs = append(estimatedIDs, warning.SongID)
+	}
+	assert.Equal(t, []int{2, 3, 4}, estimatedIDs, "Songs with estimated offsets should be reported in order.")
+
+	offsets, err = lib.SongOffsets(context.Background(), songs, rekordbox, rekordbox, lib.OffsetOptions{ReadFiles: true}, &lib.Reporter{})
+	assert.Nil(t, err)
+	assert.Equal(t, make([]float64, len(songs)), offsets)
+}
+
+func TestShiftPositions(t *testing.T) {
+	song := lib.Song{
+		Cue:   1,
+		Cues:  []lib.HotCue{{Offset: 2}},
+		Loops: []lib.Loop{{Start: 3, End: 4}},
+		Grid:  []lib.Marker{{StartPosition: 0.5, Bpm: 120}},
+	}
+	song.ShiftPositions(-0.5)
+	assert.Equal(t, lib.Song{
+		Cue:   0.5,
+		Cues:  []lib.HotCue{{Offset: 1.5}},
+		Loops: []lib.Loop{{Start: 2.5, End: 3.5}},
+		Grid:  []lib.Marker{{StartPosition: 0, Bpm: 120}},
+	}, song)
+}
+
+func TestShiftPositionsCue(t *testing.T) {
+	tests := []struct {
+		name     string
+		cue      float64
+		offset   float64
+		expected float64
+	}{
+		{"Unset", 0, -0.05, 0},
+		{"UnsetForward", 0, 0.05, 0},
+		{"Clamped", 0.01, -0.05, 0},
+		{"Forward", 1, 0.05, 1.05},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			song := lib.Song{Cue: test.cue, Cues: []lib.HotCue{{Offset: test.cue}}}
+			song.ShiftPositions(test.offset)
+			assert.InDelta(t, test.expected, song.Cue, 1e-9)
+			assert.GreaterOrEqual(t, song.Cues[0].Offset, 0.0, "Hot cues shouldn't move before the start.")
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// ParallelMap calls fn for every index below n on up to parallelism goroutines and returns
+// the results in index order, so the output doesn't depend on the order the calls finish in.
+// parallelism of 0 or less uses runtime.GOMAXPROCS(0). onDone is called on the calling
+// goroutine with the number of finished calls after each one, and can be nil. If ctx is
+// canceled, calls that haven't started are skipped and ctx.Err() is returned.
+func ParallelMap[T any](ctx context.Context, n int, parallelism int, fn func(i int) T, onDone func(done int)) ([]T, error) {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	parallelism = min(parallelism, n)
+
+	results := make([]T, n)
+	indexes := make(chan int)
+	finished := make(chan struct{})
+	var wg sync.WaitGroup
+	for range parallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = fn(i)
+				finished <- struct{}{}
+			}
+		}()
+	}
+	go func() {
+		defer close(indexes)
+		for i := range n {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	done := 0
+	for range finished {
+		done++
+		if onDone != nil {
+			onDone(done)
+		}
+	}
+	if done < n {
+		return nil, ctx.Err()
+	}
+	return results, nil
+}
@@ -0,0 +1,71 @@
+package lib_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelMap(t *testing.T) {
+	tests := []struct {
+		name        string
+		n           int
+		parallelism int
+	}{
+		{"Serial", 100, 1},
+		{"Bounded", 100, 4},
+		{"Default", 100, 0},
+		{"MoreWorkers", 3, 16},
+		{"Empty", 0, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var running, most atomic.Int32
+			var progress []int
+			results, err := lib.ParallelMap(context.Background(), test.n, test.parallelism, func(i int) int {
+				current := running.Add(1)
+				for {
+					previous := most.Load()
+					if current <= previous || most.CompareAndSwap(previous, current) {
+						break
+					}
+				}
+				// later indexes finish first, so results are collected out of order
+				time.Sleep(time.Duration(test.n-i) * time.Microsecond)
+				running.Add(-1)
+				return i * 2
+			}, func(done int) {
+				progress = append(progress, done)
+			})
+			assert.Nil(t, err)
+
+			expected := make([]int, test.n)
+			for i := range expected {
+				expected[i] = i * 2
+				assert.Equal(t, i+1, progress[i], "onDone should count the finished calls.")
+			}
+			assert.Equal(t, expected, results, "Results should be in index order.")
+			if test.parallelism > 0 {
+				assert.LessOrEqual(t, int(most.Load()), test.parallelism, "Calls shouldn't exceed the parallelism.")
+			}
+		})
+	}
+}
+
+func TestParallelMapCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+	results, err := lib.ParallelMap(ctx, 1000, 2, func(i int) int {
+		if calls.Add(1) == 10 {
+			cancel()
+		}
+		return i
+	}, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, results)
+	assert.Less(t, int(calls.Load()), 1000, "Calls shouldn't start after the context is canceled.")
+}
@@ -0,0 +1,272 @@
+package lib
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Predicate reports whether a song matches a condition.
+type Predicate func(song Song) bool
+
+// Operator compares a song field to a value.
+type Operator string
+
+const (
+	OpEqual        Operator = "="  // equal, case-insensitive for strings
+	OpNotEqual     Operator = "!=" // not equal, case-insensitive for strings
+	OpLess         Operator = "<"
+	OpLessEqual    Operator = "<="
+	OpGreater      Operator = ">"
+	OpGreaterEqual Operator = ">="
+	OpContains     Operator = "~" // contains, case-insensitive, strings only
+)
+
+// And matches songs that match every predicate.
+func And(predicates ...Predicate) Predicate {
+	return func(song Song) bool {
+		for _, predicate := range predicates {
+			if !predicate(song) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or matches songs that match any predicate.
+func Or(predicates ...Predicate) Predicate {
+	return func(song Song) bool {
+		for _, predicate := range predicates {
+			if predicate(song) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not matches songs that don't match the predicate.
+func Not(predicate Predicate) Predicate {
+	return func(song Song) bool {
+		return !predicate(song)
+	}
+}
+
+// Compare returns a predicate comparing a song field to a value. Fields are the names of
+// Song fields, case-insensitive, or "cues", "loops", and "grid" for the number of hot cues,
+// loops, and grid markers, or "playlist" for the paths of the playlists containing the song.
+func (l *Library) Compare(field string, op Operator, value string) (Predicate, error) {
+	switch strings.ToLower(field) {
+	case "cues":
+		return compareNumber(field, op, value, func(song Song) float64 { return float64(len(song.Cues)) })
+	case "loops":
+		return compareNumber(field, op, value, func(song Song) float64 { return float64(len(song.Loops)) })
+	case "grid":
+		return compareNumber(field, op, value, func(song Song) float64 { return float64(len(song.Grid)) })
+	case "playlist":
+		return l.comparePlaylist(op, value)
+	}
+
+	structField, found := songField(field)
+	if !found {
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+	get := func(song Song) reflect.Value {
+		return reflect.ValueOf(song).FieldByIndex(structField.Index)
+	}
+
+	switch structField.Type.Kind() {
+	case reflect.String:
+		return compareString(field, op, value, func(song Song) string { return get(song).String() })
+	case reflect.Int:
+		return compareNumber(field, op, value, func(song Song) float64 { return float64(get(song).Int()) })
+	case reflect.Float32, reflect.Float64:
+		return compareNumber(field, op, value, func(song Song) float64 { return get(song).Float() })
+	case reflect.Bool:
+		expected, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s needs true or false, got %q", field, value)
+		}
+		switch op {
+		case OpEqual:
+			return func(song Song) bool { return get(song).Bool() == expected }, nil
+		case OpNotEqual:
+			return func(song Song) bool { return get(song).Bool() != expected }, nil
+		}
+		return nil, fmt.Errorf("operator %s can't be used with field %s", op, field)
+	}
+	return nil, fmt.Errorf("field %s can't be compared", field)
+}
+
+// songField finds a Song field by name, case-insensitive.
+func songField(field string) (reflect.StructField, bool) {
+	return reflect.TypeOf(Song{}).FieldByNameFunc(func(name string) bool {
+		return strings.EqualFold(name, field)
+	})
+}
+
+// numericField returns true if a field accepted by Compare is a number.
+func numericField(field string) bool {
+	switch strings.ToLower(field) {
+	case "cues", "loops", "grid":
+		return true
+	}
+	structField, found := songField(field)
+	if !found {
+		return false
+	}
+	switch structField.Type.Kind() {
+	case reflect.Int, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func compareString(field string, op Operator, value string, get func(Song) string) (Predicate, error) {
+	value = strings.ToLower(value)
+	switch op {
+	case OpEqual:
+		return func(song Song) bool { return strings.ToLower(get(song)) == value }, nil
+	case OpNotEqual:
+		return func(song Song) bool { return strings.ToLower(get(song)) != value }, nil
+	case OpContains:
+		return func(song Song) bool { return strings.Contains(strings.ToLower(get(song)), value) }, nil
+	case OpLess:
+		return func(song Song) bool { return strings.ToLower(get(song)) < value }, nil
+	case OpLessEqual:
+		return func(song Song) bool { return strings.ToLower(get(song)) <= value }, nil
+	case OpGreater:
+		return func(song Song) bool { return strings.ToLower(get(song)) > value }, nil
+	case OpGreaterEqual:
+		return func(song Song) bool { return strings.ToLower(get(song)) >= value }, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", op)
+}
+
+func compareNumber(field string, op Operator, value string, get func(Song) float64) (Predicate, error) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("field %s needs a number, got %q", field, value)
+	}
+	switch op {
+	case OpEqual:
+		return func(song Song) bool { return get(song) == number }, nil
+	case OpNotEqual:
+		return func(song Song) bool { return get(song) != number }, nil
+	case OpLess:
+		return func(song Song) bool { return get(song) < number }, nil
+	case OpLessEqual:
+		return func(song Song) bool { return get(song) <= number }, nil
+	case OpGreater:
+		return func(song Song) bool { return get(song) > number }, nil
+	case OpGreaterEqual:
+		return func(song Song) bool { return get(song) >= number }, nil
+	case OpContains:
+		return nil, fmt.Errorf("operator %s can't be used with field %s", op, field)
+	}
+	return nil, fmt.Errorf("unknown operator %q", op)
+}
+
+// comparePlaylist matches songs in the playlist at a path with OpEqual, songs in any playlist
+// with a path containing the value with OpContains, and songs not in the playlist with OpNotEqual.
+func (l *Library) comparePlaylist(op Operator, value string) (Predicate, error) {
+	var match func(path string) bool
+	switch op {
+	case OpEqual, OpNotEqual:
+		match = func(path string) bool { return strings.EqualFold(path, value) }
+	case OpContains:
+		lower := strings.ToLower(value)
+		match = func(path string) bool { return strings.Contains(strings.ToLower(path), lower) }
+	default:
+		return nil, fmt.Errorf("operator %s can't be used with field playlist", op)
+	}
+
+	ids := make(map[int]bool)
+	for _, playlist := range flattenPlaylists(l.Playlists, "") {
+		if match(playlist.path) {
+			for _, id := range playlist.Songs {
+				ids[id] = true
+			}
+		}
+	}
+	if op == OpNotEqual {
+		return func(song Song) bool { return !ids[song.SongID] }, nil
+	}
+	return func(song Song) bool { return ids[song.SongID] }, nil
+}
+
+// Query selects part of a library.
+type Query struct {
+	Match       Predicate // songs to keep, every song if nil
+	Playlists   []string  // paths of playlists to keep with their sub-playlists, every playlist if empty
+	DropOrphans bool      // drop songs that aren't in any kept playlist
+}
+
+// Filter returns a new Library with the songs and playlists selected by the query.
+// Playlists only contain kept songs, and the parents of kept playlists are kept as folders.
+// The library isn't modified.
+func (l *Library) Filter(query Query) Library {
+	var filtered Library
+	kept := make(map[int]bool)
+	for _, song := range l.Songs {
+		if query.Match == nil || query.Match(song) {
+			kept[song.SongID] = true
+		}
+	}
+
+	filtered.Playlists = filterPlaylists(l.Playlists, "", query.Playlists, kept)
+
+	inPlaylist := make(map[int]bool)
+	for _, playlist := range flattenPlaylists(filtered.Playlists, "") {
+		for _, id := range playlist.Songs {
+			inPlaylist[id] = true
+		}
+	}
+	for _, song := range l.Songs {
+		if kept[song.SongID] && (!query.DropOrphans || inPlaylist[song.SongID]) {
+			filtered.Songs = append(filtered.Songs, song)
+		}
+	}
+	return filtered
+}
+
+// filterPlaylists copies the playlists selected by paths, removing songs that aren't kept.
+func filterPlaylists(playlists []Playlist, parent string, paths []string, kept map[int]bool) []Playlist {
+	var filtered []Playlist
+	for _, playlist := range playlists {
+		path := playlist.Name
+		if parent != "" {
+			path = parent + "/" + playlist.Name
+		}
+
+		selected := len(paths) == 0 || slices.Contains(paths, path)
+		if selected {
+			var songs []int
+			if playlist.Songs != nil {
+				songs = []int{}
+			}
+			for _, id := range playlist.Songs {
+				if kept[id] {
+					songs = append(songs, id)
+				}
+			}
+			playlist.Songs = songs
+			// every sub-playlist of a selected playlist is selected
+			playlist.SubPlaylists = filterPlaylists(playlist.SubPlaylists, path, nil, kept)
+			filtered = append(filtered, playlist)
+			continue
+		}
+
+		// keep parents of selected playlists as folders
+		subPlaylists := filterPlaylists(playlist.SubPlaylists, path, paths, kept)
+		if subPlaylists != nil {
+			playlist.Songs = nil
+			playlist.SubPlaylists = subPlaylists
+			filtered = append(filtered, playlist)
+		}
+	}
+	return filtered
+}
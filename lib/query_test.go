@@ -0,0 +1,143 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func queryLibrary() lib.Library {
+	return lib.Library{
+		Songs: []lib.Song{
+			{SongID: 1, Title: "One", Genre: "Deep House", Bpm: 122, Year: 2020,
+				Cues: []lib.HotCue{{Offset: 1, Position: 1}}},
+			{SongID: 2, Title: "Two", Genre: "House", Bpm: 118, Year: 2021,
+				Cues: []lib.HotCue{{Offset: 1, Position: 1}}},
+			{SongID: 3, Title: "Three \"quoted\"", Genre: "Techno", Bpm: 130, Year: 2022, Corrupt: true},
+			{SongID: 4, Title: "Four", Genre: "Tech House", Bpm: 125, Year: 2023},
+		},
+		Playlists: []lib.Playlist{
+			{Name: "Folder", SubPlaylists: []lib.Playlist{
+				{Name: "House", Songs: []int{1, 2}},
+				{Name: "Techno", Songs: []int{3}},
+			}},
+			{Name: "All", Songs: []int{4, 3, 2, 1}},
+		},
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		expected   []int
+	}{
+		{"Contains", "genre ~ house", []int{1, 2, 4}},
+		{"Number", "bpm > 120", []int{1, 3, 4}},
+		{"And", "genre ~ house and bpm > 120 and cues > 0", []int{1}},
+		{"Or", "year <= 2020 or year >= 2023", []int{1, 4}},
+		{"Precedence", "genre = techno or genre ~ house and bpm < 120", []int{2, 3}},
+		{"Parentheses", "(genre = techno or genre ~ house) and bpm < 125", []int{1, 2}},
+		{"Not", "not genre ~ house", []int{3}},
+		{"String", `title = "three \"quoted\""`, []int{3}},
+		{"Bool", "corrupt = true", []int{3}},
+		{"Playlist", `playlist = "Folder/House"`, []int{1, 2}},
+		{"PlaylistContains", "playlist ~ techno", []int{3}},
+		{"NotInPlaylist", "playlist != Folder/House", []int{3, 4}},
+		{"CaseInsensitive", "GENRE = TECHNO AND Bpm >= 130", []int{3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			library := queryLibrary()
+			predicate, err := library.ParseFilter(test.expression)
+			assert.Nil(t, err, "Valid filter should return no errors.")
+			var ids []int
+			for _, song := range library.Songs {
+				if predicate(song) {
+					ids = append(ids, song.SongID)
+				}
+			}
+			assert.Equal(t, test.expected, ids, "Filter should match the expected songs.")
+		})
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+	}{
+		{"Empty", ""},
+		{"UnknownField", "unknown = 1"},
+		{"NotNumber", "bpm > fast"},
+		{"StringContainsNumber", "bpm ~ 120"},
+		{"MissingValue", "bpm >"},
+		{"MissingOperator", "bpm 120"},
+		{"Unterminated", `title = "one`},
+		{"Unclosed", "(bpm > 120"},
+		{"Trailing", "bpm > 120 bpm"},
+		{"InvalidOperator", "bpm ! 120"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			library := queryLibrary()
+			_, err := library.ParseFilter(test.expression)
+			assert.NotNil(t, err, "Invalid filter should return an error.")
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	library := queryLibrary()
+	house, err := library.ParseFilter("genre ~ house")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    lib.Query
+		expected lib.Library
+	}{
+		{"Everything", lib.Query{}, queryLibrary()},
+		{"Songs", lib.Query{Match: house}, lib.Library{
+			Songs: []lib.Song{library.Songs[0], library.Songs[1], library.Songs[3]},
+			Playlists: []lib.Playlist{
+				{Name: "Folder", SubPlaylists: []lib.Playlist{
+					{Name: "House", Songs: []int{1, 2}},
+					{Name: "Techno", Songs: []int{}},
+				}},
+				{Name: "All", Songs: []int{4, 2, 1}},
+			},
+		}},
+		{"Playlists", lib.Query{Playlists: []string{"Folder/Techno"}, DropOrphans: true}, lib.Library{
+			Songs: []lib.Song{library.Songs[2]},
+			Playlists: []lib.Playlist{
+				{Name: "Folder", SubPlaylists: []lib.Playlist{
+					{Name: "Techno", Songs: []int{3}},
+				}},
+			},
+		}},
+		{"Folder", lib.Query{Match: house, Playlists: []string{"Folder"}, DropOrphans: true}, lib.Library{
+			Songs: []lib.Song{library.Songs[0], library.Songs[1]},
+			Playlists: []lib.Playlist{
+				{Name: "Folder", SubPlaylists: []lib.Playlist{
+					{Name: "House", Songs: []int{1, 2}},
+					{Name: "Techno", Songs: []int{}},
+				}},
+			},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filtered := library.Filter(test.query)
+			assert.Equal(t, test.expected, filtered, "Filter should return the selected songs and playlists.")
+			assert.Empty(t, filtered.Validate(), "Filtered library should be valid.")
+			assert.Equal(t, queryLibrary(), library, "Library should not be modified.")
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package lib
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// PathMapping rewrites song paths starting with From to start with To.
+// Paths are compared with either separator, and Windows paths case-insensitively.
+type PathMapping struct {
+	From string // old root, like "/Users/a/Music" or "D:\Music"
+	To   string // new root, the separators of the rewritten path follow this root
+}
+
+// RelocateOptions configures how Relocate repairs song paths.
+type RelocateOptions struct {
+	Mappings   []PathMapping // prefix rewrites, the first matching mapping is used
+	SearchDirs []string      // directories searched recursively for songs that are still missing
+	OnWarning  WarningFunc   // called for each directory or file in the search directories that can't be read, can be nil
+}
+
+// RelocationStatus is the result of relocating a song.
+type RelocationStatus string
+
+const (
+	RelocationOK        RelocationStatus = "ok"        // song file exists at its new path
+	RelocationFound     RelocationStatus = "found"     // song file was missing and found in a search directory
+	RelocationMissing   RelocationStatus = "missing"   // song file couldn't be found
+	RelocationAmbiguous RelocationStatus = "ambiguous" // more than one file could be the song, path is left unchanged
+)
+
+// Relocation is the result of relocating a single song.
+type Relocation struct {
+	SongID  int              // id of the song
+	OldPath string           // path before relocating
+	NewPath string           // path after relocating
+	Status  RelocationStatus // whether the song file was found
+	Method  string           // how a found file was matched: "filename", "size", or "tags"
+}
+
+// Relocate rewrites song paths with the mappings, checks which song files exist,
+// and searches for missing files in the search directories. Songs are updated in place
+// and the result for every song is returned in the order of l.Songs.
+func (l *Library) Relocate(options RelocateOptions) ([]Relocation, error) {
+	var index *fileIndex
+	if len(options.SearchDirs) > 0 {
+		var err error
+		index, err = newFileIndex(options.SearchDirs, options.OnWarning)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var relocations []Relocation
+	for i := range l.Songs {
+		song := &l.Songs[i]
+		relocation := Relocation{SongID: song.SongID, OldPath: song.Path, Status: RelocationMissing}
+		song.Path = MapPath(song.Path, options.Mappings)
+
+		if _, err := os.Stat(song.Path); err == nil {
+			relocation.Status = RelocationOK
+		} else if index != nil {
+			path, method, status := index.find(*song)
+			relocation.Status = status
+			relocation.Method = method
+			if status == RelocationFound {
+				song.Path = path
+			}
+		}
+
+		relocation.NewPath = song.Path
+		relocations = append(relocations, relocation)
+	}
+	return relocations, nil
+}
+
+var windowsPath = regexp.MustCompile(`^[A-Za-z]:|\\`)
+
+// MapPath rewrites a path with the first matching mapping, returning it unchanged if none match.
+func MapPath(path string, mappings []PathMapping) string {
+	slashed := strings.ReplaceAll(path, "\\", "/")
+	for _, mapping := range mappings {
+		from := strings.TrimSuffix(strings.ReplaceAll(mapping.From, "\\", "/"), "/")
+		if len(slashed) < len(from) {
+			continue
+		}
+		prefix := slashed[:len(from)]
+		if prefix != from && !(windowsPath.MatchString(mapping.From) && strings.EqualFold(prefix, from)) {
+			continue
+		}
+		// only match whole path components
+		rest := slashed[len(from):]
+		if rest != "" && !strings.HasPrefix(rest, "/") {
+			continue
+		}
+
+		to := strings.TrimRight(mapping.To, "/\\")
+		if windowsPath.MatchString(mapping.To) {
+			return to + strings.ReplaceAll(rest, "/", "\\")
+		}
+		return strings.ReplaceAll(to, "\\", "/") + rest
+	}
+	return path
+}
+
+// baseName returns the last element of a path with either separator.
+func baseName(path string) string {
+	return path[strings.LastIndexAny(path, "/\\")+1:]
+}
+
+// fileIndex indexes the files in a set of directories.
+type fileIndex struct {
+	byName map[string][]string // lowercase base name to paths
+	bySize map[int64][]string  // size to paths
+}
+
+// newFileIndex indexes the files in dirs. A search directory that can't be read is an error,
+// but directories and files inside of it that can't be read, like .Trashes or System Volume
+// Information at the root of a drive, are skipped and passed to onWarning, which can be nil.
+func newFileIndex(dirs []string, onWarning WarningFunc) (*fileIndex, error) {
+	index := &fileIndex{byName: make(map[string][]string), bySize: make(map[int64][]string)}
+	skip := func(path string, err error) {
+		if onWarning != nil {
+			onWarning(Warning{Type: WarningUnreadableFile, Path: path, Message: fmt.Sprintf("skipped while searching for songs: %v", err)})
+		}
+	}
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if path == dir {
+					return err
+				}
+				skip(path, err)
+				if entry != nil && entry.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				skip(path, err)
+				return nil
+			}
+			name := strings.ToLower(entry.Name())
+			index.byName[name] = append(index.byName[name], path)
+			index.bySize[info.Size()] = append(index.bySize[info.Size()], path)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error searching for songs: %v", err)
+		}
+	}
+	return index, nil
+}
+
+// find searches the index for a song file by name, narrowing by size and then tags
+// if more than one file has the name. Files with a different name are matched by size and tags.
+func (index *fileIndex) find(song Song) (string, string, RelocationStatus) {
+	named := index.byName[strings.ToLower(baseName(song.Path))]
+	if len(named) == 1 {
+		return named[0], "filename", RelocationFound
+	}
+
+	candidates := named
+	if len(named) > 1 {
+		if song.Size > 0 {
+			var sized []string
+			for _, path := range candidates {
+				if info, err := os.Stat(path); err == nil && info.Size() == int64(song.Size) {
+					sized = append(sized, path)
+				}
+			}
+			if len(sized) == 1 {
+				return sized[0], "size", RelocationFound
+			}
+			if len(sized) > 1 {
+				candidates = sized
+			}
+		}
+	} else if song.Size > 0 {
+		// the file might have been renamed
+		candidates = index.bySize[int64(song.Size)]
+	}
+
+	var tagged []string
+	for _, path := range candidates {
+		if tagsMatch(path, song) {
+			tagged = append(tagged, path)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], "tags", RelocationFound
+	}
+	if len(named) > 1 || len(tagged) > 1 {
+		return "", "", RelocationAmbiguous
+	}
+	return "", "", RelocationMissing
+}
+
+// tagsMatch returns true if the file at path has the same normalized title and artist as the song.
+func tagsMatch(path string, song Song) bool {
+	if song.Title == "" {
+		return false
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	metadata, err := tag.ReadFrom(file)
+	if err != nil {
+		return false
+	}
+	return normalize(metadata.Title()) == normalize(song.Title) &&
+		normalize(metadata.Artist()) == normalize(song.Artist)
+}
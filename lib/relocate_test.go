@@ -0,0 +1,116 @@
+package lib_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapPath(t *testing.T) {
+	mappings := []lib.PathMapping{
+		{From: "/Users/a/Music", To: "D:\\Music"},
+		{From: "e:\\DJ", To: "/Volumes/DJ/"},
+	}
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{"MacToWindows", "/Users/a/Music/Artist/song.mp3", "D:\\Music\\Artist\\song.mp3"},
+		{"WindowsToMac", "E:\\DJ\\Artist\\song.mp3", "/Volumes/DJ/Artist/song.mp3"},
+		{"PartialComponent", "/Users/a/Musical/song.mp3", "/Users/a/Musical/song.mp3"},
+		{"CaseSensitive", "/users/a/Music/song.mp3", "/users/a/Music/song.mp3"},
+		{"NoMatch", "/other/song.mp3", "/other/song.mp3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, lib.MapPath(test.path, mappings), "Path should be mapped to the new root.")
+		})
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"new/one.mp3":        []byte("one"),
+		"search/two.mp3":     []byte("two"),
+		"search/a/three.mp3": []byte("three"),
+		"search/b/three.mp3": []byte("3"),
+		"search/a/four.mp3":  append(id3Tag(map[string]string{"TIT2": "Four", "TPE1": "Artist"}, nil), "four"...),
+		"search/b/four.mp3":  append(id3Tag(map[string]string{"TIT2": "Other", "TPE1": "Artist"}, nil), "four"...),
+		"search/renamed.mp3": append(id3Tag(map[string]string{"TIT2": "Five", "TPE1": "Artist"}, nil), "five"...),
+		"search/a/six.mp3":   []byte("six"),
+		"search/b/six.mp3":   []byte("six"),
+	}
+	for name, data := range files {
+		writeFile(t, filepath.Join(dir, name), data)
+	}
+
+	library := lib.Library{Songs: []lib.Song{
+		{SongID: 1, Path: "C:\\Old\\one.mp3"},
+		{SongID: 2, Path: "C:\\Old\\two.mp3"},
+		{SongID: 3, Path: "C:\\Old\\three.mp3", Size: 1},
+		{SongID: 4, Path: "C:\\Old\\four.mp3", Title: "Four", Artist: "Artist"},
+		{SongID: 5, Path: "C:\\Old\\five.mp3", Title: "Five", Artist: "Artist", Size: len(files["search/renamed.mp3"])},
+		{SongID: 6, Path: "C:\\Old\\six.mp3"},
+		{SongID: 7, Path: "C:\\Old\\seven.mp3"},
+	}}
+	relocations, err := library.Relocate(lib.RelocateOptions{
+		Mappings:   []lib.PathMapping{{From: "C:\\Old", To: filepath.Join(dir, "new")}},
+		SearchDirs: []string{filepath.Join(dir, "search")},
+	})
+	assert.Nil(t, err, "Valid search directory should return no errors.")
+
+	newDir := filepath.Join(dir, "new")
+	search := filepath.Join(dir, "search")
+	expected := []lib.Relocation{
+		{1, "C:\\Old\\one.mp3", filepath.Join(newDir, "one.mp3"), lib.RelocationOK, ""},
+		{2, "C:\\Old\\two.mp3", filepath.Join(search, "two.mp3"), lib.RelocationFound, "filename"},
+		{3, "C:\\Old\\three.mp3", filepath.Join(search, "b", "three.mp3"), lib.RelocationFound, "size"},
+		{4, "C:\\Old\\four.mp3", filepath.Join(search, "a", "four.mp3"), lib.RelocationFound, "tags"},
+		{5, "C:\\Old\\five.mp3", filepath.Join(search, "renamed.mp3"), lib.RelocationFound, "tags"},
+		{6, "C:\\Old\\six.mp3", filepath.Join(newDir, "six.mp3"), lib.RelocationAmbiguous, ""},
+		{7, "C:\\Old\\seven.mp3", filepath.Join(newDir, "seven.mp3"), lib.RelocationMissing, ""},
+	}
+	assert.Equal(t, expected, relocations, "Songs should be relocated.")
+	for i, relocation := range relocations {
+		assert.Equal(t, relocation.NewPath, library.Songs[i].Path, "Song path should be updated.")
+	}
+}
+
+func TestRelocateInvalidSearchDir(t *testing.T) {
+	library := lib.Library{Songs: []lib.Song{{SongID: 1, Path: "/missing.mp3"}}}
+	_, err := library.Relocate(lib.RelocateOptions{SearchDirs: []string{filepath.Join("invalid", "path")}})
+	assert.NotNil(t, err, "Invalid search directory should return an error.")
+}
+
+func TestRelocateUnreadableDir(t *testing.T) {
+	dir := t.TempDir()
+	locked := filepath.Join(dir, "locked")
+	writeFile(t, filepath.Join(dir, "one.mp3"), []byte("song"))
+	writeFile(t, filepath.Join(locked, "two.mp3"), []byte("song"))
+	err := os.Chmod(locked, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(locked, 0755) })
+	if _, err := os.ReadDir(locked); err == nil {
+		t.Skip("directory permissions aren't enforced for this user")
+	}
+
+	library := lib.Library{Songs: []lib.Song{{SongID: 1, Path: "/missing/one.mp3"}}}
+	var warnings []lib.Warning
+	relocations, err := library.Relocate(lib.RelocateOptions{
+		SearchDirs: []string{dir},
+		OnWarning:  func(warning lib.Warning) { warnings = append(warnings, warning) },
+	})
+	assert.Nil(t, err, "Unreadable directories in a search directory should be skipped.")
+	assert.Equal(t, filepath.Join(dir, "one.mp3"), relocations[0].NewPath, "Readable songs should still be found.")
+	if assert.Len(t, warnings, 1, "Skipped directories should be reported.") {
+		assert.Equal(t, lib.WarningUnreadableFile, warnings[0].Type)
+		assert.Equal(t, locked, warnings[0].Path)
+	}
+}
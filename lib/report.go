@@ -0,0 +1,68 @@
+package lib
+
+import "fmt"
+
+// WarningType is the kind of problem found with a song during a conversion.
+type WarningType string
+
+const (
+	WarningCorrupt         WarningType = "corrupt"         // song data couldn't be decoded, song is removed
+	WarningMissingFile     WarningType = "missingFile"     // song file doesn't exist at its path
+	WarningUnknownKey      WarningType = "unknownKey"      // song key couldn't be converted
+	WarningUnreadableFile  WarningType = "unreadableFile"  // song file couldn't be read
+	WarningTagMismatch     WarningType = "tagMismatch"     // song metadata disagrees with its file tags
+	WarningEstimatedOffset WarningType = "estimatedOffset" // song file couldn't be read, so its decoder offset is estimated
+)
+
+// Warning is a problem with a song that didn't stop the conversion.
+type Warning struct {
+	Type    WarningType // kind of problem
+	SongID  int         // id of the song, as used by the source software
+	Path    string      // path of the song, if known
+	Message string      // human-readable description
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: song id %d (%s): %s", w.Type, w.SongID, w.Path, w.Message)
+}
+
+// Progress is the progress of a step in a conversion.
+type Progress struct {
+	Step  string // name of the step, like "songs" or "performanceData"
+	Done  int    // number of items done
+	Total int    // total number of items in the step
+}
+
+// ProgressFunc is called as a conversion makes progress.
+type ProgressFunc func(Progress)
+
+// WarningFunc is called when a conversion finds a problem with a song.
+type WarningFunc func(Warning)
+
+// Report is the summary of a conversion.
+type Report struct {
+	Warnings []Warning // warnings in the order they were found
+}
+
+// Reporter collects warnings into a Report and forwards
+// warnings and progress to optional callbacks.
+type Reporter struct {
+	OnProgress ProgressFunc // can be nil
+	OnWarning  WarningFunc  // can be nil
+	Report     Report
+}
+
+// Warn adds a warning to the report.
+func (r *Reporter) Warn(warning Warning) {
+	r.Report.Warnings = append(r.Report.Warnings, warning)
+	if r.OnWarning != nil {
+		r.OnWarning(warning)
+	}
+}
+
+// Progress reports the progress of a step.
+func (r *Reporter) Progress(step string, done int, total int) {
+	if r.OnProgress != nil {
+		r.OnProgress(Progress{Step: step, Done: done, Total: total})
+	}
+}
@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nateranda/djtools/lib/key"
+)
+
+// RuleOperator is how a smart playlist rule compares a song field.
+type RuleOperator string
+
+const (
+	RuleEquals      RuleOperator = "equals"      // field equals Value, case-insensitive for strings
+	RuleNotEquals   RuleOperator = "notEquals"   // field doesn't equal Value
+	RuleContains    RuleOperator = "contains"    // field contains Value, case-insensitive
+	RuleNotContains RuleOperator = "notContains" // field doesn't contain Value
+	RuleBetween     RuleOperator = "between"     // field is between Min and Max, inclusive
+	RuleIn          RuleOperator = "in"          // field equals any of Values, like a set of keys
+	RuleInLast      RuleOperator = "inLast"      // date field is within Within before the evaluation time
+)
+
+// SmartRule is a single condition of a smart playlist.
+type SmartRule struct {
+	Field    string        // song field, as accepted by Library.Compare
+	Operator RuleOperator  // how the field is compared
+	Value    string        // value for equals and contains rules
+	Min      float64       // minimum for between rules
+	Max      float64       // maximum for between rules
+	Values   []string      // values for in rules
+	Within   time.Duration // window for inLast rules
+}
+
+// SmartPlaylist is a group of rules that selects songs from a library.
+// An empty group matches every song if MatchAll is set, and no songs otherwise.
+type SmartPlaylist struct {
+	MatchAll bool            // songs must match every rule and group, or any of them if false
+	Rules    []SmartRule     // rules of the group
+	Groups   []SmartPlaylist // nested groups, evaluated like rules
+}
+
+// SmartPredicate compiles a smart playlist into a predicate, evaluating inLast rules at now.
+func (l *Library) SmartPredicate(smart SmartPlaylist, now time.Time) (Predicate, error) {
+	var predicates []Predicate
+	for _, rule := range smart.Rules {
+		predicate, err := l.rulePredicate(rule, now)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating smart playlist rule: %v", err)
+		}
+		predicates = append(predicates, predicate)
+	}
+	for _, group := range smart.Groups {
+		predicate, err := l.SmartPredicate(group, now)
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, predicate)
+	}
+
+	if smart.MatchAll {
+		return And(predicates...), nil
+	}
+	return Or(predicates...), nil
+}
+
+func (l *Library) rulePredicate(rule SmartRule, now time.Time) (Predicate, error) {
+	formatFloat := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	if (rule.Operator == RuleBetween || rule.Operator == RuleInLast) && !numericField(rule.Field) {
+		return nil, fmt.Errorf("rule %s needs a numeric field, got %q", rule.Operator, rule.Field)
+	}
+
+	switch rule.Operator {
+	case RuleEquals:
+		return l.Compare(rule.Field, OpEqual, rule.Value)
+	case RuleNotEquals:
+		return l.Compare(rule.Field, OpNotEqual, rule.Value)
+	case RuleContains:
+		return l.Compare(rule.Field, OpContains, rule.Value)
+	case RuleNotContains:
+		predicate, err := l.Compare(rule.Field, OpContains, rule.Value)
+		if err != nil {
+			return nil, err
+		}
+		return Not(predicate), nil
+	case RuleBetween:
+		atLeast, err := l.Compare(rule.Field, OpGreaterEqual, formatFloat(rule.Min))
+		if err != nil {
+			return nil, err
+		}
+		atMost, err := l.Compare(rule.Field, OpLessEqual, formatFloat(rule.Max))
+		if err != nil {
+			return nil, err
+		}
+		return And(atLeast, atMost), nil
+	case RuleIn:
+		var predicates []Predicate
+		for _, value := range rule.Values {
+			value, err := ruleValue(rule.Field, value)
+			if err != nil {
+				return nil, err
+			}
+			predicate, err := l.Compare(rule.Field, OpEqual, value)
+			if err != nil {
+				return nil, err
+			}
+			predicates = append(predicates, predicate)
+		}
+		return Or(predicates...), nil
+	case RuleInLast:
+		// unknown dates are stored as 0 and are never in the window
+		known, err := l.Compare(rule.Field, OpGreater, "0")
+		if err != nil {
+			return nil, err
+		}
+		start := now.Add(-rule.Within).Unix()
+		within, err := l.Compare(rule.Field, OpGreaterEqual, strconv.FormatInt(start, 10))
+		if err != nil {
+			return nil, err
+		}
+		return And(known, within), nil
+	}
+	return nil, fmt.Errorf("unknown rule operator %q", rule.Operator)
+}
+
+// ruleValue converts keys in Camelot, Open Key, or musical notation, like the ones
+// stored by Serato and Rekordbox, to the number of Song.Key. Other values are unchanged.
+func ruleValue(field string, value string) (string, error) {
+	if !strings.EqualFold(field, "key") {
+		return value, nil
+	}
+	if _, err := strconv.Atoi(value); err == nil {
+		return value, nil
+	}
+	parsed, err := key.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("rule on field %s: %v", field, err)
+	}
+	return strconv.Itoa(int(parsed)), nil
+}
+
+// EvaluateSmartPlaylist returns the ids of the songs matching a smart playlist
+// in library order, evaluating inLast rules at now.
+func (l *Library) EvaluateSmartPlaylist(smart SmartPlaylist, now time.Time) ([]int, error) {
+	predicate, err := l.SmartPredicate(smart, now)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for _, song := range l.Songs {
+		if predicate(song) {
+			ids = append(ids, song.SongID)
+		}
+	}
+	return ids, nil
+}
+
+// MaterializeSmartPlaylists evaluates every smart playlist in the library at now,
+// replacing it with a static playlist of the matching songs. Used before exporting
+// to software that doesn't support smart playlists.
+func (l *Library) MaterializeSmartPlaylists(now time.Time) error {
+	var materialize func(playlists []Playlist) error
+	materialize = func(playlists []Playlist) error {
+		for i := range playlists {
+			if playlists[i].Smart != nil {
+				ids, err := l.EvaluateSmartPlaylist(*playlists[i].Smart, now)
+				if err != nil {
+					return fmt.Errorf("error materializing smart playlist %s: %v", playlists[i].Name, err)
+				}
+				playlists[i].Songs = ids
+				playlists[i].Smart = nil
+			}
+			err := materialize(playlists[i].SubPlaylists)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return materialize(l.Playlists)
+}
+
+// hasSmartPlaylists returns true if any playlist in the tree is a smart playlist.
+func hasSmartPlaylists(playlists []Playlist) bool {
+	for _, playlist := range playlists {
+		if playlist.Smart != nil || hasSmartPlaylists(playlist.SubPlaylists) {
+			return true
+		}
+	}
+	return false
+}
+
+// clonePlaylists copies a playlist tree so it can be modified without changing the original.
+func clonePlaylists(playlists []Playlist) []Playlist {
+	if playlists == nil {
+		return nil
+	}
+	cloned := make([]Playlist, len(playlists))
+	for i, playlist := range playlists {
+		playlist.Songs = slices.Clone(playlist.Songs)
+		playlist.SubPlaylists = clonePlaylists(playlist.SubPlaylists)
+		playlist.Smart = cloneSmartPlaylist(playlist.Smart)
+		cloned[i] = playlist
+	}
+	return cloned
+}
+
+// cloneSmartPlaylist copies smart playlist rules, returning nil for static playlists.
+func cloneSmartPlaylist(smart *SmartPlaylist) *SmartPlaylist {
+	if smart == nil {
+		return nil
+	}
+	cloned := *smart
+	cloned.Rules = slices.Clone(smart.Rules)
+	for i := range cloned.Rules {
+		cloned.Rules[i].Values = slices.Clone(cloned.Rules[i].Values)
+	}
+	cloned.Groups = slices.Clone(smart.Groups)
+	for i := range cloned.Groups {
+		cloned.Groups[i] = *cloneSmartPlaylist(&cloned.Groups[i])
+	}
+	return &cloned
+}
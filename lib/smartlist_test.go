@@ -0,0 +1,133 @@
+package lib_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func smartLibrary() lib.Library {
+	day := int(24 * time.Hour / time.Second)
+	return lib.Library{
+		Songs: []lib.Song{
+			{SongID: 1, Title: "One", Artist: "DJ One", Genre: "House", Bpm: 124, Year: 2020, Rating: 80, Key: 1,
+				DateAdded: int(now.Unix()) - 2*day},
+			{SongID: 2, Title: "Two", Artist: "DJ Two", Genre: "Deep House", Bpm: 118, Year: 2015, Rating: 40, Key: 3,
+				DateAdded: int(now.Unix()) - 40*day},
+			{SongID: 3, Title: "Three", Artist: "Someone", Genre: "Techno", Bpm: 132, Year: 2024, Rating: 100, Key: 1},
+		},
+	}
+}
+
+func TestEvaluateSmartPlaylist(t *testing.T) {
+	tests := []struct {
+		name     string
+		smart    lib.SmartPlaylist
+		expected []int
+	}{
+		{"Equals", lib.SmartPlaylist{Rules: []lib.SmartRule{
+			{Field: "genre", Operator: lib.RuleEquals, Value: "house"}}}, []int{1}},
+		{"NotEquals", lib.SmartPlaylist{Rules: []lib.SmartRule{
+			{Field: "genre", Operator: lib.RuleNotEquals, Value: "house"}}}, []int{2, 3}},
+		{"Contains", lib.SmartPlaylist{Rules: []lib.SmartRule{
+			{Field: "artist", Operator: lib.RuleContains, Value: "dj"}}}, []int{1, 2}},
+		{"NotContains", lib.SmartPlaylist{Rules: []lib.SmartRule{
+			{Field: "genre", Operator: lib.RuleNotContains, Value: "house"}}}, []int{3}},
+		{"BpmRange", lib.SmartPlaylist{Rules: []lib.SmartRule{
+			{Field: "bpm", Operator: lib.RuleBetween, Min: 118, Max: 124}}}, []int{1, 2}},
+		{"YearRange", lib.SmartPlaylist{Rules: []lib.SmartRule{
+			{Field: "year", Operator: lib.RuleBetween, Min: 2020, Max: 2030}}}, []int{1, 3}},
+		{"RatingRange", lib.SmartPlaylist{Rules: []lib.SmartRule{
+			{Field: "rating", Operator: lib.RuleBetween, Min: 80, Max: 100}}}, []int{1, 3}},
+		{"KeySet", lib.SmartPlaylist{Rules: []lib.SmartRule{
+			{Field: "key", Operator: lib.RuleIn, Values: []string{"1", "2"}}}}, []int{1, 3}},
+		{"KeyNotations", lib.SmartPlaylist{Rules: []lib.SmartRule{
+			{Field: "key", Operator: lib.RuleIn, Values: []string{"Em", "10A", "1m"}}}}, []int{1, 2, 3}},
+		{"DateAdded", lib.SmartPlaylist{Rules: []lib.SmartRule{
+			{Field: "dateAdded", Operator: lib.RuleInLast, Within: 30 * 24 * time.Hour}}}, []int{1}},
+		{"MatchAll", lib.SmartPlaylist{MatchAll: true, Rules: []lib.SmartRule{
+			{Field: "genre", Operator: lib.RuleContains, Value: "house"},
+			{Field: "bpm", Operator: lib.RuleBetween, Min: 120, Max: 130},
+		}}, []int{1}},
+		{"MatchAny", lib.SmartPlaylist{Rules: []lib.SmartRule{
+			{Field: "genre", Operator: lib.RuleEquals, Value: "techno"},
+			{Field: "bpm", Operator: lib.RuleBetween, Min: 100, Max: 120},
+		}}, []int{2, 3}},
+		{"Groups", lib.SmartPlaylist{MatchAll: true,
+			Rules: []lib.SmartRule{{Field: "rating", Operator: lib.RuleBetween, Min: 60, Max: 100}},
+			Groups: []lib.SmartPlaylist{{Rules: []lib.SmartRule{
+				{Field: "genre", Operator: lib.RuleEquals, Value: "techno"},
+				{Field: "year", Operator: lib.RuleEquals, Value: "2020"},
+			}}},
+		}, []int{1, 3}},
+		{"EmptyAll", lib.SmartPlaylist{MatchAll: true}, []int{1, 2, 3}},
+		{"EmptyAny", lib.SmartPlaylist{}, []int{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			library := smartLibrary()
+			ids, err := library.EvaluateSmartPlaylist(test.smart, now)
+			assert.Nil(t, err, "Valid smart playlist should return no errors.")
+			assert.Equal(t, test.expected, ids, "Smart playlist should match the expected songs.")
+		})
+	}
+}
+
+func TestEvaluateSmartPlaylistInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule lib.SmartRule
+	}{
+		{"UnknownField", lib.SmartRule{Field: "unknown", Operator: lib.RuleEquals, Value: "1"}},
+		{"UnknownOperator", lib.SmartRule{Field: "bpm", Operator: "unknown"}},
+		{"NumberContains", lib.SmartRule{Field: "bpm", Operator: lib.RuleContains, Value: "1"}},
+		{"StringDate", lib.SmartRule{Field: "title", Operator: lib.RuleInLast, Within: time.Hour}},
+		{"UnknownKey", lib.SmartRule{Field: "key", Operator: lib.RuleIn, Values: []string{"8A", "H#"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			library := smartLibrary()
+			_, err := library.EvaluateSmartPlaylist(lib.SmartPlaylist{Rules: []lib.SmartRule{test.rule}}, now)
+			assert.NotNil(t, err, "Invalid rule should return an error.")
+		})
+	}
+}
+
+func TestMaterializeSmartPlaylists(t *testing.T) {
+	smart := &lib.SmartPlaylist{Rules: []lib.SmartRule{{Field: "genre", Operator: lib.RuleContains, Value: "house"}}}
+	library := smartLibrary()
+	library.Playlists = []lib.Playlist{
+		{Name: "folder", SubPlaylists: []lib.Playlist{{Name: "house", Smart: smart}}},
+		{Name: "static", Songs: []int{3}},
+	}
+
+	err := library.MaterializeSmartPlaylists(now)
+	assert.Nil(t, err, "Valid smart playlists should return no errors.")
+	assert.Equal(t, []lib.Playlist{
+		{Name: "folder", SubPlaylists: []lib.Playlist{{Name: "house", Songs: []int{1, 2}}}},
+		{Name: "static", Songs: []int{3}},
+	}, library.Playlists, "Smart playlists should become static playlists.")
+}
+
+func TestExportSmartPlaylists(t *testing.T) {
+	smart := &lib.SmartPlaylist{Rules: []lib.SmartRule{{Field: "genre", Operator: lib.RuleEquals, Value: "techno"}}}
+	library := smartLibrary()
+	library.Playlists = []lib.Playlist{{Name: "techno", Smart: smart}}
+
+	path := filepath.Join(t.TempDir(), "library")
+	err := lib.Export(&library, "readonly", path)
+	assert.ErrorIs(t, err, lib.ErrUnsupported, "Format without an exporter should not export.")
+
+	err = lib.Export(&library, "memory", path)
+	assert.Nil(t, err, "Valid library should export.")
+	assert.Equal(t, []lib.Playlist{{Name: "techno", Songs: []int{3}}}, memory[path].Playlists,
+		"Smart playlists should be exported as static playlists.")
+	assert.Equal(t, smart, library.Playlists[0].Smart, "Exported library should not be modified.")
+}
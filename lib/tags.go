@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/dhowden/tag"
+)
+
+// Artwork is the cover art embedded in a song file.
+type Artwork struct {
+	MIMEType string // like "image/jpeg"
+	Data     []byte // encoded image
+}
+
+// FileTags is the metadata of an audio file, read from its tags and headers.
+type FileTags struct {
+	Title       string   // title
+	Artist      string   // artist
+	Album       string   // album
+	Genre       string   // genre
+	Year        int      // release year, 0 if unknown
+	TrackNumber int      // number in album, 0 if unknown
+	Artwork     *Artwork // cover art, nil if the file has none
+	Length      float32  // length, seconds, 0 if unknown
+	Bitrate     int      // average bitrate, kbps, 0 if unknown
+	SampleRate  float64  // sample rate, hz, 0 if unknown
+	Size        int      // file size, bytes
+}
+
+// ReadFileTags reads the tags of an audio file, and its length, bitrate, and sample rate
+// from its headers if it is an MP3, MP4, or FLAC file. Files without tags aren't an error.
+func ReadFileTags(path string) (FileTags, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FileTags{}, fmt.Errorf("error reading tags: %w", err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return FileTags{}, fmt.Errorf("error reading tags: %v", err)
+	}
+
+	tags := FileTags{Size: int(stat.Size())}
+	metadata, err := tag.ReadFrom(file)
+	if err != nil && !errors.Is(err, tag.ErrNoTagsFound) {
+		return FileTags{}, fmt.Errorf("error reading tags: %v", err)
+	}
+	if err == nil {
+		tags.Title = metadata.Title()
+		tags.Artist = metadata.Artist()
+		tags.Album = metadata.Album()
+		tags.Genre = metadata.Genre()
+		tags.Year = metadata.Year()
+		tags.TrackNumber, _ = metadata.Track()
+		if picture := metadata.Picture(); picture != nil && len(picture.Data) > 0 {
+			tags.Artwork = &Artwork{MIMEType: picture.MIMEType, Data: picture.Data}
+		}
+	}
+
+	info, err := ReadAudioInfo(path)
+	if err == nil {
+		tags.Length = float32(info.Length)
+		tags.Bitrate = info.Bitrate
+		tags.SampleRate = info.SampleRate
+	}
+	return tags, nil
+}
+
+// TagOptions configures how ReadTags uses song file tags.
+type TagOptions struct {
+	Overwrite  bool         // replace library metadata that disagrees with the file instead of only reporting it
+	Cues       bool         // also read the cues, loops, and beatgrid embedded with TagWriteOptions.Cues
+	OnProgress ProgressFunc // can be nil
+	OnWarning  WarningFunc  // can be nil
+}
+
+// ReadTags reads the tags of every song file, fills empty song fields from them, and reports
+// fields where the library disagrees with the file as WarningTagMismatch warnings. Songs
+// whose file is missing or unreadable are reported and left unchanged. With options.Cues,
+// empty cues, loops, and beatgrids are filled from the cue data embedded in the file.
+func (l *Library) ReadTags(options TagOptions) Report {
+	reporter := Reporter{OnProgress: options.OnProgress, OnWarning: options.OnWarning}
+	for i := range l.Songs {
+		reporter.Progress("tags", i+1, len(l.Songs))
+		song := &l.Songs[i]
+		tags, err := ReadFileTags(song.Path)
+		if err != nil {
+			warningType := WarningUnreadableFile
+			if errors.Is(err, os.ErrNotExist) {
+				warningType = WarningMissingFile
+			}
+			reporter.Warn(Warning{Type: warningType, SongID: song.SongID, Path: song.Path, Message: err.Error()})
+			continue
+		}
+
+		merger := tagMerger{song: song, overwrite: options.Overwrite, reporter: &reporter}
+		mergeTag(&merger, "Title", &song.Title, tags.Title, sameText)
+		mergeTag(&merger, "Artist", &song.Artist, tags.Artist, sameText)
+		mergeTag(&merger, "Album", &song.Album, tags.Album, sameText)
+		mergeTag(&merger, "Genre", &song.Genre, tags.Genre, sameText)
+		mergeTag(&merger, "Year", &song.Year, tags.Year, same)
+		mergeTag(&merger, "TrackNumber", &song.TrackNumber, tags.TrackNumber, same)
+		mergeTag(&merger, "Length", &song.Length, tags.Length, sameLength)
+		mergeTag(&merger, "Bitrate", &song.Bitrate, tags.Bitrate, sameBitrate)
+		mergeTag(&merger, "SampleRate", &song.SampleRate, tags.SampleRate, same)
+		mergeTag(&merger, "Size", &song.Size, tags.Size, same)
+		if tags.Artwork != nil && (song.Artwork == nil || options.Overwrite) {
+			song.Artwork = tags.Artwork
+		}
+		if options.Cues {
+			readSongCueData(song, options.Overwrite, &reporter)
+		}
+	}
+	return reporter.Report
+}
+
+// tagMerger merges the fields of a song file's tags into the song.
+type tagMerger struct {
+	song      *Song
+	overwrite bool
+	reporter  *Reporter
+}
+
+// mergeTag fills an empty song field with the file's value, and reports a mismatch
+// if both are set and not equal.
+func mergeTag[T comparable](m *tagMerger, field string, value *T, file T, equal func(a, b T) bool) {
+	var zero T
+	switch {
+	case file == zero || equal(*value, file):
+	case *value == zero:
+		*value = file
+	default:
+		m.reporter.Warn(Warning{
+			Type:    WarningTagMismatch,
+			SongID:  m.song.SongID,
+			Path:    m.song.Path,
+			Message: fmt.Sprintf("%s is %s in the library and %s in the file", field, formatValue(*value), formatValue(file)),
+		})
+		if m.overwrite {
+			*value = file
+		}
+	}
+}
+
+// same returns true if two values are equal.
+func same[T comparable](a, b T) bool {
+	return a == b
+}
+
+// sameText returns true if two tags are the same, ignoring case and whitespace.
+func sameText(a, b string) bool {
+	return normalize(a) == normalize(b)
+}
+
+// sameLength returns true if two lengths are within a second, since software rounds them differently.
+func sameLength(a, b float32) bool {
+	return math.Abs(float64(a-b)) <= 1
+}
+
+// sameBitrate returns true if two bitrates are within 5%, since the average bitrate
+// of VBR files is estimated differently by every software.
+func sameBitrate(a, b int) bool {
+	return math.Abs(float64(a-b)) <= 0.05*float64(max(a, b))
+}
@@ -0,0 +1,113 @@
+package lib_test
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+// id3Tag returns an ID3v2.3 tag with text frames and an optional PNG cover.
+func id3Tag(frames map[string]string, cover []byte) []byte {
+	var body []byte
+	addFrame := func(id string, data []byte) {
+		body = append(body, id...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(data)))
+		body = append(body, 0, 0)
+		body = append(body, data...)
+	}
+	for _, id := range []string{"TIT2", "TPE1", "TALB", "TCON", "TYER", "TRCK"} {
+		if text, ok := frames[id]; ok {
+			addFrame(id, append([]byte{0}, text...))
+		}
+	}
+	if cover != nil {
+		addFrame("APIC", append([]byte("\x00image/png\x00\x03\x00"), cover...))
+	}
+	size := len(body)
+	header := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	return append(header, body...)
+}
+
+var songFrames = map[string]string{
+	"TIT2": "Pulsewidth",
+	"TPE1": "Aphex Twin",
+	"TALB": "Selected Ambient Works 85-92",
+	"TCON": "Ambient",
+	"TYER": "1992",
+	"TRCK": "3/13",
+}
+
+func TestReadFileTags(t *testing.T) {
+	tag := id3Tag(songFrames, []byte("png"))
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.mp3"), append(tag, mp3Frame("Info", 576)...))
+
+	tags, err := lib.ReadFileTags(path)
+	assert.Nil(t, err)
+	assert.Equal(t, lib.FileTags{
+		Title:       "Pulsewidth",
+		Artist:      "Aphex Twin",
+		Album:       "Selected Ambient Works 85-92",
+		Genre:       "Ambient",
+		Year:        1992,
+		TrackNumber: 3,
+		Artwork:     &lib.Artwork{MIMEType: "image/png", Data: []byte("png")},
+		Length:      float32(1152000 / 44100.0),
+		Bitrate:     128,
+		SampleRate:  44100,
+		Size:        len(tag) + 417,
+	}, tags)
+
+	untagged := writeFile(t, filepath.Join(t.TempDir(), "untagged.mp3"), mp3Frame("Info", 576))
+	tags, err = lib.ReadFileTags(untagged)
+	assert.Nil(t, err, "Files without tags should still be read.")
+	assert.Equal(t, "", tags.Title)
+	assert.Equal(t, 44100.0, tags.SampleRate)
+
+	_, err = lib.ReadFileTags(filepath.Join(t.TempDir(), "missing.mp3"))
+	assert.NotNil(t, err)
+}
+
+func TestReadTags(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.mp3"), append(id3Tag(songFrames, []byte("png")), mp3Frame("Info", 576)...))
+	missing := filepath.Join(t.TempDir(), "missing.mp3")
+	newLibrary := func() lib.Library {
+		return lib.Library{Songs: []lib.Song{
+			{SongID: 1, Path: path},
+			{SongID: 2, Path: path, Title: " pulsewidth", Artist: "Aphex Twin", Year: 1991, Length: 26.5, Bitrate: 130},
+			{SongID: 3, Path: missing, Title: "Missing"},
+		}}
+	}
+
+	library := newLibrary()
+	var progress []lib.Progress
+	report := library.ReadTags(lib.TagOptions{OnProgress: func(p lib.Progress) { progress = append(progress, p) }})
+	assert.Len(t, progress, 3)
+
+	filled := library.Songs[0]
+	assert.Equal(t, "Pulsewidth", filled.Title, "Empty fields should be filled from the tags.")
+	assert.Equal(t, "Ambient", filled.Genre)
+	assert.Equal(t, 1992, filled.Year)
+	assert.Equal(t, 3, filled.TrackNumber)
+	assert.Equal(t, 128, filled.Bitrate)
+	assert.Equal(t, 44100.0, filled.SampleRate)
+	assert.NotZero(t, filled.Size)
+	assert.NotZero(t, filled.Length)
+	assert.Equal(t, &lib.Artwork{MIMEType: "image/png", Data: []byte("png")}, filled.Artwork)
+
+	assert.Equal(t, []lib.Warning{
+		{Type: lib.WarningTagMismatch, SongID: 2, Path: path, Message: "Year is 1991 in the library and 1992 in the file"},
+		{Type: lib.WarningMissingFile, SongID: 3, Path: missing, Message: report.Warnings[1].Message},
+	}, report.Warnings, "Only fields that differ beyond case, whitespace, and rounding should be reported.")
+	assert.Equal(t, 1991, library.Songs[1].Year, "Mismatched fields should be kept.")
+	assert.Equal(t, " pulsewidth", library.Songs[1].Title)
+	assert.Equal(t, "Missing", library.Songs[2].Title)
+
+	library = newLibrary()
+	report = library.ReadTags(lib.TagOptions{Overwrite: true})
+	assert.Len(t, report.Warnings, 2)
+	assert.Equal(t, 1992, library.Songs[1].Year, "Mismatched fields should be replaced when overwriting.")
+	assert.Equal(t, " pulsewidth", library.Songs[1].Title, "Matching fields shouldn't be replaced.")
+}
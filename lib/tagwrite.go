@@ -0,0 +1,243 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nateranda/djtools/lib/key"
+)
+
+// tagFields are the song fields WriteTags writes to files, in the order of the Song struct.
+var tagFields = []string{"Title", "Artist", "Album", "Genre", "Year", "Bpm", "Comment", "Rating", "Key", "Color"}
+
+// tagCodec reads and writes the tag fields of an audio file format. Field values are in the
+// form returned by songTags, and each codec converts them to how its format stores them.
+type tagCodec interface {
+	read(data []byte) (map[string]string, error)
+	write(data []byte, fields map[string]string) ([]byte, error)
+}
+
+// tagCodecFor returns the codec of the tags of an audio file from its first bytes.
+func tagCodecFor(data []byte) (tagCodec, error) {
+	switch {
+	case len(data) >= 4 && string(data[:4]) == "fLaC":
+		return flacCodec{}, nil
+	case len(data) >= 4 && string(data[:4]) == "OggS":
+		return oggCodec{}, nil
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return mp4Codec{}, nil
+	case len(data) >= 3 && string(data[:3]) == "ID3", len(data) >= 2 && frameSync(data):
+		return id3Codec{}, nil
+	}
+	return nil, fmt.Errorf("%w: tags of this file type can't be written", ErrUnsupported)
+}
+
+// songTags returns the tag fields of a song as they are compared and written. Empty fields
+// are left out so they don't erase the file's tags.
+func songTags(song Song) map[string]string {
+	fields := map[string]string{
+		"Title":   song.Title,
+		"Artist":  song.Artist,
+		"Album":   song.Album,
+		"Genre":   song.Genre,
+		"Comment": song.Comment,
+		"Color":   strings.ToUpper(song.Color),
+	}
+	if song.Year > 0 {
+		fields["Year"] = strconv.Itoa(song.Year)
+	}
+	if song.Bpm > 0 {
+		fields["Bpm"] = strconv.FormatFloat(math.Round(float64(song.Bpm)*100)/100, 'f', -1, 64)
+	}
+	if song.Rating > 0 {
+		fields["Rating"] = strconv.Itoa(song.Rating)
+	}
+	if k := key.Key(song.Key); k.Valid() {
+		fields["Key"] = k.Musical()
+	}
+	for field, value := range fields {
+		if value == "" {
+			delete(fields, field)
+		}
+	}
+	return fields
+}
+
+// sameTag returns true if a file's tag field already has a value. Keys are compared in any
+// notation and bpms as numbers, since software writes them differently.
+func sameTag(field string, file string, value string) bool {
+	switch field {
+	case "Key":
+		fileKey, err := key.Parse(file)
+		valueKey, _ := key.Parse(value)
+		return err == nil && fileKey == valueKey
+	case "Bpm":
+		fileBpm, err := strconv.ParseFloat(file, 64)
+		valueBpm, _ := strconv.ParseFloat(value, 64)
+		return err == nil && math.Abs(fileBpm-valueBpm) < 0.005
+	}
+	return file == value
+}
+
+// formatTag formats a tag field for a FieldChange, summarizing cue data.
+func formatTag(field string, value string) string {
+	if field != cueDataField {
+		return formatValue(value)
+	}
+	data, err := decodeCueData(value)
+	if err != nil {
+		return "invalid cue data"
+	}
+	return data.String()
+}
+
+// TagWriteOptions configures how WriteTags writes song metadata to files.
+type TagWriteOptions struct {
+	DryRun     bool         // only return the changes, without writing any file
+	Cues       bool         // also write the cues, loops, and beatgrid, which ReadEmbeddedCues reads back
+	Keys       bool         // also write the key, off by default since a key of 0 (8B) is also what unanalyzed songs have
+	Backup     bool         // copy each file to a ".bak" file next to it before changing it, unless one exists
+	OnProgress ProgressFunc // can be nil
+	OnWarning  WarningFunc  // can be nil
+}
+
+// TagWrite is the change to the tags of a song file.
+type TagWrite struct {
+	SongID  int           // id of the song
+	Path    string        // path of the song file
+	Changes []FieldChange // changed fields in the order of the Song struct, Old is the file's value
+	Backup  string        // path of the backup, empty if none was made
+}
+
+// WriteSongTags writes the title, artist, album, genre, year, bpm, comment, rating, and color
+// of a song to the ID3v2 tag of an MP3, the Vorbis comments of a FLAC or Ogg file, or the MP4
+// atoms of an M4A file, and its key and cue data if options.Keys and options.Cues are set. Empty song fields are left
+// unchanged in the file, and files that already match aren't written. The file is replaced atomically.
+func WriteSongTags(song Song, options TagWriteOptions) (TagWrite, error) {
+	result := TagWrite{SongID: song.SongID, Path: song.Path}
+	data, err := os.ReadFile(song.Path)
+	if err != nil {
+		return result, fmt.Errorf("error writing tags: %w", err)
+	}
+	codec, err := tagCodecFor(data)
+	if err != nil {
+		return result, fmt.Errorf("error writing tags: %w", err)
+	}
+	current, err := codec.read(data)
+	if err != nil {
+		return result, fmt.Errorf("error writing tags: %v", err)
+	}
+
+	fields := songTags(song)
+	if !options.Keys {
+		// Engine imports a missing key as 0, so it can't be told apart from 8B
+		delete(fields, "Key")
+	}
+	order := tagFields
+	if data := songCueData(song); options.Cues && data != nil {
+		fields[cueDataField] = encodeCueData(*data)
+		order = append(order[:len(order):len(order)], cueDataField)
+	}
+	changed := make(map[string]string)
+	for _, field := range order {
+		value, exists := fields[field]
+		if !exists || sameTag(field, current[field], value) {
+			continue
+		}
+		change := FieldChange{Field: field, New: formatTag(field, value)}
+		if current[field] != "" {
+			change.Old = formatTag(field, current[field])
+		}
+		result.Changes = append(result.Changes, change)
+		changed[field] = value
+	}
+	if options.DryRun || len(changed) == 0 {
+		return result, nil
+	}
+
+	tagged, err := codec.write(data, changed)
+	if err != nil {
+		return result, fmt.Errorf("error writing tags: %v", err)
+	}
+	if options.Backup {
+		backup := song.Path + ".bak"
+		if _, err := os.Stat(backup); errors.Is(err, os.ErrNotExist) {
+			err = os.WriteFile(backup, data, 0644)
+			if err != nil {
+				return result, fmt.Errorf("error backing up song file: %v", err)
+			}
+			result.Backup = backup
+		}
+	}
+	err = replaceFile(song.Path, tagged)
+	if err != nil {
+		return result, fmt.Errorf("error writing tags: %v", err)
+	}
+	return result, nil
+}
+
+// replaceFile replaces the contents of a file by writing a temporary file next to it
+// and renaming it, keeping the file's permissions.
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	temp := path + ".djtools.tmp"
+	err = os.WriteFile(temp, data, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temp, path)
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// WriteTags writes the metadata of every song to its file with WriteSongTags. It returns the
+// songs whose tags changed, or would change in a dry run, in the order of l.Songs. Songs whose
+// file can't be written are reported and skipped.
+func (l *Library) WriteTags(options TagWriteOptions) ([]TagWrite, Report) {
+	reporter := Reporter{OnProgress: options.OnProgress, OnWarning: options.OnWarning}
+	var writes []TagWrite
+	for i, song := range l.Songs {
+		reporter.Progress("tags", i+1, len(l.Songs))
+		write, err := WriteSongTags(song, options)
+		if err != nil {
+			warningType := WarningUnreadableFile
+			if errors.Is(err, os.ErrNotExist) {
+				warningType = WarningMissingFile
+			}
+			reporter.Warn(Warning{Type: warningType, SongID: song.SongID, Path: song.Path, Message: err.Error()})
+			continue
+		}
+		if len(write.Changes) > 0 {
+			writes = append(writes, write)
+		}
+	}
+	return writes, reporter.Report
+}
+
+// tagStars converts a rating in multiples of 20 to the number of stars, 0-5.
+func tagStars(rating string) int {
+	value, _ := strconv.Atoi(rating)
+	return min(max(int(math.Round(float64(value)/20)), 0), 5)
+}
+
+// bytesCut splits data at the first null terminator of a string in an encoding
+// whose null is width bytes, returning the string and the rest.
+func bytesCut(data []byte, width int) ([]byte, []byte) {
+	for i := 0; i+width <= len(data); i += width {
+		if bytes.Equal(data[i:i+width], make([]byte, width)) {
+			return data[:i], data[i+width:]
+		}
+	}
+	return data, nil
+}
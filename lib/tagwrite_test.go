@@ -0,0 +1,296 @@
+package lib_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+// taggedSong is a song with every field WriteSongTags writes.
+var taggedSong = lib.Song{
+	SongID:  1,
+	Title:   "Windowlicker",
+	Artist:  "Aphex Twin",
+	Album:   "Windowlicker",
+	Genre:   "Electronic",
+	Year:    1999,
+	Bpm:     127.5,
+	Comment: "Wärp Records",
+	Rating:  80,
+	Key:     21, // 6A, Gm
+	Color:   "ff0000",
+}
+
+// oggPage returns an Ogg page of the first stream containing whole packets.
+func oggPage(headerType byte, sequence uint32, packets ...[]byte) []byte {
+	var segments, data []byte
+	for _, packet := range packets {
+		for len(packet) >= 255 {
+			segments = append(segments, 255)
+			data, packet = append(data, packet[:255]...), packet[255:]
+		}
+		segments = append(segments, byte(len(packet)))
+		data = append(data, packet...)
+	}
+	page := []byte{'O', 'g', 'g', 'S', 0, headerType}
+	page = binary.LittleEndian.AppendUint64(page, 0)
+	page = binary.LittleEndian.AppendUint32(page, 1)
+	page = binary.LittleEndian.AppendUint32(page, sequence)
+	page = append(page, 0, 0, 0, 0, byte(len(segments)))
+	return append(append(page, segments...), data...)
+}
+
+// oggFile returns an Ogg Vorbis file with a title comment and an audio page.
+func oggFile() []byte {
+	comments := binary.LittleEndian.AppendUint32(nil, 6)
+	comments = append(comments, "vendor"...)
+	comments = binary.LittleEndian.AppendUint32(comments, 1)
+	comments = binary.LittleEndian.AppendUint32(comments, 15)
+	comments = append(comments, "TITLE=Vordhosbn"...)
+	comment := append(append([]byte("\x03vorbis"), comments...), 1)
+
+	file := oggPage(2, 0, append([]byte("\x01vorbis"), make([]byte, 23)...))
+	file = append(file, oggPage(0, 1, comment, []byte("\x05vorbis setup"))...)
+	return append(file, oggPage(4, 2, []byte("audio"))...)
+}
+
+// m4aFileWithChunks returns an M4A file whose moov box is before the media data,
+// with a chunk offset pointing to "audio" at the start of the mdat box.
+func m4aFileWithChunks() []byte {
+	stco := binary.BigEndian.AppendUint32(make([]byte, 4), 1)
+	stco = binary.BigEndian.AppendUint32(stco, 0) // set below
+	ftyp := mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	moov := mp4Box("moov", mp4Box("trak", mp4Box("mdia", mp4Box("minf", mp4Box("stbl", mp4Box("stco", stco))))))
+	binary.BigEndian.PutUint32(moov[len(moov)-4:], uint32(len(ftyp)+len(moov)+8))
+	return append(append(ftyp, moov...), mp4Box("mdat", []byte("audio"))...)
+}
+
+func TestWriteSongTags(t *testing.T) {
+	id3v24 := id3Tag(map[string]string{"TIT2": "Flim", "TYER": "1997"}, nil)
+	id3v24[3] = 4
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"ID3v23.mp3", append(id3Tag(songFrames, []byte("png")), mp3Frame("Info", 576)...)},
+		{"ID3v24.mp3", append(id3v24, mp3Frame("Info", 576)...)},
+		{"Untagged.mp3", mp3Frame("Info", 576)},
+		{"Song.flac", flacFile()},
+		{"Song.ogg", oggFile()},
+		{"Song.m4a", m4aFile(44100, 2112, "")},
+		{"Tagged.m4a", m4aFile(44100, -1, " 00000000 00000840 00000000")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeFile(t, filepath.Join(t.TempDir(), test.name), test.data)
+			info, _ := lib.ReadAudioInfo(path)
+			song := taggedSong
+			song.Path = path
+
+			write, err := lib.WriteSongTags(song, lib.TagWriteOptions{Keys: true})
+			assert.Nil(t, err)
+			assert.Contains(t, write.Changes, lib.FieldChange{Field: "Key", New: `"Gm"`})
+
+			tags, err := lib.ReadFileTags(path)
+			assert.Nil(t, err, "Written tags should be readable.")
+			assert.Equal(t, song.Title, tags.Title)
+			assert.Equal(t, song.Artist, tags.Artist)
+			assert.Equal(t, song.Genre, tags.Genre)
+			assert.Equal(t, song.Year, tags.Year)
+			// the bitrate is estimated from the file size, which includes the tags
+			newInfo, _ := lib.ReadAudioInfo(path)
+			info.Bitrate, newInfo.Bitrate = 0, 0
+			assert.Equal(t, info, newInfo, "Audio data should be unchanged.")
+
+			write, err = lib.WriteSongTags(song, lib.TagWriteOptions{Keys: true, DryRun: true})
+			assert.Nil(t, err)
+			assert.Empty(t, write.Changes, "Written fields should match the song.")
+		})
+	}
+}
+
+func TestWriteSongTagsPreserve(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.mp3"), append(id3Tag(songFrames, []byte("png")), mp3Frame("Info", 576)...))
+	song := lib.Song{Path: path, Title: "Windowlicker", Key: -1}
+	_, err := lib.WriteSongTags(song, lib.TagWriteOptions{})
+	assert.Nil(t, err)
+
+	tags, err := lib.ReadFileTags(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "Windowlicker", tags.Title)
+	assert.Equal(t, songFrames["TPE1"], tags.Artist, "Empty song fields shouldn't change the file.")
+	assert.Equal(t, 3, tags.TrackNumber, "Other frames should be kept.")
+	assert.Equal(t, &lib.Artwork{MIMEType: "image/png", Data: []byte("png")}, tags.Artwork, "Cover art should be kept.")
+}
+
+func TestWriteSongTagsChunkOffsets(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.m4a"), m4aFileWithChunks())
+	song := taggedSong
+	song.Path = path
+	_, err := lib.WriteSongTags(song, lib.TagWriteOptions{})
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stco := bytes.Index(data, []byte("stco"))
+	offset := binary.BigEndian.Uint32(data[stco+12:])
+	assert.Equal(t, "audio", string(data[offset:offset+5]), "Chunk offsets should point to the moved media data.")
+}
+
+func TestWriteSongTagsLongComment(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.ogg"), oggFile())
+	song := lib.Song{Path: path, Comment: strings.Repeat("long comment ", 10000), Key: -1}
+	_, err := lib.WriteSongTags(song, lib.TagWriteOptions{})
+	assert.Nil(t, err)
+
+	write, err := lib.WriteSongTags(song, lib.TagWriteOptions{DryRun: true})
+	assert.Nil(t, err, "Comments over several pages should be readable.")
+	assert.Empty(t, write.Changes)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, bytes.HasSuffix(data, []byte("audio")), "Audio pages should be kept.")
+}
+
+func TestWriteSongTagsOptions(t *testing.T) {
+	data := append(id3Tag(songFrames, nil), mp3Frame("Info", 576)...)
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.mp3"), data)
+	song := lib.Song{SongID: 1, Path: path, Title: "Windowlicker", Rating: 100, Key: -1}
+
+	write, err := lib.WriteSongTags(song, lib.TagWriteOptions{DryRun: true, Backup: true})
+	assert.Nil(t, err)
+	assert.Equal(t, lib.TagWrite{SongID: 1, Path: path, Changes: []lib.FieldChange{
+		{Field: "Title", Old: `"Pulsewidth"`, New: `"Windowlicker"`},
+		{Field: "Rating", New: `"100"`},
+	}}, write)
+	written, _ := os.ReadFile(path)
+	assert.Equal(t, data, written, "Dry runs shouldn't change the file.")
+	assert.NoFileExists(t, path+".bak", "Dry runs shouldn't back up the file.")
+
+	write, err = lib.WriteSongTags(song, lib.TagWriteOptions{Backup: true})
+	assert.Nil(t, err)
+	assert.Equal(t, path+".bak", write.Backup)
+	backup, _ := os.ReadFile(path + ".bak")
+	assert.Equal(t, data, backup, "Backup should contain the original file.")
+
+	song.Title = "Flim"
+	write, err = lib.WriteSongTags(song, lib.TagWriteOptions{Backup: true})
+	assert.Nil(t, err)
+	assert.Empty(t, write.Backup, "Existing backups shouldn't be replaced.")
+	backup, _ = os.ReadFile(path + ".bak")
+	assert.Equal(t, data, backup)
+}
+
+func TestWriteSongTagsUnsupported(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.wav"), []byte("RIFF\x00\x00\x00\x00WAVE"))
+	_, err := lib.WriteSongTags(lib.Song{Path: path, Title: "Title"}, lib.TagWriteOptions{})
+	assert.ErrorIs(t, err, lib.ErrUnsupported)
+}
+
+func TestWriteTags(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.flac"), flacFile())
+	library := lib.Library{Songs: []lib.Song{
+		{SongID: 1, Path: path, Title: "Windowlicker", Key: -1},
+		{SongID: 2, Path: filepath.Join(t.TempDir(), "missing.flac"), Title: "Flim", Key: -1},
+	}}
+	writes, report := library.WriteTags(lib.TagWriteOptions{})
+	assert.Equal(t, []lib.TagWrite{{SongID: 1, Path: path, Changes: []lib.FieldChange{
+		{Field: "Title", New: `"Windowlicker"`},
+	}}}, writes)
+	assert.Len(t, report.Warnings, 1, "Missing files should be reported.")
+	assert.Equal(t, lib.WarningMissingFile, report.Warnings[0].Type)
+}
+
+func TestWriteSongTagsFLACWithoutStreamInfo(t *testing.T) {
+	// a single padding block, which isn't kept
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.flac"), []byte{'f', 'L', 'a', 'C', 0x81, 0, 0, 4, 0, 0, 0, 0})
+	_, err := lib.WriteSongTags(lib.Song{Path: path, Title: "Title", Key: -1}, lib.TagWriteOptions{})
+	assert.ErrorContains(t, err, "STREAMINFO")
+}
+
+// oggPages returns the header types and lacing values of the pages of an Ogg file.
+func oggPages(t *testing.T, data []byte) ([]byte, [][]byte) {
+	var headerTypes []byte
+	var segments [][]byte
+	for len(data) >= 27 {
+		count := int(data[26])
+		if len(data) < 27+count {
+			t.Fatal("Ogg page is longer than the file")
+		}
+		lacing := data[27 : 27+count]
+		length := 27 + count
+		for _, lace := range lacing {
+			length += int(lace)
+		}
+		headerTypes = append(headerTypes, data[5])
+		segments = append(segments, lacing)
+		data = data[min(length, len(data)):]
+	}
+	return headerTypes, segments
+}
+
+func TestWriteSongTagsOggPageBoundary(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.ogg"), oggFile())
+	song := lib.Song{Path: path, Comment: "x", Key: -1}
+	_, err := lib.WriteSongTags(song, lib.TagWriteOptions{})
+	assert.Nil(t, err)
+	data, _ := os.ReadFile(path)
+	_, segments := oggPages(t, data)
+	length := 0
+	for _, lace := range segments[1] {
+		length += int(lace)
+		if lace < 255 {
+			break
+		}
+	}
+
+	// make the comment packet end on the last lacing value of its page,
+	// so the setup packet starts a new page instead of continuing one
+	song.Comment = strings.Repeat("x", 1+254*255+100-length)
+	_, err = lib.WriteSongTags(song, lib.TagWriteOptions{})
+	assert.Nil(t, err)
+	data, _ = os.ReadFile(path)
+	headerTypes, segments := oggPages(t, data)
+	assert.Len(t, segments[1], 255)
+	assert.Equal(t, []byte{2, 0, 0, 4}, headerTypes, "Only pages continuing a packet should be marked as continued.")
+	write, err := lib.WriteSongTags(song, lib.TagWriteOptions{DryRun: true})
+	assert.Nil(t, err)
+	assert.Empty(t, write.Changes)
+}
+
+func TestWriteSongTagsID3Order(t *testing.T) {
+	var written [][]byte
+	for range 5 {
+		path := writeFile(t, filepath.Join(t.TempDir(), "song.mp3"), mp3Frame("Info", 576))
+		song := taggedSong
+		song.Path = path
+		_, err := lib.WriteSongTags(song, lib.TagWriteOptions{})
+		assert.Nil(t, err)
+		data, _ := os.ReadFile(path)
+		written = append(written, data)
+	}
+	for _, data := range written[1:] {
+		assert.Equal(t, written[0], data, "Frames should be written in the same order.")
+	}
+}
+
+func TestWriteSongTagsKeys(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "song.mp3"), mp3Frame("Info", 576))
+	song := lib.Song{Path: path, Title: "Windowlicker", Key: 0} // 8B, or a key that was never set
+	write, err := lib.WriteSongTags(song, lib.TagWriteOptions{DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []lib.FieldChange{{Field: "Title", New: `"Windowlicker"`}}, write.Changes, "Keys shouldn't be written by default.")
+
+	write, err = lib.WriteSongTags(song, lib.TagWriteOptions{DryRun: true, Keys: true})
+	assert.Nil(t, err)
+	assert.Contains(t, write.Changes, lib.FieldChange{Field: "Key", New: `"C"`})
+}
@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// IssueType is the kind of problem found when validating a library.
+type IssueType string
+
+const (
+	IssueMissingSong         IssueType = "missingSong"         // playlist refers to a song id that doesn't exist
+	IssueDuplicateSongID     IssueType = "duplicateSongID"     // more than one song has the same id
+	IssueDuplicatePlaylistID IssueType = "duplicatePlaylistID" // more than one playlist has the same id
+	IssueInvalidKey          IssueType = "invalidKey"          // key is outside 0-23
+	IssueInvalidRating       IssueType = "invalidRating"       // rating isn't a multiple of 20 between 0 and 100
+	IssueCueOutOfRange       IssueType = "cueOutOfRange"       // cue or loop is past the end of the song
+	IssueInvalidLoop         IssueType = "invalidLoop"         // loop ends before or where it starts
+	IssueUnsortedGrid        IssueType = "unsortedGrid"        // grid markers aren't ordered by start position
+	IssueInvalidBpm          IssueType = "invalidBpm"          // grid marker bpm isn't positive
+	IssueInvalidBeat         IssueType = "invalidBeat"         // grid marker time signature or beat in bar is invalid
+	IssueInvalidColor        IssueType = "invalidColor"        // color isn't a #RRGGBB hex code
+)
+
+// Issue is a problem that makes a library invalid.
+type Issue struct {
+	Type       IssueType // kind of problem
+	SongID     int       // id of the song, 0 if the issue is with a playlist
+	PlaylistID int       // id of the playlist, 0 if the issue is with a song
+	Message    string    // human-readable description
+}
+
+func (i Issue) String() string {
+	if i.SongID == 0 && i.PlaylistID != 0 {
+		return fmt.Sprintf("%s: playlist id %d: %s", i.Type, i.PlaylistID, i.Message)
+	}
+	return fmt.Sprintf("%s: song id %d: %s", i.Type, i.SongID, i.Message)
+}
+
+// ValidationError is returned by exporters when a library fails validation.
+type ValidationError struct {
+	Issues []Issue
+}
+
+func (e *ValidationError) Error() string {
+	var issues []string
+	for _, issue := range e.Issues {
+		issues = append(issues, issue.String())
+	}
+	return fmt.Sprintf("ValidationError: library has %d issue(s): %s", len(e.Issues), strings.Join(issues, "; "))
+}
+
+var hexColor = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// Validate checks a Library for data that can't be exported
+// and returns the issues found, in order of songs then playlists.
+func (l *Library) Validate() []Issue {
+	var issues []Issue
+	add := func(issueType IssueType, songID int, playlistID int, format string, a ...any) {
+		issues = append(issues, Issue{
+			Type:       issueType,
+			SongID:     songID,
+			PlaylistID: playlistID,
+			Message:    fmt.Sprintf(format, a...),
+		})
+	}
+
+	songIDs := make(map[int]bool)
+	for _, song := range l.Songs {
+		if songIDs[song.SongID] {
+			add(IssueDuplicateSongID, song.SongID, 0, "song id is used more than once")
+		}
+		songIDs[song.SongID] = true
+		issues = append(issues, validateSong(song)...)
+	}
+
+	playlistIDs := make(map[int]bool)
+	var validatePlaylists func(playlists []Playlist)
+	validatePlaylists = func(playlists []Playlist) {
+		for _, playlist := range playlists {
+			// playlist ids aren't set by every importer
+			if playlist.PlaylistID != 0 {
+				if playlistIDs[playlist.PlaylistID] {
+					add(IssueDuplicatePlaylistID, 0, playlist.PlaylistID, "playlist id of %q is used more than once", playlist.Name)
+				}
+				playlistIDs[playlist.PlaylistID] = true
+			}
+			for _, id := range playlist.Songs {
+				if !songIDs[id] {
+					add(IssueMissingSong, 0, playlist.PlaylistID, "playlist %q contains song id %d, which does not exist", playlist.Name, id)
+				}
+			}
+			validatePlaylists(playlist.SubPlaylists)
+		}
+	}
+	validatePlaylists(l.Playlists)
+
+	return issues
+}
+
+// lengthSlack is how far past Song.Length, in seconds, cues and loops can be.
+const lengthSlack = 1
+
+func validateSong(song Song) []Issue {
+	var issues []Issue
+	add := func(issueType IssueType, format string, a ...any) {
+		issues = append(issues, Issue{
+			Type:    issueType,
+			SongID:  song.SongID,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+	validColor := func(color string) bool {
+		return color == "" || hexColor.MatchString(color)
+	}
+	// length isn't known for every song, and is rounded down to whole seconds by
+	// rekordbox and Engine, so positions in the last partial second are allowed
+	pastEnd := func(position float64) bool {
+		return song.Length > 0 && position > float64(song.Length)+lengthSlack
+	}
+
+	if song.Key < 0 || song.Key > 23 {
+		add(IssueInvalidKey, "key %d is outside 0-23", song.Key)
+	}
+	if song.Rating < 0 || song.Rating > 100 || song.Rating%20 != 0 {
+		add(IssueInvalidRating, "rating %d must be 0, 20, 40, 60, 80, or 100", song.Rating)
+	}
+	if !validColor(song.Color) {
+		add(IssueInvalidColor, "color %q is not a #RRGGBB hex code", song.Color)
+	}
+
+	for _, cue := range song.Cues {
+		if pastEnd(cue.Offset) {
+			add(IssueCueOutOfRange, "hot cue %d at %.3fs is past the end of the song", cue.Position, cue.Offset)
+		}
+		if !validColor(cue.Color) {
+			add(IssueInvalidColor, "hot cue %d color %q is not a #RRGGBB hex code", cue.Position, cue.Color)
+		}
+	}
+
+	for _, loop := range song.Loops {
+		if loop.End <= loop.Start {
+			add(IssueInvalidLoop, "loop %d ends at %.3fs, which is not after its start at %.3fs", loop.Position, loop.End, loop.Start)
+		}
+		if pastEnd(loop.Start) {
+			add(IssueCueOutOfRange, "loop %d at %.3fs is past the end of the song", loop.Position, loop.Start)
+		}
+		if !validColor(loop.Color) {
+			add(IssueInvalidColor, "loop %d color %q is not a #RRGGBB hex code", loop.Position, loop.Color)
+		}
+	}
+
+	for i, marker := range song.Grid {
+		if marker.Bpm <= 0 {
+			add(IssueInvalidBpm, "grid marker %d has a bpm of %g", i, marker.Bpm)
+		}
+		if marker.BeatsPerBar < 0 || marker.BeatUnit < 0 {
+			add(IssueInvalidBeat, "grid marker %d has a time signature of %d/%d", i, marker.BeatsPerBar, marker.BeatUnit)
+		} else if marker.BeatNumber < 0 || marker.BeatNumber >= marker.Beats() {
+			add(IssueInvalidBeat, "grid marker %d starts on beat %d of a %d-beat bar", i, marker.BeatNumber, marker.Beats())
+		}
+		if i > 0 && marker.StartPosition < song.Grid[i-1].StartPosition {
+			add(IssueUnsortedGrid, "grid marker %d at %.3fs is before the previous marker", i, marker.StartPosition)
+		}
+	}
+
+	return issues
+}
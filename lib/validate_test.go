@@ -0,0 +1,86 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/nateranda/djtools/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func validLibrary() lib.Library {
+	return lib.Library{
+		Songs: []lib.Song{
+			{
+				SongID: 1,
+				Length: 300,
+				Key:    4,
+				Rating: 60,
+				Color:  "#F4D338",
+				Grid:   []lib.Marker{{StartPosition: 0.1, Bpm: 128}, {StartPosition: 60, Bpm: 130}},
+				Cues:   []lib.HotCue{{Offset: 30, Position: 1, Color: "#20C670"}},
+				Loops:  []lib.Loop{{Start: 30, End: 45, Position: 1}},
+			},
+			{SongID: 2},
+		},
+		Playlists: []lib.Playlist{
+			{PlaylistID: 1, Name: "folder", SubPlaylists: []lib.Playlist{
+				{PlaylistID: 2, Name: "playlist", Songs: []int{2, 1}},
+			}},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(l *lib.Library)
+		expected []lib.IssueType
+	}{
+		{"Valid", func(l *lib.Library) {}, nil},
+		{"MissingSong", func(l *lib.Library) {
+			l.Playlists[0].SubPlaylists[0].Songs = append(l.Playlists[0].SubPlaylists[0].Songs, 3)
+		}, []lib.IssueType{lib.IssueMissingSong}},
+		{"DuplicateSongID", func(l *lib.Library) { l.Songs[1].SongID = 1 }, []lib.IssueType{lib.IssueDuplicateSongID, lib.IssueMissingSong}},
+		{"DuplicatePlaylistID", func(l *lib.Library) { l.Playlists[0].SubPlaylists[0].PlaylistID = 1 }, []lib.IssueType{lib.IssueDuplicatePlaylistID}},
+		{"InvalidKey", func(l *lib.Library) { l.Songs[0].Key = 24 }, []lib.IssueType{lib.IssueInvalidKey}},
+		{"NegativeKey", func(l *lib.Library) { l.Songs[0].Key = -1 }, []lib.IssueType{lib.IssueInvalidKey}},
+		{"InvalidRating", func(l *lib.Library) { l.Songs[0].Rating = 50 }, []lib.IssueType{lib.IssueInvalidRating}},
+		{"LargeRating", func(l *lib.Library) { l.Songs[0].Rating = 120 }, []lib.IssueType{lib.IssueInvalidRating}},
+		{"CueOutOfRange", func(l *lib.Library) { l.Songs[0].Cues[0].Offset = 302 }, []lib.IssueType{lib.IssueCueOutOfRange}},
+		{"CueInLastSecond", func(l *lib.Library) { l.Songs[0].Cues[0].Offset = 300.5 }, nil},
+		{"LoopInLastSecond", func(l *lib.Library) { l.Songs[0].Loops[0].Start = 300.5; l.Songs[0].Loops[0].End = 300.9 }, nil},
+		{"LoopOutOfRange", func(l *lib.Library) { l.Songs[0].Loops[0].Start = 302; l.Songs[0].Loops[0].End = 303 }, []lib.IssueType{lib.IssueCueOutOfRange}},
+		{"UnknownLength", func(l *lib.Library) { l.Songs[0].Length = 0; l.Songs[0].Cues[0].Offset = 301 }, nil},
+		{"InvalidLoop", func(l *lib.Library) { l.Songs[0].Loops[0].End = 30 }, []lib.IssueType{lib.IssueInvalidLoop}},
+		{"UnsortedGrid", func(l *lib.Library) { l.Songs[0].Grid[1].StartPosition = 0 }, []lib.IssueType{lib.IssueUnsortedGrid}},
+		{"InvalidBpm", func(l *lib.Library) { l.Songs[0].Grid[0].Bpm = 0 }, []lib.IssueType{lib.IssueInvalidBpm}},
+		{"InvalidBeat", func(l *lib.Library) { l.Songs[0].Grid[0].BeatNumber = 4 }, []lib.IssueType{lib.IssueInvalidBeat}},
+		{"ThreeFour", func(l *lib.Library) { l.Songs[0].Grid[0].BeatsPerBar = 3; l.Songs[0].Grid[0].BeatNumber = 2 }, nil},
+		{"InvalidTimeSignature", func(l *lib.Library) { l.Songs[0].Grid[0].BeatsPerBar = -1 }, []lib.IssueType{lib.IssueInvalidBeat}},
+		{"InvalidColor", func(l *lib.Library) { l.Songs[0].Color = "F4D338" }, []lib.IssueType{lib.IssueInvalidColor}},
+		{"InvalidCueColor", func(l *lib.Library) { l.Songs[0].Cues[0].Color = "#20C67" }, []lib.IssueType{lib.IssueInvalidColor}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			library := validLibrary()
+			test.modify(&library)
+			var issueTypes []lib.IssueType
+			for _, issue := range library.Validate() {
+				issueTypes = append(issueTypes, issue.Type)
+			}
+			assert.Equal(t, test.expected, issueTypes, "Validation should find the expected issues.")
+		})
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := &lib.ValidationError{Issues: []lib.Issue{
+		{Type: lib.IssueInvalidRating, SongID: 1, Message: "rating 50 must be 0, 20, 40, 60, 80, or 100"},
+		{Type: lib.IssueMissingSong, PlaylistID: 2, Message: "playlist \"playlist\" contains song id 3, which does not exist"},
+	}}
+	assert.Equal(t, "ValidationError: library has 2 issue(s): "+
+		"invalidRating: song id 1: rating 50 must be 0, 20, 40, 60, 80, or 100; "+
+		"missingSong: playlist id 2: playlist \"playlist\" contains song id 3, which does not exist",
+		err.Error(), "Error should list every issue.")
+}
@@ -0,0 +1,416 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// vorbisFields are the Vorbis comment names of tag fields.
+var vorbisFields = map[string]string{
+	"Title":   "TITLE",
+	"Artist":  "ARTIST",
+	"Album":   "ALBUM",
+	"Genre":   "GENRE",
+	"Year":    "DATE",
+	"Bpm":     "BPM",
+	"Comment": "COMMENT",
+	"Rating":  "RATING",
+	"Key":     "INITIALKEY",
+	"Color":   "COLOR",
+
+	cueDataField: "DJTOOLS_CUES",
+}
+
+// vorbisComments are the Vorbis comments of a FLAC, Ogg Vorbis, or Opus file.
+type vorbisComments struct {
+	vendor   string
+	comments []string // comments like "TITLE=Song", in order
+}
+
+// parseVorbisComments parses Vorbis comments, returning the data after them.
+func parseVorbisComments(data []byte) (vorbisComments, []byte, error) {
+	var comments vorbisComments
+	next := func() (string, bool) {
+		if len(data) < 4 {
+			return "", false
+		}
+		length := int(binary.LittleEndian.Uint32(data))
+		if 4+length > len(data) || length < 0 {
+			return "", false
+		}
+		value := string(data[4 : 4+length])
+		data = data[4+length:]
+		return value, true
+	}
+	vendor, ok := next()
+	if !ok || len(data) < 4 {
+		return vorbisComments{}, nil, fmt.Errorf("invalid Vorbis comments")
+	}
+	comments.vendor = vendor
+	count := int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+	for range count {
+		comment, ok := next()
+		if !ok {
+			return vorbisComments{}, nil, fmt.Errorf("invalid Vorbis comments")
+		}
+		comments.comments = append(comments.comments, comment)
+	}
+	return comments, data, nil
+}
+
+func (v vorbisComments) encode() []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(v.vendor)))
+	data = append(data, v.vendor...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(v.comments)))
+	for _, comment := range v.comments {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(comment)))
+		data = append(data, comment...)
+	}
+	return data
+}
+
+// get returns the first value of a comment, whose names are case-insensitive.
+func (v vorbisComments) get(name string) string {
+	for _, comment := range v.comments {
+		key, value, _ := strings.Cut(comment, "=")
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// set replaces every value of a comment with a single value.
+func (v *vorbisComments) set(name string, value string) {
+	var comments []string
+	for _, comment := range v.comments {
+		key, _, _ := strings.Cut(comment, "=")
+		if !strings.EqualFold(key, name) {
+			comments = append(comments, comment)
+		}
+	}
+	v.comments = append(comments, name+"="+value)
+}
+
+// fields returns the tag fields of the comments.
+func (v vorbisComments) fields() map[string]string {
+	fields := make(map[string]string)
+	for field, name := range vorbisFields {
+		if value := v.get(name); value != "" {
+			fields[field] = value
+		}
+	}
+	if year := fields["Year"]; len(year) > 4 {
+		fields["Year"] = year[:4] // dates can be full dates like 2008-05-01
+	}
+	return fields
+}
+
+// setFields sets the comments of tag fields.
+func (v *vorbisComments) setFields(fields map[string]string) {
+	for field, value := range fields {
+		v.set(vorbisFields[field], value)
+	}
+}
+
+// flacPadding is the size of the padding block of a written FLAC file.
+const flacPadding = 1024
+
+// flacBlock is a metadata block of a FLAC file.
+type flacBlock struct {
+	blockType byte
+	data      []byte
+}
+
+// parseFLAC parses the metadata blocks of a FLAC file, returning the audio frames after them.
+func parseFLAC(data []byte) ([]flacBlock, []byte, error) {
+	var blocks []flacBlock
+	data = data[4:]
+	for {
+		if len(data) < 4 {
+			return nil, nil, fmt.Errorf("FLAC metadata is longer than the file")
+		}
+		header := data[0]
+		length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		if 4+length > len(data) {
+			return nil, nil, fmt.Errorf("FLAC metadata is longer than the file")
+		}
+		blocks = append(blocks, flacBlock{header & 0x7F, data[4 : 4+length]})
+		data = data[4+length:]
+		if header&0x80 != 0 {
+			return blocks, data, nil
+		}
+	}
+}
+
+// flacCodec reads and writes the Vorbis comments of FLAC files.
+type flacCodec struct{}
+
+func (flacCodec) read(data []byte) (map[string]string, error) {
+	blocks, _, err := parseFLAC(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		if block.blockType == 4 {
+			comments, _, err := parseVorbisComments(block.data)
+			if err != nil {
+				return nil, err
+			}
+			return comments.fields(), nil
+		}
+	}
+	return map[string]string{}, nil
+}
+
+// write writes the comments in a VORBIS_COMMENT block after the STREAMINFO block, replacing
+// the padding with a new padding block at the end of the metadata.
+func (flacCodec) write(data []byte, fields map[string]string) ([]byte, error) {
+	blocks, audio, err := parseFLAC(data)
+	if err != nil {
+		return nil, err
+	}
+	comments := vorbisComments{vendor: "djtools"}
+	var kept []flacBlock
+	for _, block := range blocks {
+		switch block.blockType {
+		case 4:
+			comments, _, err = parseVorbisComments(block.data)
+			if err != nil {
+				return nil, err
+			}
+		case 1: // padding
+		default:
+			kept = append(kept, block)
+		}
+	}
+	if len(kept) == 0 || kept[0].blockType != 0 {
+		return nil, fmt.Errorf("FLAC metadata doesn't start with a STREAMINFO block")
+	}
+	comments.setFields(fields)
+
+	blocks = append([]flacBlock{kept[0], {4, comments.encode()}}, kept[1:]...)
+	blocks = append(blocks, flacBlock{1, make([]byte, flacPadding)})
+	out := []byte("fLaC")
+	for i, block := range blocks {
+		header := block.blockType
+		if i == len(blocks)-1 {
+			header |= 0x80
+		}
+		length := len(block.data)
+		out = append(out, header, byte(length>>16), byte(length>>8), byte(length))
+		out = append(out, block.data...)
+	}
+	return append(out, audio...), nil
+}
+
+// oggCRC is the lookup table of the CRC-32 of Ogg pages, with polynomial 0x04C11DB7
+// and without reflection.
+var oggCRC = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// oggPage is a page of an Ogg file.
+type oggPage struct {
+	headerType byte // 1 if the page continues a packet, 2 for the first page, 4 for the last
+	granule    uint64
+	serial     uint32
+	sequence   uint32
+	segments   []byte // lacing values, a value under 255 ends a packet
+	data       []byte
+}
+
+// parseOgg parses the pages of an Ogg file.
+func parseOgg(data []byte) ([]oggPage, error) {
+	var pages []oggPage
+	for len(data) > 0 {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			return nil, fmt.Errorf("invalid Ogg page")
+		}
+		count := int(data[26])
+		if len(data) < 27+count {
+			return nil, fmt.Errorf("invalid Ogg page")
+		}
+		page := oggPage{
+			headerType: data[5],
+			granule:    binary.LittleEndian.Uint64(data[6:]),
+			serial:     binary.LittleEndian.Uint32(data[14:]),
+			sequence:   binary.LittleEndian.Uint32(data[18:]),
+			segments:   data[27 : 27+count],
+		}
+		length := 0
+		for _, lace := range page.segments {
+			length += int(lace)
+		}
+		if len(data) < 27+count+length {
+			return nil, fmt.Errorf("Ogg page is longer than the file")
+		}
+		page.data = data[27+count : 27+count+length]
+		pages = append(pages, page)
+		data = data[27+count+length:]
+	}
+	return pages, nil
+}
+
+func (p oggPage) encode() []byte {
+	out := []byte{'O', 'g', 'g', 'S', 0, p.headerType}
+	out = binary.LittleEndian.AppendUint64(out, p.granule)
+	out = binary.LittleEndian.AppendUint32(out, p.serial)
+	out = binary.LittleEndian.AppendUint32(out, p.sequence)
+	out = append(out, 0, 0, 0, 0, byte(len(p.segments)))
+	out = append(out, p.segments...)
+	out = append(out, p.data...)
+	var crc uint32
+	for _, b := range out {
+		crc = crc<<8 ^ oggCRC[byte(crc>>24)^b]
+	}
+	binary.LittleEndian.PutUint32(out[22:], crc)
+	return out
+}
+
+// oggHeaders returns the header packets after the identification header of the first stream
+// of an Ogg file, and the index of the first page after them. Vorbis has a comment and a setup
+// header, and Opus only a comment header.
+func oggHeaders(pages []oggPage) ([][]byte, int, error) {
+	if len(pages) == 0 {
+		return nil, 0, fmt.Errorf("Ogg file has no pages")
+	}
+	count := 0
+	switch {
+	case bytes.HasPrefix(pages[0].data, []byte("\x01vorbis")):
+		count = 2
+	case bytes.HasPrefix(pages[0].data, []byte("OpusHead")):
+		count = 1
+	default:
+		return nil, 0, fmt.Errorf("%w: tags of this Ogg codec can't be written", ErrUnsupported)
+	}
+
+	var packets [][]byte
+	var packet []byte
+	i := 1
+	for ; i < len(pages) && len(packets) < count; i++ {
+		page := pages[i]
+		if page.serial != pages[0].serial {
+			return nil, 0, fmt.Errorf("%w: multiplexed Ogg streams can't be written", ErrUnsupported)
+		}
+		offset := 0
+		for _, lace := range page.segments {
+			packet = append(packet, page.data[offset:offset+int(lace)]...)
+			offset += int(lace)
+			if lace < 255 {
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+	}
+	if len(packets) != count || packet != nil {
+		return nil, 0, fmt.Errorf("invalid Ogg headers")
+	}
+	return packets, i, nil
+}
+
+// oggPaginate splits packets into pages of a stream, starting at a sequence number.
+func oggPaginate(packets [][]byte, serial uint32, sequence uint32) []oggPage {
+	var pages []oggPage
+	page := oggPage{serial: serial, sequence: sequence}
+	// a page where no packet ends has a granule position of -1
+	page.granule = ^uint64(0)
+	for _, packet := range packets {
+		for offset := 0; ; offset += 255 {
+			if len(page.segments) == 255 {
+				pages = append(pages, page)
+				sequence++
+				page = oggPage{granule: ^uint64(0), serial: serial, sequence: sequence}
+				if offset > 0 {
+					page.headerType = 1 // the page continues the packet
+				}
+			}
+			lace := min(len(packet)-offset, 255)
+			page.segments = append(page.segments, byte(lace))
+			page.data = append(page.data, packet[offset:offset+lace]...)
+			if lace < 255 {
+				page.granule = 0
+				break
+			}
+		}
+	}
+	return append(pages, page)
+}
+
+// oggCodec reads and writes the comments of Ogg Vorbis and Opus files.
+type oggCodec struct{}
+
+// oggCommentPrefix returns the bytes before the comments of a comment header.
+func oggCommentPrefix(packet []byte) []byte {
+	if bytes.HasPrefix(packet, []byte("OpusTags")) {
+		return packet[:8]
+	}
+	return packet[:min(7, len(packet))] // "\x03vorbis"
+}
+
+func (oggCodec) read(data []byte) (map[string]string, error) {
+	pages, err := parseOgg(data)
+	if err != nil {
+		return nil, err
+	}
+	packets, _, err := oggHeaders(pages)
+	if err != nil {
+		return nil, err
+	}
+	prefix := oggCommentPrefix(packets[0])
+	comments, _, err := parseVorbisComments(packets[0][len(prefix):])
+	if err != nil {
+		return nil, err
+	}
+	return comments.fields(), nil
+}
+
+// write replaces the comment header, rewriting the header pages and renumbering the pages after them.
+func (oggCodec) write(data []byte, fields map[string]string) ([]byte, error) {
+	pages, err := parseOgg(data)
+	if err != nil {
+		return nil, err
+	}
+	packets, next, err := oggHeaders(pages)
+	if err != nil {
+		return nil, err
+	}
+	prefix := oggCommentPrefix(packets[0])
+	comments, rest, err := parseVorbisComments(packets[0][len(prefix):])
+	if err != nil {
+		return nil, err
+	}
+	comments.setFields(fields)
+	// Vorbis ends the comment header with a framing bit, and Opus can keep binary data after it
+	packets[0] = append(append(append([]byte{}, prefix...), comments.encode()...), rest...)
+
+	serial := pages[0].serial
+	headers := oggPaginate(packets, serial, pages[0].sequence+1)
+	out := pages[0].encode()
+	for _, page := range headers {
+		out = append(out, page.encode()...)
+	}
+	shift := int64(len(headers)) - int64(next-1)
+	for _, page := range pages[next:] {
+		if page.serial == serial {
+			page.sequence = uint32(int64(page.sequence) + shift)
+		}
+		out = append(out, page.encode()...)
+	}
+	return out, nil
+}
@@ -0,0 +1,12 @@
+// djtools converts and inspects DJ software libraries.
+package main
+
+import (
+	"os"
+
+	"github.com/nateranda/djtools/cli"
+)
+
+func main() {
+	os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -1,6 +1,7 @@
 package rbxml
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"path/filepath"
@@ -9,6 +10,8 @@ import (
 	"time"
 
 	"github.com/nateranda/djtools/lib"
+	"github.com/nateranda/djtools/lib/color"
+	"github.com/nateranda/djtools/lib/key"
 )
 
 func unixToDate(date int, options ExportOptions) string {
@@ -30,58 +33,12 @@ func pathToURI(path string) string {
 	return "file://localhost/" + uriPath
 }
 
-func exportConvertTonality(key int) (string, error) {
-	switch key {
-	case 0:
-		return "8B", nil
-	case 1:
-		return "8A", nil
-	case 2:
-		return "9B", nil
-	case 3:
-		return "9A", nil
-	case 4:
-		return "10B", nil
-	case 5:
-		return "10A", nil
-	case 6:
-		return "11B", nil
-	case 7:
-		return "11A", nil
-	case 8:
-		return "12B", nil
-	case 9:
-		return "12A", nil
-	case 10:
-		return "1B", nil
-	case 11:
-		return "1A", nil
-	case 12:
-		return "2B", nil
-	case 13:
-		return "2A", nil
-	case 14:
-		return "3B", nil
-	case 15:
-		return "3A", nil
-	case 16:
-		return "4B", nil
-	case 17:
-		return "4A", nil
-	case 18:
-		return "5B", nil
-	case 19:
-		return "5A", nil
-	case 20:
-		return "6B", nil
-	case 21:
-		return "6A", nil
-	case 22:
-		return "7B", nil
-	case 23:
-		return "7A", nil
+func exportConvertTonality(k int) (string, error) {
+	tonality := key.Key(k).Camelot()
+	if tonality == "" {
+		return "", fmt.Errorf("tonality '%d' is outside the accepted range", k)
 	}
-	return "", fmt.Errorf("tonality '%d' is outside the accepted range", key)
+	return tonality, nil
 }
 
 func exportConvertRating(rating int) (int32, error) {
@@ -102,13 +59,36 @@ func exportConvertRating(rating int) (int32, error) {
 	return -1, fmt.Errorf("NoMatchError: rating %d did not match convention. Must be 0, 20, 40, 60, 80, or 100", rating)
 }
 
-func exportConvertPositionMarks(song *lib.Song) []positionMark {
-	var offset float64
-	if song.Filetype == "mp3" {
-		offset = 0.05
-	} else {
-		offset = 0
+// exportConvertColor converts a hex color to the 0x-prefixed hex of a Colour attribute,
+// mapped to the nearest Rekordbox track color.
+func exportConvertColor(hex string) (string, error) {
+	snapped, err := color.RekordboxTracks.Snap(hex)
+	if err != nil || snapped == "" {
+		return "", err
+	}
+	return "0x" + strings.TrimPrefix(snapped, "#"), nil
+}
+
+// exportConvertMarkColor sets the color of a position mark, mapped to the nearest
+// Rekordbox cue color if snap is set.
+func exportConvertMarkColor(mark *positionMark, hex string, snap bool) error {
+	if hex == "" {
+		return nil
+	}
+	c, err := color.Parse(hex)
+	if err != nil {
+		return err
 	}
+	if snap {
+		c = color.RekordboxCues.Nearest(c)
+	}
+	mark.Red, mark.Green, mark.Blue = &c.R, &c.G, &c.B
+	return nil
+}
+
+// exportConvertPositionMarks converts a song's cue, hot cues, and loops to position marks,
+// adding offset to move them to Rekordbox's timeline.
+func exportConvertPositionMarks(song *lib.Song, offset float64, options ExportOptions) ([]positionMark, error) {
 	var positionMarks []positionMark
 	// add cue point
 	positionMarks = append(positionMarks, positionMark{
@@ -119,43 +99,51 @@ func exportConvertPositionMarks(song *lib.Song) []positionMark {
 
 	// add hot cues
 	for _, cue := range song.Cues {
-		positionMarks = append(positionMarks, positionMark{
+		mark := positionMark{
 			Name:     cue.Name,
 			MarkType: 0,
 			Start:    cue.Offset + offset,
 			Num:      int32(cue.Position - 1),
-		})
+		}
+		err := exportConvertMarkColor(&mark, cue.Color, options.SnapColors)
+		if err != nil {
+			return nil, fmt.Errorf("error converting hot cue color: %v", err)
+		}
+		positionMarks = append(positionMarks, mark)
 	}
 
 	// add loops
 	for _, loop := range song.Loops {
-		positionMarks = append(positionMarks, positionMark{
+		mark := positionMark{
 			Name:     loop.Name,
 			MarkType: 4,
 			Start:    loop.Start + offset,
 			End:      loop.End + offset,
 			Num:      int32(loop.Position - 1),
-		})
+		}
+		err := exportConvertMarkColor(&mark, loop.Color, options.SnapColors)
+		if err != nil {
+			return nil, fmt.Errorf("error converting loop color: %v", err)
+		}
+		positionMarks = append(positionMarks, mark)
 	}
 
-	return positionMarks
+	return positionMarks, nil
 }
 
-func exportConvertGrid(song *lib.Song) []tempo {
+// exportConvertGrid converts a song's grid to tempos, adding offset to move them to Rekordbox's timeline.
+func exportConvertGrid(song *lib.Song, offset float64) []tempo {
 	var tempos []tempo
-
-	var offset float64
-	if song.Filetype == "mp3" {
-		offset = 0.05
-	} else {
-		offset = 0
+	grid := slices.Clone(song.Grid)
+	for i := range grid {
+		grid[i].StartPosition += offset
 	}
-
-	for _, grid := range song.Grid {
+	// Rekordbox can't store grids that start before the song, like Engine's
+	for _, grid := range lib.TrimGrid(grid, 0) {
 		tempos = append(tempos, tempo{
-			Inizio:  grid.StartPosition + offset,
+			Inizio:  grid.StartPosition,
 			Bpm:     grid.Bpm,
-			Metro:   "4/4",                      // assumed, may add time signature support later
+			Metro:   grid.TimeSignature(),
 			Battito: int32(grid.BeatNumber) + 1, // Battito is 1-indexed
 		})
 	}
@@ -223,7 +211,14 @@ func exportConvertPlaylist(library *lib.Library) node {
 
 func exportConvertSong(library *lib.Library, options ExportOptions) ([]track, error) {
 	var tracks []track
-	for _, song := range library.Songs {
+	offsets, err := lib.SongOffsets(context.Background(), library.Songs, lib.GaplessDecoder, Decoder, lib.OffsetOptions{
+		ReadFiles:   options.ReadAudioFiles,
+		Parallelism: options.Parallelism,
+	}, &lib.Reporter{OnWarning: options.OnWarning})
+	if err != nil {
+		return nil, err
+	}
+	for i, song := range library.Songs {
 		rating, err := exportConvertRating(song.Rating)
 		if err != nil {
 			return nil, fmt.Errorf("error converting song rating: %v", err)
@@ -233,8 +228,16 @@ func exportConvertSong(library *lib.Library, options ExportOptions) ([]track, er
 		if err != nil {
 			return nil, fmt.Errorf("error converting song tonality: %v", err)
 		}
-		positionMarks := exportConvertPositionMarks(&song)
-		tempos := exportConvertGrid(&song)
+		offset := offsets[i]
+		positionMarks, err := exportConvertPositionMarks(&song, offset, options)
+		if err != nil {
+			return nil, fmt.Errorf("error converting song position marks: %v", err)
+		}
+		colour, err := exportConvertColor(song.Color)
+		if err != nil {
+			return nil, fmt.Errorf("error converting song color: %v", err)
+		}
+		tempos := exportConvertGrid(&song, offset)
 		tracks = append(tracks, track{
 			TrackId:      song.SongID,
 			Name:         song.Title,
@@ -262,7 +265,7 @@ func exportConvertSong(library *lib.Library, options ExportOptions) ([]track, er
 			Tonality:     tonality,
 			Label:        song.Label,
 			Mix:          song.Mix,
-			Colour:       song.Color,
+			Colour:       colour,
 			PositionMark: &positionMarks,
 			Tempo:        &tempos,
 		})
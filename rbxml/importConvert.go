@@ -1,6 +1,7 @@
 package rbxml
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"path/filepath"
@@ -9,15 +10,27 @@ import (
 	"time"
 
 	"github.com/nateranda/djtools/lib"
+	"github.com/nateranda/djtools/lib/color"
+	"github.com/nateranda/djtools/lib/key"
 )
 
-func importConvert(djPlaylists *djPlaylists) (lib.Library, error) {
+func importConvert(ctx context.Context, djPlaylists *djPlaylists, importOptions ImportOptions, reporter *lib.Reporter) (lib.Library, error) {
 	var library lib.Library
 	var err error
 	library.Songs, err = importConvertSong(djPlaylists)
 	if err != nil {
 		return lib.Library{}, err
 	}
+	offsets, err := lib.SongOffsets(ctx, library.Songs, Decoder, lib.GaplessDecoder, lib.OffsetOptions{
+		ReadFiles:   importOptions.ReadAudioFiles,
+		Parallelism: importOptions.Parallelism,
+	}, reporter)
+	if err != nil {
+		return lib.Library{}, err
+	}
+	for i := range library.Songs {
+		library.Songs[i].ShiftPositions(offsets[i])
+	}
 	library.Playlists = importConvertPlaylists(djPlaylists)
 
 	return library, nil
@@ -54,7 +67,14 @@ func importConvertSong(djPlaylists *djPlaylists) ([]lib.Song, error) {
 		if track.AverageBpm == 0 {
 			corrupt = true
 		}
-		markers := importConvertGrid(track)
+		color, err := importConvertColor(track.Colour)
+		if err != nil {
+			return nil, fmt.Errorf("error converting song: %v", err)
+		}
+		markers, err := importConvertGrid(track)
+		if err != nil {
+			return nil, fmt.Errorf("error converting song: %v", err)
+		}
 		cues, loops := importConvertCuesLoops(track)
 		song := lib.Song{
 			SongID:       track.TrackId,
@@ -83,7 +103,7 @@ func importConvertSong(djPlaylists *djPlaylists) ([]lib.Song, error) {
 			Key:          key,
 			Label:        track.Label,
 			Mix:          track.Mix,
-			Color:        track.Colour,
+			Color:        color,
 			Grid:         markers,
 			Cues:         cues,
 			Loops:        loops,
@@ -138,19 +158,27 @@ func importConvertSubPlaylists(rbPlaylists *[]node, id *int) ([]lib.Playlist, []
 	return playlists, subSongs
 }
 
-func importConvertGrid(track track) []lib.Marker {
+func importConvertGrid(track track) ([]lib.Marker, error) {
 	if track.Tempo == nil {
-		return nil
+		return nil, nil
 	}
 	var markers []lib.Marker
 	for _, tempo := range *track.Tempo {
-		markers = append(markers, lib.Marker{
+		marker := lib.Marker{
 			StartPosition: tempo.Inizio,
 			Bpm:           tempo.Bpm,
 			BeatNumber:    int(tempo.Battito) - 1, // BeatNumber is 0-indexed
-		})
+		}
+		// older exports leave out Metro, which means 4/4
+		if tempo.Metro != "" {
+			err := marker.SetTimeSignature(tempo.Metro)
+			if err != nil {
+				return nil, fmt.Errorf("error converting grid: %v", err)
+			}
+		}
+		markers = append(markers, marker)
 	}
-	return markers
+	return markers, nil
 }
 
 func importConvertCuesLoops(track track) ([]lib.HotCue, []lib.Loop) {
@@ -165,6 +193,7 @@ func importConvertCuesLoops(track track) ([]lib.HotCue, []lib.Loop) {
 				Name:     mark.Name,
 				Offset:   mark.Start,
 				Position: int(mark.Num) + 1, // Position is 1-indexed
+				Color:    importConvertMarkColor(mark),
 			})
 		}
 		if mark.MarkType == 4 {
@@ -173,12 +202,33 @@ func importConvertCuesLoops(track track) ([]lib.HotCue, []lib.Loop) {
 				Start:    mark.Start,
 				End:      mark.End,
 				Position: int(mark.Num) + 1, // Position is 1-indexed
+				Color:    importConvertMarkColor(mark),
 			})
 		}
 	}
 	return cues, loops
 }
 
+// importConvertColor converts a 0x-prefixed Colour attribute to a hex color.
+func importConvertColor(colour string) (string, error) {
+	if colour == "" {
+		return "", nil
+	}
+	c, err := color.Parse(colour)
+	if err != nil {
+		return "", fmt.Errorf("error converting colour: %v", err)
+	}
+	return c.Hex(), nil
+}
+
+// importConvertMarkColor returns the hex color of a position mark, or "" if it has none.
+func importConvertMarkColor(mark positionMark) string {
+	if mark.Red == nil || mark.Green == nil || mark.Blue == nil {
+		return ""
+	}
+	return color.RGB{R: *mark.Red, G: *mark.Green, B: *mark.Blue}.Hex()
+}
+
 func dateToUnix(date string) (int, error) {
 	if date == "" {
 		return 0, nil
@@ -208,58 +258,14 @@ func uriToPath(uri string) (string, error) {
 	return path, nil
 }
 
+// tonalityToInt accepts Camelot tonalities, which Rekordbox writes by default,
+// and the musical or Open Key tonalities it writes with other key display settings.
 func tonalityToInt(tonality string) (int, error) {
-	switch tonality {
-	case "8B":
-		return 0, nil
-	case "8A":
-		return 1, nil
-	case "9B":
-		return 2, nil
-	case "9A":
-		return 3, nil
-	case "10B":
-		return 4, nil
-	case "10A":
-		return 5, nil
-	case "11B":
-		return 6, nil
-	case "11A":
-		return 7, nil
-	case "12B":
-		return 8, nil
-	case "12A":
-		return 9, nil
-	case "1B":
-		return 10, nil
-	case "1A":
-		return 11, nil
-	case "2B":
-		return 12, nil
-	case "2A":
-		return 13, nil
-	case "3B":
-		return 14, nil
-	case "3A":
-		return 15, nil
-	case "4B":
-		return 16, nil
-	case "4A":
-		return 17, nil
-	case "5B":
-		return 18, nil
-	case "5A":
-		return 19, nil
-	case "6B":
-		return 20, nil
-	case "6A":
-		return 21, nil
-	case "7B":
-		return 22, nil
-	case "7A":
-		return 23, nil
+	k, err := key.Parse(tonality)
+	if err != nil {
+		return -1, fmt.Errorf("tonality '%s' is outside the accepted range", tonality)
 	}
-	return -1, fmt.Errorf("tonality '%s' is outside the accepted range", tonality)
+	return int(k), nil
 }
 
 func importConvertRating(rating int32) (int, error) {
@@ -2,6 +2,7 @@
 package rbxml
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"os"
@@ -12,8 +13,23 @@ import (
 
 const version string = "0.1"
 
+// ImportOptions contains the options used when importing a Rekordbox XML file.
+type ImportOptions struct {
+	ReadAudioFiles bool             // read song file headers for decoder offsets instead of estimating them from the filetype
+	Parallelism    int              // number of song files read at once, runtime.GOMAXPROCS(0) if 0
+	OnProgress     lib.ProgressFunc // called as song files are read, can be nil
+	OnWarning      lib.WarningFunc  // called for each song whose offset had to be estimated, can be nil
+}
+
 type ExportOptions struct {
 	UseUTC bool
+	// SnapColors maps hot cue and loop colors to the nearest Rekordbox cue color.
+	// Rekordbox XML stores exact RGB cue colors if false. Track colors are always
+	// mapped to the nearest Rekordbox track color, since Colour only accepts those.
+	SnapColors     bool
+	ReadAudioFiles bool            // read song file headers for decoder offsets instead of estimating them from the filetype
+	Parallelism    int             // number of song files read at once, runtime.GOMAXPROCS(0) if 0
+	OnWarning      lib.WarningFunc // called for each song whose offset had to be estimated, can be nil
 }
 
 type product struct {
@@ -67,7 +83,10 @@ type positionMark struct {
 	MarkType int32   `xml:"Type,attr"` // cue=0, fade-in=1, fade-out=2, load=3, loop=4
 	Start    float64 `xml:"Start,attr"`
 	End      float64 `xml:"End,attr,omitempty"`
-	Num      int32   `xml:"Num,attr"` // hot cue: 0, 1, 2... memory cue: -1
+	Num      int32   `xml:"Num,attr"`           // hot cue: 0, 1, 2... memory cue: -1
+	Red      *uint8  `xml:"Red,attr,omitempty"` // hot cue and loop color, unset for memory cues
+	Green    *uint8  `xml:"Green,attr,omitempty"`
+	Blue     *uint8  `xml:"Blue,attr,omitempty"`
 }
 
 type node struct {
@@ -152,24 +171,104 @@ func (d *djPlaylists) read(path string) error {
 	return nil
 }
 
-func Import(path string) (lib.Library, error) {
+// Decoder is Rekordbox's MP3 and AAC decoder, which plays the header frame and encoder delay
+// of MP3s and the priming of AAC files.
+var Decoder = lib.Decoder{}
+
+func init() {
+	lib.Register(lib.Format{
+		Name:        "rbxml",
+		Description: "Rekordbox XML",
+		Capabilities: lib.Capabilities{
+			Loops:               true,
+			MultipleGridMarkers: true,
+			Colors:              true,
+		},
+		Importer: Importer{},
+		Exporter: Exporter{},
+		Detect:   detect,
+	})
+}
+
+// Importer imports Rekordbox XML files with the given options.
+// It implements lib.Importer.
+type Importer struct {
+	Options ImportOptions
+}
+
+// Import converts a Rekordbox XML file into a djtools Library struct.
+func (i Importer) Import(path string) (lib.Library, error) {
+	return Import(path, i.Options)
+}
+
+// ImportContext converts a Rekordbox XML file into a djtools Library struct,
+// stopping early if ctx is canceled. It implements lib.ContextImporter.
+func (i Importer) ImportContext(ctx context.Context, path string) (lib.Library, lib.Report, error) {
+	return ImportContext(ctx, path, i.Options)
+}
+
+// Exporter exports Rekordbox XML files with the given options.
+// It implements lib.Exporter.
+type Exporter struct {
+	Options ExportOptions
+}
+
+// Export converts a djtools Library struct into a Rekordbox XML file.
+func (e Exporter) Export(library *lib.Library, path string) error {
+	return Export(library, path, e.Options)
+}
+
+// detect detects an XML file with a DJ_PLAYLISTS root element.
+func detect(path string) lib.Detection {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return lib.Detection{}
+	}
+	element, err := lib.ReadXMLRoot(path)
+	if err != nil || element.Name.Local != "DJ_PLAYLISTS" {
+		return lib.Detection{}
+	}
+	var version string
+	for _, attr := range element.Attr {
+		if attr.Name.Local == "Version" {
+			version = attr.Value
+		}
+	}
+	return lib.Detection{Version: version, Confidence: 1, Path: path}
+}
+
+// Import converts a Rekordbox XML file into a djtools Library struct.
+func Import(path string, importOptions ImportOptions) (lib.Library, error) {
+	library, _, err := ImportContext(context.Background(), path, importOptions)
+	return library, err
+}
+
+// ImportContext converts a Rekordbox XML file into a djtools Library struct,
+// stopping early if ctx is canceled. It also returns a Report of
+// the songs whose decoder offsets had to be estimated.
+func ImportContext(ctx context.Context, path string, importOptions ImportOptions) (lib.Library, lib.Report, error) {
+	reporter := &lib.Reporter{OnProgress: importOptions.OnProgress, OnWarning: importOptions.OnWarning}
 	var djPlaylists djPlaylists
 	err := djPlaylists.read(path)
 	if err != nil {
-		return lib.Library{}, err
+		return lib.Library{}, reporter.Report, err
 	}
 
-	library, err := importConvert(&djPlaylists)
+	library, err := importConvert(ctx, &djPlaylists, importOptions, reporter)
 	if err != nil {
-		return lib.Library{}, err
+		return lib.Library{}, reporter.Report, err
 	}
 
 	library.CheckCorruptedSongs()
 
-	return library, nil
+	return library, reporter.Report, nil
 }
 
 func Export(library *lib.Library, path string, options ExportOptions) error {
+	issues := library.Validate()
+	if len(issues) > 0 {
+		return &lib.ValidationError{Issues: issues}
+	}
 	djPlaylists, err := exportConvert(library, options)
 	if err != nil {
 		return err
@@ -1,6 +1,7 @@
 package rbxml_test
 
 import (
+	"context"
 	"errors"
 	"os"
 	"path/filepath"
@@ -44,6 +45,35 @@ func TestExportInvalidPath(t *testing.T) {
 		err, "invalid path should throw an error")
 }
 
+func TestExportInvalid(t *testing.T) {
+	var library lib.Library
+	liberr := library.Load(filepath.Join(jsonDirExport, "songs.json"))
+	if liberr != nil {
+		t.Fatal(liberr)
+	}
+	library.Songs[0].Rating = 50
+	path := filepath.Join(t.TempDir(), "library.xml")
+	err := rbxml.Export(&library, path, exportOptions)
+	var validationErr *lib.ValidationError
+	assert.ErrorAs(t, err, &validationErr, "Invalid library should return a validation error.")
+	assert.NoFileExists(t, path, "Invalid library should not be written.")
+}
+
+func TestExportLastSecond(t *testing.T) {
+	var library lib.Library
+	liberr := library.Load(filepath.Join(jsonDirExport, "songs.json"))
+	if liberr != nil {
+		t.Fatal(liberr)
+	}
+	// lengths are whole seconds, so an outro can be just past the length
+	song := &library.Songs[0]
+	song.Length = 200
+	song.Cues = []lib.HotCue{{Offset: float64(song.Length) + 0.5, Position: 1}}
+	song.Loops = []lib.Loop{{Start: float64(song.Length) + 0.5, End: float64(song.Length) + 0.9, Position: 2}}
+	err := rbxml.Export(&library, filepath.Join(t.TempDir(), "library.xml"), exportOptions)
+	assert.Nil(t, err, "Cues in the last partial second should be exported.")
+}
+
 func TestExport(t *testing.T) {
 	tests := []test{
 		{"Empty", "empty.json", "empty.xml", false},
@@ -77,8 +107,81 @@ func TestExport(t *testing.T) {
 	}
 }
 
+func TestExportColors(t *testing.T) {
+	var library lib.Library
+	liberr := library.Load(filepath.Join(jsonDirExport, "cuesLoops.json"))
+	if liberr != nil {
+		t.Fatal(liberr)
+	}
+	library.Songs[1].Color = "#F01010"
+	library.Songs[1].Cues = []lib.HotCue{{Name: "Cue 1", Offset: 1, Position: 1, Color: "#F01010"}}
+
+	tests := []struct {
+		name     string
+		snap     bool
+		cueColor string
+	}{
+		{"Exact", false, "#F01010"},
+		{"Snap", true, "#E62828"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "library.xml")
+			err := rbxml.Export(&library, path, rbxml.ExportOptions{UseUTC: true, SnapColors: test.snap})
+			assert.Nil(t, err, "Valid library export should return no errors.")
+			assert.Contains(t, string(loadXml(t, path)), `Colour="0xFF0000"`,
+				"Track colors should be written as the nearest Rekordbox track color with a 0x prefix.")
+
+			imported, err := rbxml.Import(path, rbxml.ImportOptions{})
+			assert.Nil(t, err, "Exported library should be importable.")
+			imported.SortSongs()
+			song := imported.Songs[0]
+			assert.Equal(t, library.Songs[1].SongID, song.SongID)
+			assert.Equal(t, "#FF0000", song.Color, "Track color should be imported.")
+			for _, cue := range song.Cues {
+				if cue.Position == 1 {
+					assert.Equal(t, test.cueColor, cue.Color, "Cue color should be imported.")
+				}
+			}
+		})
+	}
+}
+
+func TestExportTimeSignatures(t *testing.T) {
+	var library lib.Library
+	liberr := library.Load(filepath.Join(jsonDirExport, "cuesLoops.json"))
+	if liberr != nil {
+		t.Fatal(liberr)
+	}
+	grid := []lib.Marker{
+		{StartPosition: 0.5, Bpm: 120, BeatNumber: 2, BeatsPerBar: 3},
+		{StartPosition: 30.5, Bpm: 120, BeatsPerBar: 7, BeatUnit: 8},
+		{StartPosition: 60.5, Bpm: 120},
+	}
+	library.Songs[1].Grid = grid
+
+	path := filepath.Join(t.TempDir(), "library.xml")
+	err := rbxml.Export(&library, path, exportOptions)
+	assert.Nil(t, err, "Valid library export should return no errors.")
+	xml := string(loadXml(t, path))
+	assert.Contains(t, xml, `Metro="3/4" Battito="3"`, "3/4 markers should keep their time signature.")
+	assert.Contains(t, xml, `Metro="7/8" Battito="1"`, "7/8 markers should keep their time signature.")
+
+	imported, err := rbxml.Import(path, rbxml.ImportOptions{})
+	assert.Nil(t, err, "Exported library should be importable.")
+	imported.SortSongs()
+	song := imported.Songs[0]
+	assert.Equal(t, library.Songs[1].SongID, song.SongID)
+	assert.Len(t, song.Grid, len(grid))
+	for i, marker := range song.Grid {
+		assert.Equal(t, grid[i].TimeSignature(), marker.TimeSignature(), "Time signature should be imported.")
+		assert.Equal(t, grid[i].BeatNumber, marker.BeatNumber, "Beat in bar should be imported.")
+	}
+}
+
 func TestImportInvalidPath(t *testing.T) {
-	_, err := rbxml.Import("invalid/path/library.xml")
+	_, err := rbxml.Import("invalid/path/library.xml", rbxml.ImportOptions{})
 	assert.Equal(t, errors.New("error reading file: open invalid/path/library.xml: no such file or directory"),
 		err, "Invalid path should throw an error.")
 }
@@ -87,6 +190,7 @@ func TestImport(t *testing.T) {
 	tests := []test{
 		{"Empty", "empty.json", "empty.xml", false},
 		{"Songs", "songs.json", "songs.xml", false},
+		{"MusicalKeys", "songs.json", "musicalKeys.xml", false},
 		{"CorruptSong", "corruptSong.json", "corruptSong.xml", false},
 		{"CuesLoops", "cuesLoops.json", "cuesLoops.xml", false},
 		{"Playlists", "playlists.json", "playlists.xml", false},
@@ -96,7 +200,7 @@ func TestImport(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			path := filepath.Join(xmlDirImport, test.xmlName)
-			library, liberr := rbxml.Import(path)
+			library, liberr := rbxml.Import(path, rbxml.ImportOptions{})
 			library.SortSongs()
 			path = filepath.Join(jsonDirImport, test.jsonName)
 			if test.saveStub {
@@ -116,3 +220,53 @@ func TestImport(t *testing.T) {
 		})
 	}
 }
+
+func TestImportReadAudioFiles(t *testing.T) {
+	path := filepath.Join(xmlDirImport, "songs.xml")
+	estimated, report, err := rbxml.ImportContext(context.Background(), path, rbxml.ImportOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, report.Warnings, "Offsets shouldn't be reported without reading files.")
+
+	// the song files of the fixture don't exist, so every offset is estimated
+	read, report, err := rbxml.ImportContext(context.Background(), path, rbxml.ImportOptions{ReadAudioFiles: true})
+	assert.Nil(t, err)
+	assert.Len(t, report.Warnings, len(read.Songs), "Songs whose files can't be read should be reported.")
+	for _, warning := range report.Warnings {
+		assert.Equal(t, lib.WarningEstimatedOffset, warning.Type)
+	}
+	assert.Equal(t, estimated, read)
+}
+
+func TestImportNoNegativePositions(t *testing.T) {
+	for _, name := range []string{"songs.xml", "cuesLoops.xml", "playlists.xml", "corruptSong.xml"} {
+		t.Run(name, func(t *testing.T) {
+			library, err := rbxml.Import(filepath.Join(xmlDirImport, name), rbxml.ImportOptions{})
+			assert.Nil(t, err)
+			for _, song := range library.Songs {
+				assert.GreaterOrEqual(t, song.Cue, 0.0, "Cue of song %d shouldn't be negative.", song.SongID)
+				for _, cue := range song.Cues {
+					assert.GreaterOrEqual(t, cue.Offset, 0.0, "Hot cues of song %d shouldn't be negative.", song.SongID)
+				}
+				for _, loop := range song.Loops {
+					assert.GreaterOrEqual(t, loop.Start, 0.0, "Loops of song %d shouldn't be negative.", song.SongID)
+				}
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	path := filepath.Join(xmlDirImport, "songs.xml")
+	format, detection, err := lib.DetectFormat(path)
+	assert.Nil(t, err, "Rekordbox XML should be detected.")
+	assert.Equal(t, "rbxml", format.Name, "Rekordbox XML should be detected as rbxml.")
+	assert.Equal(t, "1.0.0", detection.Version, "Detection should read the XML version.")
+
+	tempPath := filepath.Join(t.TempDir(), "library.xml")
+	err = lib.Convert("", path, "rbxml", tempPath)
+	assert.Nil(t, err, "Valid conversion should return no errors.")
+	library, err := rbxml.Import(tempPath, rbxml.ImportOptions{})
+	assert.Nil(t, err, "Converted library should be importable.")
+	expected, _ := rbxml.Import(path, rbxml.ImportOptions{})
+	assert.Equal(t, len(expected.Songs), len(library.Songs), "Converted library should keep every song.")
+}
@@ -1,6 +1,7 @@
 package serato
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"os"
@@ -10,14 +11,15 @@ import (
 	"unicode/utf16"
 
 	"github.com/dhowden/tag"
+	"github.com/nateranda/djtools/lib"
 )
 
-func importExtract(path string) error {
+func importExtract(path string, importOptions ImportOptions) error {
 	crates, err := importExtractCrates(path)
 	if err != nil {
 		return err
 	}
-	songs, err := importExtractSongs(crates)
+	songs, err := importExtractSongs(crates, importOptions.Parallelism)
 	if err != nil {
 		return err
 	}
@@ -63,16 +65,32 @@ func importExtractCrate(path string) (crate, error) {
 	return entries, nil
 }
 
-func importExtractSongs(crates []crate) ([]song, error) {
-	var songs []song
+// importExtractSongs reads the song files of every crate concurrently, returning
+// them in crate order and the error of the first song that failed.
+func importExtractSongs(crates []crate, parallelism int) ([]song, error) {
+	var paths []string
 	for _, crate := range crates {
-		for _, path := range crate.paths {
-			song, err := importExtractSong(path)
-			if err != nil {
-				return nil, err
-			}
-			songs = append(songs, song)
+		paths = append(paths, crate.paths...)
+	}
+
+	type extracted struct {
+		song song
+		err  error
+	}
+	results, err := lib.ParallelMap(context.Background(), len(paths), parallelism, func(i int) extracted {
+		s, err := importExtractSong(paths[i])
+		return extracted{s, err}
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	songs := make([]song, len(results))
+	for i, result := range results {
+		if result.err != nil {
+			return nil, result.err
 		}
+		songs[i] = result.song
 	}
 	return songs, nil
 }
@@ -0,0 +1,77 @@
+package serato
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeSongs writes n MP3 files with an ID3v2.3 title of their index and returns their paths.
+func writeSongs(t testing.TB, n int) []string {
+	dir := t.TempDir()
+	var paths []string
+	for i := range n {
+		title := append([]byte{0}, fmt.Sprintf("Song %d", i)...)
+		frame := append([]byte("TIT2"), binary.BigEndian.AppendUint32(nil, uint32(len(title)))...)
+		frame = append(append(frame, 0, 0), title...)
+		file := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(frame))}, frame...)
+		file = append(file, 0xFF, 0xFB, 0x90, 0x00) // MPEG-1 layer III frame header
+
+		path := filepath.Join(dir, fmt.Sprintf("%d.mp3", i))
+		err := os.WriteFile(path, file, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestImportExtractSongs(t *testing.T) {
+	paths := writeSongs(t, 50)
+	crates := []crate{{paths: paths[:20]}, {paths: paths[20:]}}
+	for _, parallelism := range []int{1, 4, 0} {
+		songs, err := importExtractSongs(crates, parallelism)
+		assert.Nil(t, err)
+		if assert.Len(t, songs, len(paths)) {
+			for i, song := range songs {
+				assert.Equal(t, paths[i], song.path, "Songs should be in crate order.")
+				assert.Equal(t, fmt.Sprintf("Song %d", i), song.tags.title)
+			}
+		}
+	}
+}
+
+func TestImportExtractSongsError(t *testing.T) {
+	paths := writeSongs(t, 50)
+	for _, i := range []int{10, 40} {
+		err := os.Remove(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := importExtractSongs([]crate{{paths: paths}}, 8)
+	assert.ErrorContains(t, err, "10.mp3", "The error of the first song in crate order should be returned.")
+}
+
+func BenchmarkImportExtractSongs(b *testing.B) {
+	crates := []crate{{paths: writeSongs(b, 2000)}}
+	for _, parallelism := range []int{1, 0} {
+		name := "Serial"
+		if parallelism == 0 {
+			name = "Parallel"
+		}
+		b.Run(name, func(b *testing.B) {
+			for range b.N {
+				_, err := importExtractSongs(crates, parallelism)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,5 +1,24 @@
 package serato
 
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/nateranda/djtools/lib"
+)
+
+// ImportOptions contains the options used when importing a Serato library.
+type ImportOptions struct {
+	Parallelism int // number of song files read at once, runtime.GOMAXPROCS(0) if 0
+}
+
+// maxVersionLength is the longest vrsn entry read from a database. Versions are a few
+// dozen bytes, so longer entries are from files that aren't Serato databases.
+const maxVersionLength = 256
+
 type crate struct {
 	filename string
 	version  string
@@ -27,8 +46,73 @@ type geob struct {
 	value []byte
 }
 
-func Import(path string) error {
-	err := importExtract(path)
+func init() {
+	// import doesn't produce a library yet, so the format is only detected
+	lib.Register(lib.Format{
+		Name:        "serato",
+		Description: "Serato _Serato_ folder",
+		Capabilities: lib.Capabilities{
+			Smartlists:          true,
+			Loops:               true,
+			MultipleGridMarkers: true,
+			Colors:              true,
+		},
+		Detect: detect,
+	})
+}
+
+// detect detects a _Serato_ folder, or a drive or music folder containing one.
+// The version is read from the database V2 file if there is one.
+func detect(path string) lib.Detection {
+	candidates := []string{
+		path,
+		filepath.Join(path, "_Serato_"),
+		filepath.Join(path, "Music", "_Serato_"),
+	}
+	for _, candidate := range candidates {
+		version, err := databaseVersion(filepath.Join(candidate, "database V2"))
+		if err == nil {
+			return lib.Detection{Version: version, Confidence: 1, Path: candidate}
+		}
+		// crates without a database are likely Serato, but less certain
+		info, err := os.Stat(filepath.Join(candidate, "Subcrates"))
+		if err == nil && info.IsDir() {
+			return lib.Detection{Confidence: 0.7, Path: candidate}
+		}
+	}
+	return lib.Detection{}
+}
+
+// databaseVersion reads the version from the vrsn entry at the start of a Serato database file.
+func databaseVersion(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, 8)
+	_, err = io.ReadFull(file, header)
+	if err != nil {
+		return "", err
+	}
+	if string(header[:4]) != "vrsn" {
+		return "", fmt.Errorf("database does not start with a version entry")
+	}
+	length := binary.BigEndian.Uint32(header[4:])
+	if length > maxVersionLength {
+		return "", fmt.Errorf("database version entry is %d bytes, longer than %d", length, maxVersionLength)
+	}
+	value := make([]byte, length)
+	_, err = io.ReadFull(file, value)
+	if err != nil {
+		return "", err
+	}
+	return utf16ToString(value)
+}
+
+func Import(path string, importOptions ImportOptions) error {
+	err := importExtract(path, importOptions)
 	if err != nil {
 		return err
 	}
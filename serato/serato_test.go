@@ -0,0 +1,51 @@
+package serato
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// versionEntry returns a vrsn entry with the given length header and UTF-16 value.
+func versionEntry(length uint32, version string) []byte {
+	entry := binary.BigEndian.AppendUint32([]byte("vrsn"), length)
+	for _, unit := range utf16.Encode([]rune(version)) {
+		entry = binary.BigEndian.AppendUint16(entry, unit)
+	}
+	return entry
+}
+
+func TestDatabaseVersion(t *testing.T) {
+	version := "2.0/Serato Scratch LIVE Database"
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+		err      bool
+	}{
+		{"Valid", versionEntry(uint32(len(version)*2), version), version, false},
+		{"NoVersion", []byte("otrk\x00\x00\x00\x00"), "", true},
+		{"Truncated", versionEntry(uint32(len(version)*2), version[:10]), "", true},
+		{"TooLong", versionEntry(0xFFFFFFFF, version), "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "database V2")
+			err := os.WriteFile(path, test.data, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			version, err := databaseVersion(path)
+			if test.err {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, test.expected, version)
+		})
+	}
+}